@@ -30,16 +30,20 @@ type Lightblock_BlockType int32
 const (
 	Lightblock_NETWORK Lightblock_BlockType = 0
 	Lightblock_CLIENT  Lightblock_BlockType = 1
+	// GENESIS is the first block of a network, its payload is a Genesis message.
+	Lightblock_GENESIS Lightblock_BlockType = 2
 )
 
 var Lightblock_BlockType_name = map[int32]string{
 	0: "NETWORK",
 	1: "CLIENT",
+	2: "GENESIS",
 }
 
 var Lightblock_BlockType_value = map[string]int32{
 	"NETWORK": 0,
 	"CLIENT":  1,
+	"GENESIS": 2,
 }
 
 func (x Lightblock_BlockType) String() string {
@@ -50,15 +54,79 @@ func (Lightblock_BlockType) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_fcee3e88f49c2881, []int{0, 0}
 }
 
+type MemberState_MemberStatus int32
+
+const (
+	MemberState_ALIVE   MemberState_MemberStatus = 0
+	MemberState_SUSPECT MemberState_MemberStatus = 1
+)
+
+var MemberState_MemberStatus_name = map[int32]string{
+	0: "ALIVE",
+	1: "SUSPECT",
+}
+
+var MemberState_MemberStatus_value = map[string]int32{
+	"ALIVE":   0,
+	"SUSPECT": 1,
+}
+
+func (x MemberState_MemberStatus) String() string {
+	return proto.EnumName(MemberState_MemberStatus_name, int32(x))
+}
+
+func (MemberState_MemberStatus) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{17, 0}
+}
+
+type MembershipChange_ChangeType int32
+
+const (
+	MembershipChange_JOIN  MembershipChange_ChangeType = 0
+	MembershipChange_EVICT MembershipChange_ChangeType = 1
+	MembershipChange_LEAVE MembershipChange_ChangeType = 2
+)
+
+var MembershipChange_ChangeType_name = map[int32]string{
+	0: "JOIN",
+	1: "EVICT",
+	2: "LEAVE",
+}
+
+var MembershipChange_ChangeType_value = map[string]int32{
+	"JOIN":  0,
+	"EVICT": 1,
+	"LEAVE": 2,
+}
+
+func (x MembershipChange_ChangeType) String() string {
+	return proto.EnumName(MembershipChange_ChangeType_name, int32(x))
+}
+
+func (MembershipChange_ChangeType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{20, 0}
+}
+
 type Lightblock struct {
-	ID                   string               `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
-	Payload              []byte               `protobuf:"bytes,2,opt,name=Payload,proto3" json:"Payload,omitempty"`
-	PrevID               string               `protobuf:"bytes,3,opt,name=PrevID,proto3" json:"PrevID,omitempty"`
-	Type                 Lightblock_BlockType `protobuf:"varint,9,opt,name=Type,proto3,enum=Lightblock_BlockType" json:"Type,omitempty"`
-	LastUpdated          *timestamp.Timestamp `protobuf:"bytes,10,opt,name=last_updated,json=lastUpdated,proto3" json:"last_updated,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
-	XXX_unrecognized     []byte               `json:"-"`
-	XXX_sizecache        int32                `json:"-"`
+	ID      string `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Payload []byte `protobuf:"bytes,2,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	PrevID  string `protobuf:"bytes,3,opt,name=PrevID,proto3" json:"PrevID,omitempty"`
+	// RequestID is the client supplied idempotency key of the Persist request which created the block.
+	RequestID string `protobuf:"bytes,4,opt,name=RequestID,proto3" json:"RequestID,omitempty"`
+	// Entries hold the payloads of blocks created from multiple requests.
+	// Single payload blocks use the Payload and RequestID fields instead.
+	Entries []*BlockEntry `protobuf:"bytes,5,rep,name=Entries,proto3" json:"Entries,omitempty"`
+	// Height is the position of the block in the chain, starting with 1.
+	Height      uint64               `protobuf:"varint,6,opt,name=Height,proto3" json:"Height,omitempty"`
+	Type        Lightblock_BlockType `protobuf:"varint,9,opt,name=Type,proto3,enum=Lightblock_BlockType" json:"Type,omitempty"`
+	LastUpdated *timestamp.Timestamp `protobuf:"bytes,10,opt,name=last_updated,json=lastUpdated,proto3" json:"last_updated,omitempty"`
+	// NetworkID is the ID of the network the block belongs to, taken from the genesis block.
+	NetworkID string `protobuf:"bytes,11,opt,name=NetworkID,proto3" json:"NetworkID,omitempty"`
+	// Proposer is the member which recorded a NETWORK block. Peers only accept NETWORK blocks from members.
+	Proposer             *PeerInfo `protobuf:"bytes,12,opt,name=Proposer,proto3" json:"Proposer,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
 }
 
 func (m *Lightblock) Reset()         { *m = Lightblock{} }
@@ -107,6 +175,27 @@ func (m *Lightblock) GetPrevID() string {
 	return ""
 }
 
+func (m *Lightblock) GetRequestID() string {
+	if m != nil {
+		return m.RequestID
+	}
+	return ""
+}
+
+func (m *Lightblock) GetEntries() []*BlockEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+func (m *Lightblock) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
 func (m *Lightblock) GetType() Lightblock_BlockType {
 	if m != nil {
 		return m.Type
@@ -121,8 +210,137 @@ func (m *Lightblock) GetLastUpdated() *timestamp.Timestamp {
 	return nil
 }
 
+func (m *Lightblock) GetNetworkID() string {
+	if m != nil {
+		return m.NetworkID
+	}
+	return ""
+}
+
+func (m *Lightblock) GetProposer() *PeerInfo {
+	if m != nil {
+		return m.Proposer
+	}
+	return nil
+}
+
+// Genesis describes the network started by the genesis block.
+type Genesis struct {
+	NetworkID string               `protobuf:"bytes,1,opt,name=NetworkID,proto3" json:"NetworkID,omitempty"`
+	CreatedAt *timestamp.Timestamp `protobuf:"bytes,2,opt,name=CreatedAt,proto3" json:"CreatedAt,omitempty"`
+	// Network holds the peers which started the network.
+	Network []*PeerInfo `protobuf:"bytes,3,rep,name=Network,proto3" json:"Network,omitempty"`
+	// Config is the initial configuration of the network, as given by its creator.
+	Config               map[string]string `protobuf:"bytes,4,rep,name=Config,proto3" json:"Config,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Genesis) Reset()         { *m = Genesis{} }
+func (m *Genesis) String() string { return proto.CompactTextString(m) }
+func (*Genesis) ProtoMessage()    {}
+func (*Genesis) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{1}
+}
+
+func (m *Genesis) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Genesis.Unmarshal(m, b)
+}
+func (m *Genesis) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Genesis.Marshal(b, m, deterministic)
+}
+func (m *Genesis) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Genesis.Merge(m, src)
+}
+func (m *Genesis) XXX_Size() int {
+	return xxx_messageInfo_Genesis.Size(m)
+}
+func (m *Genesis) XXX_DiscardUnknown() {
+	xxx_messageInfo_Genesis.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Genesis proto.InternalMessageInfo
+
+func (m *Genesis) GetNetworkID() string {
+	if m != nil {
+		return m.NetworkID
+	}
+	return ""
+}
+
+func (m *Genesis) GetCreatedAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return nil
+}
+
+func (m *Genesis) GetNetwork() []*PeerInfo {
+	if m != nil {
+		return m.Network
+	}
+	return nil
+}
+
+func (m *Genesis) GetConfig() map[string]string {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+type BlockEntry struct {
+	Payload              []byte   `protobuf:"bytes,1,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	RequestID            string   `protobuf:"bytes,2,opt,name=RequestID,proto3" json:"RequestID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BlockEntry) Reset()         { *m = BlockEntry{} }
+func (m *BlockEntry) String() string { return proto.CompactTextString(m) }
+func (*BlockEntry) ProtoMessage()    {}
+func (*BlockEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{2}
+}
+
+func (m *BlockEntry) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlockEntry.Unmarshal(m, b)
+}
+func (m *BlockEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlockEntry.Marshal(b, m, deterministic)
+}
+func (m *BlockEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlockEntry.Merge(m, src)
+}
+func (m *BlockEntry) XXX_Size() int {
+	return xxx_messageInfo_BlockEntry.Size(m)
+}
+func (m *BlockEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlockEntry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlockEntry proto.InternalMessageInfo
+
+func (m *BlockEntry) GetPayload() []byte {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (m *BlockEntry) GetRequestID() string {
+	if m != nil {
+		return m.RequestID
+	}
+	return ""
+}
+
 type JoinRequest struct {
-	Address              string   `protobuf:"bytes,1,opt,name=Address,proto3" json:"Address,omitempty"`
+	Address string `protobuf:"bytes,1,opt,name=Address,proto3" json:"Address,omitempty"`
+	// Token is passed on to the peer at Address, for admission into its network.
+	Token                string   `protobuf:"bytes,2,opt,name=Token,proto3" json:"Token,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -132,7 +350,7 @@ func (m *JoinRequest) Reset()         { *m = JoinRequest{} }
 func (m *JoinRequest) String() string { return proto.CompactTextString(m) }
 func (*JoinRequest) ProtoMessage()    {}
 func (*JoinRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_fcee3e88f49c2881, []int{1}
+	return fileDescriptor_fcee3e88f49c2881, []int{3}
 }
 
 func (m *JoinRequest) XXX_Unmarshal(b []byte) error {
@@ -160,6 +378,75 @@ func (m *JoinRequest) GetAddress() string {
 	return ""
 }
 
+func (m *JoinRequest) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+type LeaveRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeaveRequest) Reset()         { *m = LeaveRequest{} }
+func (m *LeaveRequest) String() string { return proto.CompactTextString(m) }
+func (*LeaveRequest) ProtoMessage()    {}
+func (*LeaveRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{4}
+}
+
+func (m *LeaveRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeaveRequest.Unmarshal(m, b)
+}
+func (m *LeaveRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeaveRequest.Marshal(b, m, deterministic)
+}
+func (m *LeaveRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaveRequest.Merge(m, src)
+}
+func (m *LeaveRequest) XXX_Size() int {
+	return xxx_messageInfo_LeaveRequest.Size(m)
+}
+func (m *LeaveRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaveRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeaveRequest proto.InternalMessageInfo
+
+type LeaveResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeaveResponse) Reset()         { *m = LeaveResponse{} }
+func (m *LeaveResponse) String() string { return proto.CompactTextString(m) }
+func (*LeaveResponse) ProtoMessage()    {}
+func (*LeaveResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{5}
+}
+
+func (m *LeaveResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeaveResponse.Unmarshal(m, b)
+}
+func (m *LeaveResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeaveResponse.Marshal(b, m, deterministic)
+}
+func (m *LeaveResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaveResponse.Merge(m, src)
+}
+func (m *LeaveResponse) XXX_Size() int {
+	return xxx_messageInfo_LeaveResponse.Size(m)
+}
+func (m *LeaveResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaveResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeaveResponse proto.InternalMessageInfo
+
 type JoinResponse struct {
 	Result               string   `protobuf:"bytes,1,opt,name=Result,proto3" json:"Result,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -171,7 +458,7 @@ func (m *JoinResponse) Reset()         { *m = JoinResponse{} }
 func (m *JoinResponse) String() string { return proto.CompactTextString(m) }
 func (*JoinResponse) ProtoMessage()    {}
 func (*JoinResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_fcee3e88f49c2881, []int{2}
+	return fileDescriptor_fcee3e88f49c2881, []int{6}
 }
 
 func (m *JoinResponse) XXX_Unmarshal(b []byte) error {
@@ -200,17 +487,21 @@ func (m *JoinResponse) GetResult() string {
 }
 
 type ConnectRequest struct {
-	Peer                 *PeerInfo `protobuf:"bytes,1,opt,name=Peer,proto3" json:"Peer,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
-	XXX_unrecognized     []byte    `json:"-"`
-	XXX_sizecache        int32     `json:"-"`
+	Peer *PeerInfo `protobuf:"bytes,1,opt,name=Peer,proto3" json:"Peer,omitempty"`
+	// NetworkID is the network the peer belongs to. Peers without a network ID join any network.
+	NetworkID string `protobuf:"bytes,2,opt,name=NetworkID,proto3" json:"NetworkID,omitempty"`
+	// Token is the join token checked by the admission policy of the network.
+	Token                string   `protobuf:"bytes,3,opt,name=Token,proto3" json:"Token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *ConnectRequest) Reset()         { *m = ConnectRequest{} }
 func (m *ConnectRequest) String() string { return proto.CompactTextString(m) }
 func (*ConnectRequest) ProtoMessage()    {}
 func (*ConnectRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_fcee3e88f49c2881, []int{3}
+	return fileDescriptor_fcee3e88f49c2881, []int{7}
 }
 
 func (m *ConnectRequest) XXX_Unmarshal(b []byte) error {
@@ -238,9 +529,25 @@ func (m *ConnectRequest) GetPeer() *PeerInfo {
 	return nil
 }
 
+func (m *ConnectRequest) GetNetworkID() string {
+	if m != nil {
+		return m.NetworkID
+	}
+	return ""
+}
+
+func (m *ConnectRequest) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
 type PeerInfo struct {
-	Address              string   `protobuf:"bytes,1,opt,name=Address,proto3" json:"Address,omitempty"`
-	Name                 string   `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	Address string `protobuf:"bytes,1,opt,name=Address,proto3" json:"Address,omitempty"`
+	Name    string `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	// ID is the stable identity of the peer, which survives address changes across restarts.
+	ID                   string   `protobuf:"bytes,3,opt,name=ID,proto3" json:"ID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -250,7 +557,7 @@ func (m *PeerInfo) Reset()         { *m = PeerInfo{} }
 func (m *PeerInfo) String() string { return proto.CompactTextString(m) }
 func (*PeerInfo) ProtoMessage()    {}
 func (*PeerInfo) Descriptor() ([]byte, []int) {
-	return fileDescriptor_fcee3e88f49c2881, []int{4}
+	return fileDescriptor_fcee3e88f49c2881, []int{8}
 }
 
 func (m *PeerInfo) XXX_Unmarshal(b []byte) error {
@@ -285,8 +592,18 @@ func (m *PeerInfo) GetName() string {
 	return ""
 }
 
+func (m *PeerInfo) GetID() string {
+	if m != nil {
+		return m.ID
+	}
+	return ""
+}
+
 type PersistRequest struct {
-	Payload              []byte   `protobuf:"bytes,1,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	Payload []byte `protobuf:"bytes,1,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	// RequestID is an optional idempotency key. Retrying a request with the same ID
+	// returns the receipt of the original request instead of persisting the payload twice.
+	RequestID            string   `protobuf:"bytes,2,opt,name=RequestID,proto3" json:"RequestID,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -296,7 +613,7 @@ func (m *PersistRequest) Reset()         { *m = PersistRequest{} }
 func (m *PersistRequest) String() string { return proto.CompactTextString(m) }
 func (*PersistRequest) ProtoMessage()    {}
 func (*PersistRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_fcee3e88f49c2881, []int{5}
+	return fileDescriptor_fcee3e88f49c2881, []int{9}
 }
 
 func (m *PersistRequest) XXX_Unmarshal(b []byte) error {
@@ -324,8 +641,67 @@ func (m *PersistRequest) GetPayload() []byte {
 	return nil
 }
 
+func (m *PersistRequest) GetRequestID() string {
+	if m != nil {
+		return m.RequestID
+	}
+	return ""
+}
+
+type PersistBatchRequest struct {
+	Payloads [][]byte `protobuf:"bytes,1,rep,name=Payloads,proto3" json:"Payloads,omitempty"`
+	// RequestID is an optional idempotency key, see PersistRequest.
+	RequestID            string   `protobuf:"bytes,2,opt,name=RequestID,proto3" json:"RequestID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PersistBatchRequest) Reset()         { *m = PersistBatchRequest{} }
+func (m *PersistBatchRequest) String() string { return proto.CompactTextString(m) }
+func (*PersistBatchRequest) ProtoMessage()    {}
+func (*PersistBatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{10}
+}
+
+func (m *PersistBatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PersistBatchRequest.Unmarshal(m, b)
+}
+func (m *PersistBatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PersistBatchRequest.Marshal(b, m, deterministic)
+}
+func (m *PersistBatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PersistBatchRequest.Merge(m, src)
+}
+func (m *PersistBatchRequest) XXX_Size() int {
+	return xxx_messageInfo_PersistBatchRequest.Size(m)
+}
+func (m *PersistBatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PersistBatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PersistBatchRequest proto.InternalMessageInfo
+
+func (m *PersistBatchRequest) GetPayloads() [][]byte {
+	if m != nil {
+		return m.Payloads
+	}
+	return nil
+}
+
+func (m *PersistBatchRequest) GetRequestID() string {
+	if m != nil {
+		return m.RequestID
+	}
+	return ""
+}
+
 type PersistResponse struct {
-	Response             string   `protobuf:"bytes,1,opt,name=Response,proto3" json:"Response,omitempty"`
+	Response string `protobuf:"bytes,1,opt,name=Response,proto3" json:"Response,omitempty"`
+	// BlockID is the ID of the block holding the persisted payload.
+	BlockID string `protobuf:"bytes,2,opt,name=BlockID,proto3" json:"BlockID,omitempty"`
+	// Index is the position of the payload in the block entries, for blocks created by a group commit.
+	Index                int32    `protobuf:"varint,3,opt,name=Index,proto3" json:"Index,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -335,7 +711,7 @@ func (m *PersistResponse) Reset()         { *m = PersistResponse{} }
 func (m *PersistResponse) String() string { return proto.CompactTextString(m) }
 func (*PersistResponse) ProtoMessage()    {}
 func (*PersistResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_fcee3e88f49c2881, []int{6}
+	return fileDescriptor_fcee3e88f49c2881, []int{11}
 }
 
 func (m *PersistResponse) XXX_Unmarshal(b []byte) error {
@@ -363,6 +739,20 @@ func (m *PersistResponse) GetResponse() string {
 	return ""
 }
 
+func (m *PersistResponse) GetBlockID() string {
+	if m != nil {
+		return m.BlockID
+	}
+	return ""
+}
+
+func (m *PersistResponse) GetIndex() int32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
 type EmptyQueryRequest struct {
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -373,7 +763,7 @@ func (m *EmptyQueryRequest) Reset()         { *m = EmptyQueryRequest{} }
 func (m *EmptyQueryRequest) String() string { return proto.CompactTextString(m) }
 func (*EmptyQueryRequest) ProtoMessage()    {}
 func (*EmptyQueryRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_fcee3e88f49c2881, []int{7}
+	return fileDescriptor_fcee3e88f49c2881, []int{12}
 }
 
 func (m *EmptyQueryRequest) XXX_Unmarshal(b []byte) error {
@@ -395,7 +785,13 @@ func (m *EmptyQueryRequest) XXX_DiscardUnknown() {
 var xxx_messageInfo_EmptyQueryRequest proto.InternalMessageInfo
 
 type QueryResponse struct {
-	Payload              []byte   `protobuf:"bytes,1,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	Payload []byte `protobuf:"bytes,1,opt,name=Payload,proto3" json:"Payload,omitempty"`
+	// BlockID is the block holding the payload. Payloads persisted together share the same block.
+	BlockID string `protobuf:"bytes,2,opt,name=BlockID,proto3" json:"BlockID,omitempty"`
+	// Index is the position of the payload inside the block.
+	Index int32 `protobuf:"varint,3,opt,name=Index,proto3" json:"Index,omitempty"`
+	// BlockSize is the number of payloads stored in the block.
+	BlockSize            int32    `protobuf:"varint,4,opt,name=BlockSize,proto3" json:"BlockSize,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -405,7 +801,7 @@ func (m *QueryResponse) Reset()         { *m = QueryResponse{} }
 func (m *QueryResponse) String() string { return proto.CompactTextString(m) }
 func (*QueryResponse) ProtoMessage()    {}
 func (*QueryResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_fcee3e88f49c2881, []int{8}
+	return fileDescriptor_fcee3e88f49c2881, []int{13}
 }
 
 func (m *QueryResponse) XXX_Unmarshal(b []byte) error {
@@ -433,6 +829,27 @@ func (m *QueryResponse) GetPayload() []byte {
 	return nil
 }
 
+func (m *QueryResponse) GetBlockID() string {
+	if m != nil {
+		return m.BlockID
+	}
+	return ""
+}
+
+func (m *QueryResponse) GetIndex() int32 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *QueryResponse) GetBlockSize() int32 {
+	if m != nil {
+		return m.BlockSize
+	}
+	return 0
+}
+
 type NewBlockResponse struct {
 	Response             string   `protobuf:"bytes,1,opt,name=Response,proto3" json:"Response,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -444,7 +861,7 @@ func (m *NewBlockResponse) Reset()         { *m = NewBlockResponse{} }
 func (m *NewBlockResponse) String() string { return proto.CompactTextString(m) }
 func (*NewBlockResponse) ProtoMessage()    {}
 func (*NewBlockResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_fcee3e88f49c2881, []int{9}
+	return fileDescriptor_fcee3e88f49c2881, []int{14}
 }
 
 func (m *NewBlockResponse) XXX_Unmarshal(b []byte) error {
@@ -472,62 +889,1336 @@ func (m *NewBlockResponse) GetResponse() string {
 	return ""
 }
 
-func init() {
-	proto.RegisterEnum("Lightblock_BlockType", Lightblock_BlockType_name, Lightblock_BlockType_value)
-	proto.RegisterType((*Lightblock)(nil), "Lightblock")
-	proto.RegisterType((*JoinRequest)(nil), "JoinRequest")
-	proto.RegisterType((*JoinResponse)(nil), "JoinResponse")
-	proto.RegisterType((*ConnectRequest)(nil), "ConnectRequest")
-	proto.RegisterType((*PeerInfo)(nil), "PeerInfo")
-	proto.RegisterType((*PersistRequest)(nil), "PersistRequest")
-	proto.RegisterType((*PersistResponse)(nil), "PersistResponse")
-	proto.RegisterType((*EmptyQueryRequest)(nil), "EmptyQueryRequest")
-	proto.RegisterType((*QueryResponse)(nil), "QueryResponse")
-	proto.RegisterType((*NewBlockResponse)(nil), "NewBlockResponse")
+type ReplicationMessage struct {
+	Sequence             uint64      `protobuf:"varint,1,opt,name=Sequence,proto3" json:"Sequence,omitempty"`
+	Block                *Lightblock `protobuf:"bytes,2,opt,name=Block,proto3" json:"Block,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
 }
 
-func init() {
-	proto.RegisterFile("lightpeer.proto", fileDescriptor_fcee3e88f49c2881)
+func (m *ReplicationMessage) Reset()         { *m = ReplicationMessage{} }
+func (m *ReplicationMessage) String() string { return proto.CompactTextString(m) }
+func (*ReplicationMessage) ProtoMessage()    {}
+func (*ReplicationMessage) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{15}
 }
 
-var fileDescriptor_fcee3e88f49c2881 = []byte{
-	// 500 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x8c, 0x53, 0x4d, 0x8f, 0xd3, 0x30,
-	0x10, 0x6d, 0x4a, 0xe9, 0x6e, 0x26, 0xfd, 0x5a, 0x23, 0x50, 0x14, 0x09, 0x81, 0x2c, 0x04, 0x2c,
-	0x02, 0x07, 0x85, 0x0b, 0x17, 0x0e, 0xec, 0xd2, 0x43, 0xa1, 0x0a, 0x25, 0x2a, 0x42, 0xe2, 0x82,
-	0xd2, 0x8d, 0x5b, 0xa2, 0x4d, 0xe3, 0x90, 0x38, 0xac, 0x72, 0xe5, 0x87, 0xf2, 0x5b, 0x70, 0x1c,
-	0x3b, 0xa4, 0x8b, 0x56, 0xe2, 0x52, 0xf9, 0x4d, 0x9f, 0xe7, 0xbd, 0x79, 0xe3, 0xc0, 0x34, 0x89,
-	0x77, 0xdf, 0x79, 0x46, 0x69, 0x4e, 0xb2, 0x9c, 0x71, 0xe6, 0x3c, 0xd8, 0x31, 0xb6, 0x4b, 0xa8,
-	0x2b, 0xd1, 0xa6, 0xdc, 0xba, 0x3c, 0xde, 0xd3, 0x82, 0x87, 0xfb, 0xac, 0x21, 0xe0, 0xdf, 0x06,
-	0xc0, 0xb2, 0xbe, 0xb4, 0x49, 0xd8, 0xc5, 0x25, 0x9a, 0x40, 0x7f, 0xf1, 0xce, 0x36, 0x1e, 0x1a,
-	0x4f, 0xcd, 0x40, 0x9c, 0x90, 0x0d, 0x47, 0xab, 0xb0, 0x4a, 0x58, 0x18, 0xd9, 0x7d, 0x51, 0x1c,
-	0x05, 0x1a, 0xa2, 0x7b, 0x30, 0x5c, 0xe5, 0xf4, 0xa7, 0x60, 0xdf, 0x92, 0x6c, 0x85, 0xd0, 0x29,
-	0x0c, 0xd6, 0x55, 0x46, 0x6d, 0x53, 0x54, 0x27, 0xde, 0x5d, 0xf2, 0xb7, 0x39, 0x39, 0xab, 0x7f,
-	0xeb, 0x3f, 0x03, 0x49, 0x41, 0x6f, 0x60, 0x94, 0x84, 0x05, 0xff, 0x56, 0x66, 0x51, 0xc8, 0x69,
-	0x64, 0x83, 0xb8, 0x62, 0x79, 0x0e, 0x69, 0x3c, 0x13, 0xed, 0x99, 0xac, 0xb5, 0xe7, 0xc0, 0xaa,
-	0xf9, 0x9f, 0x1b, 0x3a, 0x7e, 0x04, 0x66, 0xdb, 0x11, 0x59, 0x70, 0xe4, 0xcf, 0xd7, 0x5f, 0x3e,
-	0x06, 0x1f, 0x66, 0x3d, 0x04, 0x30, 0x3c, 0x5f, 0x2e, 0xe6, 0xfe, 0x7a, 0x66, 0xe0, 0x27, 0x60,
-	0xbd, 0x67, 0x71, 0x1a, 0xd0, 0x1f, 0xa5, 0x68, 0x52, 0x0f, 0xf4, 0x36, 0x8a, 0x72, 0x5a, 0x14,
-	0x6a, 0x4a, 0x0d, 0xf1, 0x63, 0x18, 0x35, 0xc4, 0x22, 0x63, 0x69, 0x41, 0xeb, 0x01, 0xc5, 0xb9,
-	0x4c, 0xb8, 0x22, 0x2a, 0x84, 0x5d, 0x98, 0x9c, 0xb3, 0x34, 0xa5, 0x17, 0x5c, 0xf7, 0xbc, 0x0f,
-	0x83, 0x95, 0x88, 0x5c, 0xf2, 0x2c, 0xcf, 0x24, 0x35, 0x58, 0xa4, 0x5b, 0x16, 0xc8, 0x32, 0x7e,
-	0x0d, 0xc7, 0xba, 0x72, 0xb3, 0x3c, 0x42, 0x30, 0xf0, 0xc3, 0x3d, 0x95, 0x31, 0x9b, 0x81, 0x3c,
-	0xe3, 0x67, 0x30, 0x59, 0xd1, 0xbc, 0x88, 0x0b, 0xde, 0xb1, 0xaf, 0xf7, 0x61, 0x1c, 0xec, 0x03,
-	0xbf, 0x80, 0x69, 0xcb, 0x55, 0x13, 0x38, 0x70, 0xac, 0xcf, 0x4a, 0xad, 0xc5, 0xf8, 0x0e, 0x9c,
-	0xcc, 0xf7, 0x19, 0xaf, 0x3e, 0x95, 0x34, 0xaf, 0x54, 0x77, 0x7c, 0x0a, 0x63, 0x85, 0x55, 0x87,
-	0x9b, 0xe5, 0x08, 0xcc, 0x7c, 0x7a, 0x25, 0xf3, 0xff, 0x1f, 0x3d, 0xef, 0x57, 0x1f, 0xcc, 0xa5,
-	0x7e, 0x9c, 0xe8, 0x79, 0xb3, 0x14, 0x9f, 0xf2, 0x2b, 0x96, 0x5f, 0xa2, 0x11, 0xe9, 0xac, 0xc8,
-	0x19, 0x93, 0xee, 0x1e, 0x70, 0x0f, 0x79, 0x6d, 0xe2, 0x42, 0xb2, 0x8e, 0x12, 0x4d, 0xc9, 0xe1,
-	0x0a, 0x1c, 0xab, 0xf3, 0xce, 0x70, 0xef, 0xa5, 0x81, 0x88, 0x70, 0xde, 0xc4, 0x21, 0xc8, 0x87,
-	0x21, 0x3a, 0x33, 0x72, 0x2d, 0x29, 0xa1, 0xe1, 0xc2, 0x6d, 0x39, 0x3a, 0x42, 0xe4, 0x9f, 0x5c,
-	0x9c, 0x09, 0x39, 0x88, 0x45, 0x0a, 0x08, 0x53, 0x3e, 0xe3, 0xf1, 0xb6, 0xd2, 0x31, 0xa0, 0xae,
-	0x07, 0xe7, 0x84, 0x5c, 0x8f, 0x07, 0xf7, 0xce, 0xa6, 0x5f, 0xc7, 0x61, 0x16, 0xbb, 0xed, 0x47,
-	0xba, 0x19, 0xca, 0x37, 0xfe, 0xea, 0x4f, 0x00, 0x00, 0x00, 0xff, 0xff, 0xc1, 0xab, 0x1c, 0xfc,
-	0xb8, 0x03, 0x00, 0x00,
+func (m *ReplicationMessage) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReplicationMessage.Unmarshal(m, b)
+}
+func (m *ReplicationMessage) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReplicationMessage.Marshal(b, m, deterministic)
+}
+func (m *ReplicationMessage) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReplicationMessage.Merge(m, src)
+}
+func (m *ReplicationMessage) XXX_Size() int {
+	return xxx_messageInfo_ReplicationMessage.Size(m)
+}
+func (m *ReplicationMessage) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReplicationMessage.DiscardUnknown(m)
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
+var xxx_messageInfo_ReplicationMessage proto.InternalMessageInfo
+
+func (m *ReplicationMessage) GetSequence() uint64 {
+	if m != nil {
+		return m.Sequence
+	}
+	return 0
+}
+
+func (m *ReplicationMessage) GetBlock() *Lightblock {
+	if m != nil {
+		return m.Block
+	}
+	return nil
+}
+
+type ReplicationAck struct {
+	Sequence uint64 `protobuf:"varint,1,opt,name=Sequence,proto3" json:"Sequence,omitempty"`
+	// Error is set when the peer rejected the block.
+	Error string `protobuf:"bytes,2,opt,name=Error,proto3" json:"Error,omitempty"`
+	// MissingBlockID is set when the peer holds the block back until it receives its missing parent.
+	MissingBlockID       string   `protobuf:"bytes,3,opt,name=MissingBlockID,proto3" json:"MissingBlockID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReplicationAck) Reset()         { *m = ReplicationAck{} }
+func (m *ReplicationAck) String() string { return proto.CompactTextString(m) }
+func (*ReplicationAck) ProtoMessage()    {}
+func (*ReplicationAck) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{16}
+}
+
+func (m *ReplicationAck) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReplicationAck.Unmarshal(m, b)
+}
+func (m *ReplicationAck) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReplicationAck.Marshal(b, m, deterministic)
+}
+func (m *ReplicationAck) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReplicationAck.Merge(m, src)
+}
+func (m *ReplicationAck) XXX_Size() int {
+	return xxx_messageInfo_ReplicationAck.Size(m)
+}
+func (m *ReplicationAck) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReplicationAck.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReplicationAck proto.InternalMessageInfo
+
+func (m *ReplicationAck) GetSequence() uint64 {
+	if m != nil {
+		return m.Sequence
+	}
+	return 0
+}
+
+func (m *ReplicationAck) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *ReplicationAck) GetMissingBlockID() string {
+	if m != nil {
+		return m.MissingBlockID
+	}
+	return ""
+}
+
+type MemberState struct {
+	Address string                   `protobuf:"bytes,1,opt,name=Address,proto3" json:"Address,omitempty"`
+	Status  MemberState_MemberStatus `protobuf:"varint,2,opt,name=Status,proto3,enum=MemberState_MemberStatus" json:"Status,omitempty"`
+	// Incarnation is increased by the member itself to refute being suspected.
+	Incarnation          uint64   `protobuf:"varint,3,opt,name=Incarnation,proto3" json:"Incarnation,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MemberState) Reset()         { *m = MemberState{} }
+func (m *MemberState) String() string { return proto.CompactTextString(m) }
+func (*MemberState) ProtoMessage()    {}
+func (*MemberState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{17}
+}
+
+func (m *MemberState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MemberState.Unmarshal(m, b)
+}
+func (m *MemberState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MemberState.Marshal(b, m, deterministic)
+}
+func (m *MemberState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MemberState.Merge(m, src)
+}
+func (m *MemberState) XXX_Size() int {
+	return xxx_messageInfo_MemberState.Size(m)
+}
+func (m *MemberState) XXX_DiscardUnknown() {
+	xxx_messageInfo_MemberState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MemberState proto.InternalMessageInfo
+
+func (m *MemberState) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+func (m *MemberState) GetStatus() MemberState_MemberStatus {
+	if m != nil {
+		return m.Status
+	}
+	return MemberState_ALIVE
+}
+
+func (m *MemberState) GetIncarnation() uint64 {
+	if m != nil {
+		return m.Incarnation
+	}
+	return 0
+}
+
+type ProbeRequest struct {
+	// Target is set for indirect probes.
+	Target               string         `protobuf:"bytes,1,opt,name=Target,proto3" json:"Target,omitempty"`
+	Members              []*MemberState `protobuf:"bytes,2,rep,name=Members,proto3" json:"Members,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *ProbeRequest) Reset()         { *m = ProbeRequest{} }
+func (m *ProbeRequest) String() string { return proto.CompactTextString(m) }
+func (*ProbeRequest) ProtoMessage()    {}
+func (*ProbeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{18}
+}
+
+func (m *ProbeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ProbeRequest.Unmarshal(m, b)
+}
+func (m *ProbeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ProbeRequest.Marshal(b, m, deterministic)
+}
+func (m *ProbeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ProbeRequest.Merge(m, src)
+}
+func (m *ProbeRequest) XXX_Size() int {
+	return xxx_messageInfo_ProbeRequest.Size(m)
+}
+func (m *ProbeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ProbeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ProbeRequest proto.InternalMessageInfo
+
+func (m *ProbeRequest) GetTarget() string {
+	if m != nil {
+		return m.Target
+	}
+	return ""
+}
+
+func (m *ProbeRequest) GetMembers() []*MemberState {
+	if m != nil {
+		return m.Members
+	}
+	return nil
+}
+
+type ProbeResponse struct {
+	// Ack tells whether the probed peer answered.
+	Ack bool `protobuf:"varint,1,opt,name=Ack,proto3" json:"Ack,omitempty"`
+	// Incarnation of the probed peer.
+	Incarnation uint64         `protobuf:"varint,2,opt,name=Incarnation,proto3" json:"Incarnation,omitempty"`
+	Members     []*MemberState `protobuf:"bytes,3,rep,name=Members,proto3" json:"Members,omitempty"`
+	// NetworkHeight is the height of the latest NETWORK block applied by the peer which answered.
+	NetworkHeight        uint64   `protobuf:"varint,4,opt,name=NetworkHeight,proto3" json:"NetworkHeight,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ProbeResponse) Reset()         { *m = ProbeResponse{} }
+func (m *ProbeResponse) String() string { return proto.CompactTextString(m) }
+func (*ProbeResponse) ProtoMessage()    {}
+func (*ProbeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{19}
+}
+
+func (m *ProbeResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ProbeResponse.Unmarshal(m, b)
+}
+func (m *ProbeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ProbeResponse.Marshal(b, m, deterministic)
+}
+func (m *ProbeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ProbeResponse.Merge(m, src)
+}
+func (m *ProbeResponse) XXX_Size() int {
+	return xxx_messageInfo_ProbeResponse.Size(m)
+}
+func (m *ProbeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ProbeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ProbeResponse proto.InternalMessageInfo
+
+func (m *ProbeResponse) GetAck() bool {
+	if m != nil {
+		return m.Ack
+	}
+	return false
+}
+
+func (m *ProbeResponse) GetIncarnation() uint64 {
+	if m != nil {
+		return m.Incarnation
+	}
+	return 0
+}
+
+func (m *ProbeResponse) GetMembers() []*MemberState {
+	if m != nil {
+		return m.Members
+	}
+	return nil
+}
+
+func (m *ProbeResponse) GetNetworkHeight() uint64 {
+	if m != nil {
+		return m.NetworkHeight
+	}
+	return 0
+}
+
+type MembershipChange struct {
+	Type  MembershipChange_ChangeType `protobuf:"varint,1,opt,name=Type,proto3,enum=MembershipChange_ChangeType" json:"Type,omitempty"`
+	Peers []*PeerInfo                 `protobuf:"bytes,2,rep,name=Peers,proto3" json:"Peers,omitempty"`
+	// Token is the join token presented by the joining peers, which the coordinator checks against its
+	// admission policy.
+	Token string `protobuf:"bytes,3,opt,name=Token,proto3" json:"Token,omitempty"`
+	// Proposer is the member asking the coordinator for the change.
+	Proposer             *PeerInfo `protobuf:"bytes,4,opt,name=Proposer,proto3" json:"Proposer,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *MembershipChange) Reset()         { *m = MembershipChange{} }
+func (m *MembershipChange) String() string { return proto.CompactTextString(m) }
+func (*MembershipChange) ProtoMessage()    {}
+func (*MembershipChange) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{20}
+}
+
+func (m *MembershipChange) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MembershipChange.Unmarshal(m, b)
+}
+func (m *MembershipChange) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MembershipChange.Marshal(b, m, deterministic)
+}
+func (m *MembershipChange) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MembershipChange.Merge(m, src)
+}
+func (m *MembershipChange) XXX_Size() int {
+	return xxx_messageInfo_MembershipChange.Size(m)
+}
+func (m *MembershipChange) XXX_DiscardUnknown() {
+	xxx_messageInfo_MembershipChange.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MembershipChange proto.InternalMessageInfo
+
+func (m *MembershipChange) GetType() MembershipChange_ChangeType {
+	if m != nil {
+		return m.Type
+	}
+	return MembershipChange_JOIN
+}
+
+func (m *MembershipChange) GetPeers() []*PeerInfo {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+func (m *MembershipChange) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+func (m *MembershipChange) GetProposer() *PeerInfo {
+	if m != nil {
+		return m.Proposer
+	}
+	return nil
+}
+
+type MembershipChangeResponse struct {
+	// BlockID is the NETWORK block recording the change. It is empty when the membership did not change.
+	BlockID              string   `protobuf:"bytes,1,opt,name=BlockID,proto3" json:"BlockID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MembershipChangeResponse) Reset()         { *m = MembershipChangeResponse{} }
+func (m *MembershipChangeResponse) String() string { return proto.CompactTextString(m) }
+func (*MembershipChangeResponse) ProtoMessage()    {}
+func (*MembershipChangeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{21}
+}
+
+func (m *MembershipChangeResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MembershipChangeResponse.Unmarshal(m, b)
+}
+func (m *MembershipChangeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MembershipChangeResponse.Marshal(b, m, deterministic)
+}
+func (m *MembershipChangeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MembershipChangeResponse.Merge(m, src)
+}
+func (m *MembershipChangeResponse) XXX_Size() int {
+	return xxx_messageInfo_MembershipChangeResponse.Size(m)
+}
+func (m *MembershipChangeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_MembershipChangeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MembershipChangeResponse proto.InternalMessageInfo
+
+func (m *MembershipChangeResponse) GetBlockID() string {
+	if m != nil {
+		return m.BlockID
+	}
+	return ""
+}
+
+type StatusRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StatusRequest) Reset()         { *m = StatusRequest{} }
+func (m *StatusRequest) String() string { return proto.CompactTextString(m) }
+func (*StatusRequest) ProtoMessage()    {}
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{22}
+}
+
+func (m *StatusRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StatusRequest.Unmarshal(m, b)
+}
+func (m *StatusRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StatusRequest.Marshal(b, m, deterministic)
+}
+func (m *StatusRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StatusRequest.Merge(m, src)
+}
+func (m *StatusRequest) XXX_Size() int {
+	return xxx_messageInfo_StatusRequest.Size(m)
+}
+func (m *StatusRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_StatusRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StatusRequest proto.InternalMessageInfo
+
+type StatusResponse struct {
+	Peer    *PeerInfo `protobuf:"bytes,1,opt,name=Peer,proto3" json:"Peer,omitempty"`
+	Version string    `protobuf:"bytes,2,opt,name=Version,proto3" json:"Version,omitempty"`
+	HeadID  string    `protobuf:"bytes,3,opt,name=HeadID,proto3" json:"HeadID,omitempty"`
+	Height  uint64    `protobuf:"varint,4,opt,name=Height,proto3" json:"Height,omitempty"`
+	// StorageBytes is the disk space used by the blocks and queues of the peer.
+	StorageBytes         int64         `protobuf:"varint,5,opt,name=StorageBytes,proto3" json:"StorageBytes,omitempty"`
+	Coordinator          string        `protobuf:"bytes,6,opt,name=Coordinator,proto3" json:"Coordinator,omitempty"`
+	Members              []*PeerStatus `protobuf:"bytes,7,rep,name=Members,proto3" json:"Members,omitempty"`
+	NetworkID            string        `protobuf:"bytes,8,opt,name=NetworkID,proto3" json:"NetworkID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *StatusResponse) Reset()         { *m = StatusResponse{} }
+func (m *StatusResponse) String() string { return proto.CompactTextString(m) }
+func (*StatusResponse) ProtoMessage()    {}
+func (*StatusResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{23}
+}
+
+func (m *StatusResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StatusResponse.Unmarshal(m, b)
+}
+func (m *StatusResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StatusResponse.Marshal(b, m, deterministic)
+}
+func (m *StatusResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StatusResponse.Merge(m, src)
+}
+func (m *StatusResponse) XXX_Size() int {
+	return xxx_messageInfo_StatusResponse.Size(m)
+}
+func (m *StatusResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_StatusResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StatusResponse proto.InternalMessageInfo
+
+func (m *StatusResponse) GetPeer() *PeerInfo {
+	if m != nil {
+		return m.Peer
+	}
+	return nil
+}
+
+func (m *StatusResponse) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *StatusResponse) GetHeadID() string {
+	if m != nil {
+		return m.HeadID
+	}
+	return ""
+}
+
+func (m *StatusResponse) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *StatusResponse) GetStorageBytes() int64 {
+	if m != nil {
+		return m.StorageBytes
+	}
+	return 0
+}
+
+func (m *StatusResponse) GetCoordinator() string {
+	if m != nil {
+		return m.Coordinator
+	}
+	return ""
+}
+
+func (m *StatusResponse) GetMembers() []*PeerStatus {
+	if m != nil {
+		return m.Members
+	}
+	return nil
+}
+
+func (m *StatusResponse) GetNetworkID() string {
+	if m != nil {
+		return m.NetworkID
+	}
+	return ""
+}
+
+type PeerStatus struct {
+	Peer        *PeerInfo                `protobuf:"bytes,1,opt,name=Peer,proto3" json:"Peer,omitempty"`
+	Status      MemberState_MemberStatus `protobuf:"varint,2,opt,name=Status,proto3,enum=MemberState_MemberStatus" json:"Status,omitempty"`
+	Incarnation uint64                   `protobuf:"varint,3,opt,name=Incarnation,proto3" json:"Incarnation,omitempty"`
+	// LastSeen is the last time the peer answered a probe. It is not set for peers which were not probed yet.
+	LastSeen *timestamp.Timestamp `protobuf:"bytes,4,opt,name=LastSeen,proto3" json:"LastSeen,omitempty"`
+	// OutboundQueueDepth is the number of blocks waiting to be delivered to the peer.
+	OutboundQueueDepth   int32    `protobuf:"varint,5,opt,name=OutboundQueueDepth,proto3" json:"OutboundQueueDepth,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PeerStatus) Reset()         { *m = PeerStatus{} }
+func (m *PeerStatus) String() string { return proto.CompactTextString(m) }
+func (*PeerStatus) ProtoMessage()    {}
+func (*PeerStatus) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{24}
+}
+
+func (m *PeerStatus) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PeerStatus.Unmarshal(m, b)
+}
+func (m *PeerStatus) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PeerStatus.Marshal(b, m, deterministic)
+}
+func (m *PeerStatus) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PeerStatus.Merge(m, src)
+}
+func (m *PeerStatus) XXX_Size() int {
+	return xxx_messageInfo_PeerStatus.Size(m)
+}
+func (m *PeerStatus) XXX_DiscardUnknown() {
+	xxx_messageInfo_PeerStatus.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PeerStatus proto.InternalMessageInfo
+
+func (m *PeerStatus) GetPeer() *PeerInfo {
+	if m != nil {
+		return m.Peer
+	}
+	return nil
+}
+
+func (m *PeerStatus) GetStatus() MemberState_MemberStatus {
+	if m != nil {
+		return m.Status
+	}
+	return MemberState_ALIVE
+}
+
+func (m *PeerStatus) GetIncarnation() uint64 {
+	if m != nil {
+		return m.Incarnation
+	}
+	return 0
+}
+
+func (m *PeerStatus) GetLastSeen() *timestamp.Timestamp {
+	if m != nil {
+		return m.LastSeen
+	}
+	return nil
+}
+
+func (m *PeerStatus) GetOutboundQueueDepth() int32 {
+	if m != nil {
+		return m.OutboundQueueDepth
+	}
+	return 0
+}
+
+// MembershipEvent is a membership change recorded by a NETWORK block.
+type MembershipEvent struct {
+	BlockID   string               `protobuf:"bytes,1,opt,name=BlockID,proto3" json:"BlockID,omitempty"`
+	Height    uint64               `protobuf:"varint,2,opt,name=Height,proto3" json:"Height,omitempty"`
+	Timestamp *timestamp.Timestamp `protobuf:"bytes,3,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	// Peers is the network after the change.
+	Peers []*PeerInfo `protobuf:"bytes,4,rep,name=Peers,proto3" json:"Peers,omitempty"`
+	// Joined and Left are the peers added and removed compared to the previous NETWORK block.
+	Joined []*PeerInfo `protobuf:"bytes,5,rep,name=Joined,proto3" json:"Joined,omitempty"`
+	Left   []*PeerInfo `protobuf:"bytes,6,rep,name=Left,proto3" json:"Left,omitempty"`
+	// Updated are the peers which changed their address.
+	Updated              []*PeerInfo `protobuf:"bytes,7,rep,name=Updated,proto3" json:"Updated,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *MembershipEvent) Reset()         { *m = MembershipEvent{} }
+func (m *MembershipEvent) String() string { return proto.CompactTextString(m) }
+func (*MembershipEvent) ProtoMessage()    {}
+func (*MembershipEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{25}
+}
+
+func (m *MembershipEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MembershipEvent.Unmarshal(m, b)
+}
+func (m *MembershipEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MembershipEvent.Marshal(b, m, deterministic)
+}
+func (m *MembershipEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MembershipEvent.Merge(m, src)
+}
+func (m *MembershipEvent) XXX_Size() int {
+	return xxx_messageInfo_MembershipEvent.Size(m)
+}
+func (m *MembershipEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_MembershipEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MembershipEvent proto.InternalMessageInfo
+
+func (m *MembershipEvent) GetBlockID() string {
+	if m != nil {
+		return m.BlockID
+	}
+	return ""
+}
+
+func (m *MembershipEvent) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func (m *MembershipEvent) GetTimestamp() *timestamp.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+func (m *MembershipEvent) GetPeers() []*PeerInfo {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+func (m *MembershipEvent) GetJoined() []*PeerInfo {
+	if m != nil {
+		return m.Joined
+	}
+	return nil
+}
+
+func (m *MembershipEvent) GetLeft() []*PeerInfo {
+	if m != nil {
+		return m.Left
+	}
+	return nil
+}
+
+func (m *MembershipEvent) GetUpdated() []*PeerInfo {
+	if m != nil {
+		return m.Updated
+	}
+	return nil
+}
+
+type MembershipHistoryRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MembershipHistoryRequest) Reset()         { *m = MembershipHistoryRequest{} }
+func (m *MembershipHistoryRequest) String() string { return proto.CompactTextString(m) }
+func (*MembershipHistoryRequest) ProtoMessage()    {}
+func (*MembershipHistoryRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{26}
+}
+
+func (m *MembershipHistoryRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MembershipHistoryRequest.Unmarshal(m, b)
+}
+func (m *MembershipHistoryRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MembershipHistoryRequest.Marshal(b, m, deterministic)
+}
+func (m *MembershipHistoryRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MembershipHistoryRequest.Merge(m, src)
+}
+func (m *MembershipHistoryRequest) XXX_Size() int {
+	return xxx_messageInfo_MembershipHistoryRequest.Size(m)
+}
+func (m *MembershipHistoryRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MembershipHistoryRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MembershipHistoryRequest proto.InternalMessageInfo
+
+type MembershipHistoryResponse struct {
+	Events               []*MembershipEvent `protobuf:"bytes,1,rep,name=Events,proto3" json:"Events,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
+}
+
+func (m *MembershipHistoryResponse) Reset()         { *m = MembershipHistoryResponse{} }
+func (m *MembershipHistoryResponse) String() string { return proto.CompactTextString(m) }
+func (*MembershipHistoryResponse) ProtoMessage()    {}
+func (*MembershipHistoryResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{27}
+}
+
+func (m *MembershipHistoryResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MembershipHistoryResponse.Unmarshal(m, b)
+}
+func (m *MembershipHistoryResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MembershipHistoryResponse.Marshal(b, m, deterministic)
+}
+func (m *MembershipHistoryResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MembershipHistoryResponse.Merge(m, src)
+}
+func (m *MembershipHistoryResponse) XXX_Size() int {
+	return xxx_messageInfo_MembershipHistoryResponse.Size(m)
+}
+func (m *MembershipHistoryResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_MembershipHistoryResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MembershipHistoryResponse proto.InternalMessageInfo
+
+func (m *MembershipHistoryResponse) GetEvents() []*MembershipEvent {
+	if m != nil {
+		return m.Events
+	}
+	return nil
+}
+
+type WatchMembershipRequest struct {
+	// IncludeHistory sends the recorded membership changes before the new ones.
+	IncludeHistory       bool     `protobuf:"varint,1,opt,name=IncludeHistory,proto3" json:"IncludeHistory,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchMembershipRequest) Reset()         { *m = WatchMembershipRequest{} }
+func (m *WatchMembershipRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchMembershipRequest) ProtoMessage()    {}
+func (*WatchMembershipRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{28}
+}
+
+func (m *WatchMembershipRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchMembershipRequest.Unmarshal(m, b)
+}
+func (m *WatchMembershipRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchMembershipRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchMembershipRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchMembershipRequest.Merge(m, src)
+}
+func (m *WatchMembershipRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchMembershipRequest.Size(m)
+}
+func (m *WatchMembershipRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchMembershipRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchMembershipRequest proto.InternalMessageInfo
+
+func (m *WatchMembershipRequest) GetIncludeHistory() bool {
+	if m != nil {
+		return m.IncludeHistory
+	}
+	return false
+}
+
+// MerkleRootRequest selects the heights From to To, both included.
+type MerkleRootRequest struct {
+	From                 uint64   `protobuf:"varint,1,opt,name=From,proto3" json:"From,omitempty"`
+	To                   uint64   `protobuf:"varint,2,opt,name=To,proto3" json:"To,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MerkleRootRequest) Reset()         { *m = MerkleRootRequest{} }
+func (m *MerkleRootRequest) String() string { return proto.CompactTextString(m) }
+func (*MerkleRootRequest) ProtoMessage()    {}
+func (*MerkleRootRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{29}
+}
+
+func (m *MerkleRootRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MerkleRootRequest.Unmarshal(m, b)
+}
+func (m *MerkleRootRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MerkleRootRequest.Marshal(b, m, deterministic)
+}
+func (m *MerkleRootRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MerkleRootRequest.Merge(m, src)
+}
+func (m *MerkleRootRequest) XXX_Size() int {
+	return xxx_messageInfo_MerkleRootRequest.Size(m)
+}
+func (m *MerkleRootRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MerkleRootRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MerkleRootRequest proto.InternalMessageInfo
+
+func (m *MerkleRootRequest) GetFrom() uint64 {
+	if m != nil {
+		return m.From
+	}
+	return 0
+}
+
+func (m *MerkleRootRequest) GetTo() uint64 {
+	if m != nil {
+		return m.To
+	}
+	return 0
+}
+
+type MerkleRootResponse struct {
+	Root []byte `protobuf:"bytes,1,opt,name=Root,proto3" json:"Root,omitempty"`
+	// Height is the height of the chain of the peer.
+	Height               uint64   `protobuf:"varint,2,opt,name=Height,proto3" json:"Height,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MerkleRootResponse) Reset()         { *m = MerkleRootResponse{} }
+func (m *MerkleRootResponse) String() string { return proto.CompactTextString(m) }
+func (*MerkleRootResponse) ProtoMessage()    {}
+func (*MerkleRootResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{30}
+}
+
+func (m *MerkleRootResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MerkleRootResponse.Unmarshal(m, b)
+}
+func (m *MerkleRootResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MerkleRootResponse.Marshal(b, m, deterministic)
+}
+func (m *MerkleRootResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MerkleRootResponse.Merge(m, src)
+}
+func (m *MerkleRootResponse) XXX_Size() int {
+	return xxx_messageInfo_MerkleRootResponse.Size(m)
+}
+func (m *MerkleRootResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_MerkleRootResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MerkleRootResponse proto.InternalMessageInfo
+
+func (m *MerkleRootResponse) GetRoot() []byte {
+	if m != nil {
+		return m.Root
+	}
+	return nil
+}
+
+func (m *MerkleRootResponse) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+// BlocksRequest selects the heights From to To, both included. A zero To selects up to the head.
+type BlocksRequest struct {
+	From                 uint64   `protobuf:"varint,1,opt,name=From,proto3" json:"From,omitempty"`
+	To                   uint64   `protobuf:"varint,2,opt,name=To,proto3" json:"To,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BlocksRequest) Reset()         { *m = BlocksRequest{} }
+func (m *BlocksRequest) String() string { return proto.CompactTextString(m) }
+func (*BlocksRequest) ProtoMessage()    {}
+func (*BlocksRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{31}
+}
+
+func (m *BlocksRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlocksRequest.Unmarshal(m, b)
+}
+func (m *BlocksRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlocksRequest.Marshal(b, m, deterministic)
+}
+func (m *BlocksRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlocksRequest.Merge(m, src)
+}
+func (m *BlocksRequest) XXX_Size() int {
+	return xxx_messageInfo_BlocksRequest.Size(m)
+}
+func (m *BlocksRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlocksRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlocksRequest proto.InternalMessageInfo
+
+func (m *BlocksRequest) GetFrom() uint64 {
+	if m != nil {
+		return m.From
+	}
+	return 0
+}
+
+func (m *BlocksRequest) GetTo() uint64 {
+	if m != nil {
+		return m.To
+	}
+	return 0
+}
+
+type BlockRequest struct {
+	ID                   string   `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BlockRequest) Reset()         { *m = BlockRequest{} }
+func (m *BlockRequest) String() string { return proto.CompactTextString(m) }
+func (*BlockRequest) ProtoMessage()    {}
+func (*BlockRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{32}
+}
+
+func (m *BlockRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlockRequest.Unmarshal(m, b)
+}
+func (m *BlockRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlockRequest.Marshal(b, m, deterministic)
+}
+func (m *BlockRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlockRequest.Merge(m, src)
+}
+func (m *BlockRequest) XXX_Size() int {
+	return xxx_messageInfo_BlockRequest.Size(m)
+}
+func (m *BlockRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlockRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlockRequest proto.InternalMessageInfo
+
+func (m *BlockRequest) GetID() string {
+	if m != nil {
+		return m.ID
+	}
+	return ""
+}
+
+type BlockByHeightRequest struct {
+	Height               uint64   `protobuf:"varint,1,opt,name=Height,proto3" json:"Height,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BlockByHeightRequest) Reset()         { *m = BlockByHeightRequest{} }
+func (m *BlockByHeightRequest) String() string { return proto.CompactTextString(m) }
+func (*BlockByHeightRequest) ProtoMessage()    {}
+func (*BlockByHeightRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{33}
+}
+
+func (m *BlockByHeightRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BlockByHeightRequest.Unmarshal(m, b)
+}
+func (m *BlockByHeightRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BlockByHeightRequest.Marshal(b, m, deterministic)
+}
+func (m *BlockByHeightRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BlockByHeightRequest.Merge(m, src)
+}
+func (m *BlockByHeightRequest) XXX_Size() int {
+	return xxx_messageInfo_BlockByHeightRequest.Size(m)
+}
+func (m *BlockByHeightRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BlockByHeightRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BlockByHeightRequest proto.InternalMessageInfo
+
+func (m *BlockByHeightRequest) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+// ExportRequest selects the heights From to To, both included. A zero To selects up to the head.
+type ExportRequest struct {
+	From uint64 `protobuf:"varint,1,opt,name=From,proto3" json:"From,omitempty"`
+	To   uint64 `protobuf:"varint,2,opt,name=To,proto3" json:"To,omitempty"`
+	// Compress gzips the archive.
+	Compress             bool     `protobuf:"varint,3,opt,name=Compress,proto3" json:"Compress,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ExportRequest) Reset()         { *m = ExportRequest{} }
+func (m *ExportRequest) String() string { return proto.CompactTextString(m) }
+func (*ExportRequest) ProtoMessage()    {}
+func (*ExportRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{34}
+}
+
+func (m *ExportRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ExportRequest.Unmarshal(m, b)
+}
+func (m *ExportRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ExportRequest.Marshal(b, m, deterministic)
+}
+func (m *ExportRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ExportRequest.Merge(m, src)
+}
+func (m *ExportRequest) XXX_Size() int {
+	return xxx_messageInfo_ExportRequest.Size(m)
+}
+func (m *ExportRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ExportRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ExportRequest proto.InternalMessageInfo
+
+func (m *ExportRequest) GetFrom() uint64 {
+	if m != nil {
+		return m.From
+	}
+	return 0
+}
+
+func (m *ExportRequest) GetTo() uint64 {
+	if m != nil {
+		return m.To
+	}
+	return 0
+}
+
+func (m *ExportRequest) GetCompress() bool {
+	if m != nil {
+		return m.Compress
+	}
+	return false
+}
+
+type ArchiveChunk struct {
+	Data []byte `protobuf:"bytes,1,opt,name=Data,proto3" json:"Data,omitempty"`
+	// Token is the join token checked by the admission policy of the peer, sent with the first chunk of an import.
+	Token                string   `protobuf:"bytes,2,opt,name=Token,proto3" json:"Token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ArchiveChunk) Reset()         { *m = ArchiveChunk{} }
+func (m *ArchiveChunk) String() string { return proto.CompactTextString(m) }
+func (*ArchiveChunk) ProtoMessage()    {}
+func (*ArchiveChunk) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{35}
+}
+
+func (m *ArchiveChunk) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ArchiveChunk.Unmarshal(m, b)
+}
+func (m *ArchiveChunk) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ArchiveChunk.Marshal(b, m, deterministic)
+}
+func (m *ArchiveChunk) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ArchiveChunk.Merge(m, src)
+}
+func (m *ArchiveChunk) XXX_Size() int {
+	return xxx_messageInfo_ArchiveChunk.Size(m)
+}
+func (m *ArchiveChunk) XXX_DiscardUnknown() {
+	xxx_messageInfo_ArchiveChunk.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ArchiveChunk proto.InternalMessageInfo
+
+func (m *ArchiveChunk) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (m *ArchiveChunk) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+type ImportResponse struct {
+	// Imported is the number of blocks added to the chain, which ends with Head at Height.
+	Imported             uint64   `protobuf:"varint,1,opt,name=Imported,proto3" json:"Imported,omitempty"`
+	Head                 string   `protobuf:"bytes,2,opt,name=Head,proto3" json:"Head,omitempty"`
+	Height               uint64   `protobuf:"varint,3,opt,name=Height,proto3" json:"Height,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ImportResponse) Reset()         { *m = ImportResponse{} }
+func (m *ImportResponse) String() string { return proto.CompactTextString(m) }
+func (*ImportResponse) ProtoMessage()    {}
+func (*ImportResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_fcee3e88f49c2881, []int{36}
+}
+
+func (m *ImportResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ImportResponse.Unmarshal(m, b)
+}
+func (m *ImportResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ImportResponse.Marshal(b, m, deterministic)
+}
+func (m *ImportResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ImportResponse.Merge(m, src)
+}
+func (m *ImportResponse) XXX_Size() int {
+	return xxx_messageInfo_ImportResponse.Size(m)
+}
+func (m *ImportResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ImportResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ImportResponse proto.InternalMessageInfo
+
+func (m *ImportResponse) GetImported() uint64 {
+	if m != nil {
+		return m.Imported
+	}
+	return 0
+}
+
+func (m *ImportResponse) GetHead() string {
+	if m != nil {
+		return m.Head
+	}
+	return ""
+}
+
+func (m *ImportResponse) GetHeight() uint64 {
+	if m != nil {
+		return m.Height
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterEnum("Lightblock_BlockType", Lightblock_BlockType_name, Lightblock_BlockType_value)
+	proto.RegisterEnum("MemberState_MemberStatus", MemberState_MemberStatus_name, MemberState_MemberStatus_value)
+	proto.RegisterEnum("MembershipChange_ChangeType", MembershipChange_ChangeType_name, MembershipChange_ChangeType_value)
+	proto.RegisterType((*Lightblock)(nil), "Lightblock")
+	proto.RegisterType((*Genesis)(nil), "Genesis")
+	proto.RegisterMapType((map[string]string)(nil), "Genesis.ConfigEntry")
+	proto.RegisterType((*BlockEntry)(nil), "BlockEntry")
+	proto.RegisterType((*JoinRequest)(nil), "JoinRequest")
+	proto.RegisterType((*LeaveRequest)(nil), "LeaveRequest")
+	proto.RegisterType((*LeaveResponse)(nil), "LeaveResponse")
+	proto.RegisterType((*JoinResponse)(nil), "JoinResponse")
+	proto.RegisterType((*ConnectRequest)(nil), "ConnectRequest")
+	proto.RegisterType((*PeerInfo)(nil), "PeerInfo")
+	proto.RegisterType((*PersistRequest)(nil), "PersistRequest")
+	proto.RegisterType((*PersistBatchRequest)(nil), "PersistBatchRequest")
+	proto.RegisterType((*PersistResponse)(nil), "PersistResponse")
+	proto.RegisterType((*EmptyQueryRequest)(nil), "EmptyQueryRequest")
+	proto.RegisterType((*QueryResponse)(nil), "QueryResponse")
+	proto.RegisterType((*NewBlockResponse)(nil), "NewBlockResponse")
+	proto.RegisterType((*ReplicationMessage)(nil), "ReplicationMessage")
+	proto.RegisterType((*ReplicationAck)(nil), "ReplicationAck")
+	proto.RegisterType((*MemberState)(nil), "MemberState")
+	proto.RegisterType((*ProbeRequest)(nil), "ProbeRequest")
+	proto.RegisterType((*ProbeResponse)(nil), "ProbeResponse")
+	proto.RegisterType((*MembershipChange)(nil), "MembershipChange")
+	proto.RegisterType((*MembershipChangeResponse)(nil), "MembershipChangeResponse")
+	proto.RegisterType((*StatusRequest)(nil), "StatusRequest")
+	proto.RegisterType((*StatusResponse)(nil), "StatusResponse")
+	proto.RegisterType((*PeerStatus)(nil), "PeerStatus")
+	proto.RegisterType((*MembershipEvent)(nil), "MembershipEvent")
+	proto.RegisterType((*MembershipHistoryRequest)(nil), "MembershipHistoryRequest")
+	proto.RegisterType((*MembershipHistoryResponse)(nil), "MembershipHistoryResponse")
+	proto.RegisterType((*WatchMembershipRequest)(nil), "WatchMembershipRequest")
+	proto.RegisterType((*MerkleRootRequest)(nil), "MerkleRootRequest")
+	proto.RegisterType((*MerkleRootResponse)(nil), "MerkleRootResponse")
+	proto.RegisterType((*BlocksRequest)(nil), "BlocksRequest")
+	proto.RegisterType((*BlockRequest)(nil), "BlockRequest")
+	proto.RegisterType((*BlockByHeightRequest)(nil), "BlockByHeightRequest")
+	proto.RegisterType((*ExportRequest)(nil), "ExportRequest")
+	proto.RegisterType((*ArchiveChunk)(nil), "ArchiveChunk")
+	proto.RegisterType((*ImportResponse)(nil), "ImportResponse")
+}
+
+func init() {
+	proto.RegisterFile("lightpeer.proto", fileDescriptor_fcee3e88f49c2881)
+}
+
+var fileDescriptor_fcee3e88f49c2881 = []byte{
+	// 1778 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x58, 0xdd, 0x6e, 0xe3, 0xc6,
+	0x15, 0x16, 0x25, 0xea, 0xef, 0x48, 0xa2, 0xb4, 0xb3, 0x4e, 0xc2, 0x25, 0xd2, 0xc6, 0x99, 0x36,
+	0x0b, 0x15, 0x35, 0xc6, 0x1b, 0xa7, 0x68, 0xdc, 0x00, 0x01, 0xd6, 0x96, 0xd5, 0x5d, 0xa6, 0x5e,
+	0xd9, 0xa1, 0x94, 0x4d, 0x51, 0xa0, 0x28, 0x68, 0x69, 0x2c, 0xb3, 0x96, 0x39, 0x2a, 0x49, 0x79,
+	0x57, 0xbd, 0x6e, 0xd1, 0xab, 0x5e, 0xf4, 0x1d, 0xfa, 0x40, 0x7d, 0x8c, 0xde, 0xf5, 0x15, 0x8a,
+	0x19, 0xce, 0xf0, 0x4f, 0xd2, 0xba, 0x5b, 0x20, 0x57, 0x9e, 0x73, 0xe6, 0xcc, 0xe1, 0x37, 0x67,
+	0x66, 0xbe, 0xf3, 0xc9, 0xd0, 0x5d, 0x78, 0xf3, 0x9b, 0x68, 0x49, 0x69, 0x40, 0x96, 0x01, 0x8b,
+	0x98, 0xf5, 0xc9, 0x9c, 0xb1, 0xf9, 0x82, 0x1e, 0x0a, 0xeb, 0x6a, 0x75, 0x7d, 0x18, 0x79, 0x77,
+	0x34, 0x8c, 0xdc, 0xbb, 0x65, 0x1c, 0x80, 0xff, 0x5e, 0x01, 0x38, 0xe7, 0x8b, 0xae, 0x16, 0x6c,
+	0x7a, 0x8b, 0x0c, 0x28, 0xdb, 0x67, 0xa6, 0xb6, 0xaf, 0xf5, 0x9b, 0x4e, 0xd9, 0x3e, 0x43, 0x26,
+	0xd4, 0x2f, 0xdd, 0xf5, 0x82, 0xb9, 0x33, 0xb3, 0xbc, 0xaf, 0xf5, 0xdb, 0x8e, 0x32, 0xd1, 0x87,
+	0x50, 0xbb, 0x0c, 0xe8, 0xbd, 0x7d, 0x66, 0x56, 0x44, 0xb4, 0xb4, 0xd0, 0xc7, 0xd0, 0x74, 0xe8,
+	0x9f, 0x56, 0x34, 0x8c, 0xec, 0x33, 0x53, 0x17, 0x53, 0xa9, 0x03, 0x7d, 0x06, 0xf5, 0xa1, 0x1f,
+	0x05, 0x1e, 0x0d, 0xcd, 0xea, 0x7e, 0xa5, 0xdf, 0x3a, 0x6a, 0x91, 0x53, 0xfe, 0x61, 0xee, 0x5c,
+	0x3b, 0x6a, 0x8e, 0x27, 0x7f, 0x49, 0x39, 0x2a, 0xb3, 0xb6, 0xaf, 0xf5, 0x75, 0x47, 0x5a, 0xe8,
+	0x67, 0xa0, 0x4f, 0xd6, 0x4b, 0x6a, 0x36, 0xf7, 0xb5, 0xbe, 0x71, 0xf4, 0x01, 0x49, 0x91, 0xc7,
+	0x69, 0xf8, 0xa4, 0x23, 0x42, 0xd0, 0xd7, 0xd0, 0x5e, 0xb8, 0x61, 0xf4, 0x87, 0xd5, 0x72, 0xe6,
+	0x46, 0x74, 0x66, 0xc2, 0xbe, 0xd6, 0x6f, 0x1d, 0x59, 0x24, 0x2e, 0x08, 0x51, 0x05, 0x21, 0x13,
+	0x55, 0x10, 0xa7, 0xc5, 0xe3, 0xbf, 0x8b, 0xc3, 0xf9, 0x36, 0x46, 0x34, 0x7a, 0xc3, 0x82, 0x5b,
+	0xfb, 0xcc, 0x6c, 0xc5, 0xdb, 0x48, 0x1c, 0xe8, 0x33, 0x68, 0x5c, 0x06, 0x6c, 0xc9, 0x42, 0x1a,
+	0x98, 0x6d, 0x91, 0xb8, 0x49, 0x2e, 0x29, 0x0d, 0x6c, 0xff, 0x9a, 0x39, 0xc9, 0x14, 0xfe, 0x1c,
+	0x9a, 0x09, 0x2c, 0xd4, 0x82, 0xfa, 0x68, 0x38, 0xf9, 0xfe, 0xc2, 0xf9, 0x4d, 0xaf, 0x84, 0x00,
+	0x6a, 0x83, 0x73, 0x7b, 0x38, 0x9a, 0xf4, 0x34, 0x3e, 0xf1, 0x62, 0x38, 0x1a, 0x8e, 0xed, 0x71,
+	0xaf, 0x8c, 0xff, 0xa3, 0x41, 0xfd, 0x05, 0xf5, 0x69, 0xe8, 0x85, 0x79, 0x0c, 0x5a, 0x11, 0xc3,
+	0x31, 0x34, 0x07, 0x01, 0xe5, 0x60, 0x4f, 0x22, 0xb3, 0xfc, 0xe0, 0xee, 0xd2, 0x60, 0xf4, 0x13,
+	0xa8, 0xcb, 0x34, 0x66, 0x65, 0xbf, 0x92, 0x07, 0xaf, 0x66, 0xd0, 0x01, 0xd4, 0x06, 0xcc, 0xbf,
+	0xf6, 0xe6, 0xa6, 0x2e, 0x62, 0xf6, 0x88, 0x84, 0x45, 0x62, 0x77, 0x7c, 0x62, 0x32, 0xc6, 0xfa,
+	0x15, 0xb4, 0x32, 0x6e, 0xd4, 0x83, 0xca, 0x2d, 0x5d, 0x4b, 0xcc, 0x7c, 0x88, 0xf6, 0xa0, 0x7a,
+	0xef, 0x2e, 0x56, 0x54, 0x20, 0x6d, 0x3a, 0xb1, 0xf1, 0x55, 0xf9, 0x58, 0xc3, 0x67, 0x00, 0xe9,
+	0x15, 0xc8, 0x5e, 0x38, 0x2d, 0x7f, 0xe1, 0x72, 0x17, 0xab, 0x5c, 0xb8, 0x58, 0xf8, 0x6b, 0x68,
+	0x7d, 0xc3, 0x3c, 0x5f, 0x3a, 0x78, 0x9a, 0x93, 0xd9, 0x2c, 0xa0, 0x61, 0x28, 0x41, 0x28, 0x93,
+	0x03, 0x99, 0xb0, 0x5b, 0xea, 0x2b, 0x20, 0xc2, 0xc0, 0x06, 0xb4, 0xcf, 0xa9, 0x7b, 0x4f, 0xe5,
+	0x7a, 0xdc, 0x85, 0x8e, 0xb4, 0xc3, 0x25, 0xf3, 0x43, 0x8a, 0x9f, 0x42, 0x3b, 0xce, 0x1f, 0xdb,
+	0xfc, 0x86, 0x3a, 0x34, 0x5c, 0x2d, 0x22, 0x99, 0x5f, 0x5a, 0x78, 0x0a, 0xc6, 0x80, 0xf9, 0x3e,
+	0x9d, 0x46, 0x0a, 0xca, 0x8f, 0x40, 0xe7, 0xd5, 0x15, 0x71, 0xb9, 0x52, 0x0b, 0x77, 0xfe, 0x90,
+	0xcb, 0xc5, 0x43, 0x4e, 0xd0, 0x56, 0xb2, 0x68, 0x5f, 0x42, 0x43, 0x65, 0x79, 0xc7, 0x4e, 0x11,
+	0xe8, 0x23, 0xf7, 0x4e, 0x55, 0x5c, 0x8c, 0xe5, 0xfb, 0xae, 0xa8, 0xf7, 0x8d, 0x5f, 0x82, 0x71,
+	0x49, 0x83, 0xd0, 0x0b, 0xa3, 0x4c, 0xe5, 0xfe, 0xaf, 0x03, 0xb8, 0x80, 0xc7, 0x32, 0xd3, 0xa9,
+	0x1b, 0x4d, 0x6f, 0x54, 0x3a, 0x0b, 0x1a, 0x72, 0x3d, 0xc7, 0x57, 0xe9, 0xb7, 0x9d, 0xc4, 0x7e,
+	0x20, 0xe1, 0xef, 0xa1, 0x9b, 0x40, 0x93, 0x45, 0xb7, 0xa0, 0xa1, 0xc6, 0x72, 0xb3, 0x89, 0xcd,
+	0x71, 0x8b, 0x6b, 0x94, 0xa4, 0x52, 0x26, 0xaf, 0xa1, 0xed, 0xcf, 0xe8, 0x5b, 0xb1, 0xed, 0xaa,
+	0x13, 0x1b, 0xf8, 0x31, 0x3c, 0x1a, 0xde, 0x2d, 0xa3, 0xf5, 0xb7, 0x2b, 0x1a, 0xac, 0xd5, 0xb1,
+	0xbf, 0x81, 0x8e, 0xb4, 0xd3, 0xac, 0x3b, 0xaa, 0xf1, 0x9e, 0xdf, 0xe3, 0x9b, 0x15, 0x01, 0x63,
+	0xef, 0xcf, 0x54, 0xf0, 0x62, 0xd5, 0x49, 0x1d, 0x98, 0x40, 0x6f, 0x44, 0xdf, 0x08, 0xfb, 0x7f,
+	0xd9, 0x2d, 0x1e, 0x03, 0x72, 0xe8, 0x72, 0xe1, 0x4d, 0xdd, 0xc8, 0x63, 0xfe, 0x2b, 0x1a, 0x86,
+	0xee, 0x5c, 0xac, 0x18, 0xf3, 0x9d, 0xf8, 0xd3, 0x78, 0x85, 0xee, 0x24, 0x36, 0xfa, 0x14, 0xaa,
+	0x22, 0xbd, 0xa4, 0x8a, 0x56, 0x86, 0x3b, 0x9d, 0x78, 0x06, 0xff, 0x11, 0x8c, 0x4c, 0xd2, 0x93,
+	0xe9, 0xed, 0x3b, 0x13, 0xee, 0x41, 0x75, 0x18, 0x04, 0x2c, 0x50, 0x0f, 0x49, 0x18, 0xe8, 0x29,
+	0x18, 0xaf, 0xbc, 0x30, 0xf4, 0xfc, 0xb9, 0xaa, 0x4e, 0x7c, 0xd9, 0x0a, 0x5e, 0xfc, 0x4f, 0x0d,
+	0x5a, 0xaf, 0xe8, 0xdd, 0x15, 0x0d, 0xc6, 0x91, 0x1b, 0xd1, 0x77, 0x5c, 0xe3, 0xcf, 0xa1, 0xc6,
+	0x43, 0x56, 0xa1, 0xf8, 0x90, 0x71, 0xf4, 0x84, 0x64, 0xd6, 0x65, 0xc6, 0xab, 0xd0, 0x91, 0x81,
+	0x68, 0x1f, 0x5a, 0xb6, 0x3f, 0x75, 0x03, 0x5f, 0x6c, 0x44, 0x20, 0xd0, 0x9d, 0xac, 0x8b, 0x3f,
+	0xe7, 0xec, 0x4a, 0xd4, 0x84, 0xea, 0xc9, 0xb9, 0xfd, 0x7a, 0xd8, 0x2b, 0x71, 0x3a, 0x1e, 0x7f,
+	0x37, 0xbe, 0x1c, 0x0e, 0x26, 0x3d, 0x0d, 0x8f, 0xa0, 0x7d, 0x19, 0xb0, 0x2b, 0xc5, 0x0b, 0xfc,
+	0xd9, 0x4f, 0xdc, 0x60, 0x4e, 0x93, 0x67, 0x1f, 0x5b, 0xe8, 0x29, 0xd4, 0xe3, 0x7c, 0x1c, 0x25,
+	0xa7, 0xcb, 0x76, 0x16, 0xa5, 0xa3, 0x26, 0xf1, 0x3f, 0x34, 0xe8, 0xc8, 0x84, 0xf2, 0x94, 0x7b,
+	0x50, 0x39, 0x99, 0xde, 0x8a, 0x74, 0x0d, 0x87, 0x0f, 0x8b, 0xe8, 0xcb, 0x1b, 0xe8, 0xb3, 0x5f,
+	0xab, 0xbc, 0xe3, 0x6b, 0xe8, 0xa7, 0xd0, 0x91, 0x54, 0x22, 0xbb, 0xa9, 0x2e, 0x72, 0xe5, 0x9d,
+	0xf8, 0x5f, 0x1a, 0xf4, 0xe4, 0x8a, 0x1b, 0x6f, 0x39, 0xb8, 0x71, 0xfd, 0x39, 0x45, 0xcf, 0x64,
+	0xa7, 0xd5, 0x44, 0xcd, 0x3f, 0x26, 0xc5, 0x00, 0x12, 0xff, 0xc9, 0x34, 0xdc, 0x4f, 0xa0, 0x7a,
+	0x49, 0xd3, 0x02, 0x64, 0x88, 0x2e, 0xf6, 0x6f, 0xe7, 0xb2, 0x5c, 0x2b, 0xd5, 0x77, 0xb7, 0xd2,
+	0x03, 0x80, 0xf4, 0x8b, 0xa8, 0x01, 0xfa, 0x37, 0x17, 0xf6, 0xa8, 0x57, 0xe2, 0x07, 0x37, 0x7c,
+	0x6d, 0xf3, 0xb3, 0xe2, 0xc3, 0xf3, 0xe1, 0xc9, 0xeb, 0x61, 0xaf, 0x8c, 0x7f, 0x01, 0x66, 0x11,
+	0xf0, 0x36, 0xa2, 0xd0, 0x72, 0x0f, 0x97, 0x93, 0xbe, 0xbc, 0x48, 0x92, 0x0e, 0xfe, 0x52, 0x06,
+	0x43, 0x79, 0xe4, 0xea, 0x07, 0xd8, 0xdc, 0x84, 0xfa, 0x6b, 0x4e, 0x5a, 0x4c, 0xf5, 0x17, 0x65,
+	0xc6, 0x92, 0xc6, 0x9d, 0xa5, 0x7a, 0x29, 0xb6, 0x32, 0x52, 0x47, 0xcf, 0x49, 0x1d, 0x0c, 0xed,
+	0x71, 0xc4, 0x02, 0x77, 0x4e, 0x4f, 0xd7, 0x91, 0x90, 0x4b, 0x5a, 0xbf, 0xe2, 0xe4, 0x7c, 0xfc,
+	0xa6, 0x0c, 0x18, 0x0b, 0x66, 0x9e, 0xef, 0x46, 0x2c, 0x10, 0x5a, 0xa9, 0xe9, 0x64, 0x5d, 0x5c,
+	0x6f, 0xa9, 0x9b, 0x52, 0x97, 0x7a, 0x8b, 0xe3, 0x94, 0x9b, 0x52, 0x73, 0xf9, 0x26, 0xd4, 0x28,
+	0x34, 0x21, 0xfc, 0x6f, 0x0d, 0x20, 0x5d, 0xf5, 0x50, 0x09, 0x7e, 0x88, 0xf7, 0x8a, 0x7e, 0x09,
+	0x8d, 0x73, 0x37, 0x8c, 0xc6, 0x94, 0xfa, 0xa6, 0xfe, 0xa0, 0xd6, 0x49, 0x62, 0x11, 0x01, 0x74,
+	0xb1, 0x8a, 0xae, 0xd8, 0xca, 0x9f, 0x7d, 0xbb, 0xa2, 0x2b, 0x7a, 0x46, 0x97, 0xd1, 0x8d, 0xa8,
+	0x65, 0xd5, 0xd9, 0x32, 0x83, 0xff, 0x5a, 0x86, 0x6e, 0x7a, 0x73, 0x86, 0xf7, 0xd4, 0x8f, 0x76,
+	0x5f, 0x98, 0xcc, 0xd9, 0x95, 0x73, 0x67, 0x77, 0x0c, 0xcd, 0x04, 0x8c, 0x59, 0x79, 0x10, 0x6e,
+	0x1a, 0x9c, 0x3e, 0x22, 0x7d, 0xc7, 0x23, 0xfa, 0x14, 0x6a, 0x5c, 0x87, 0xd0, 0x99, 0x59, 0x2d,
+	0x46, 0xc8, 0x09, 0x7e, 0x3e, 0xe7, 0xf4, 0x9a, 0x4b, 0xe7, 0x42, 0x80, 0x70, 0x73, 0xf5, 0x27,
+	0x45, 0xae, 0x59, 0x2f, 0x46, 0xa8, 0x19, 0x6c, 0x65, 0x1f, 0xd0, 0x4b, 0x2f, 0x8c, 0x58, 0xda,
+	0x24, 0x87, 0xf0, 0x64, 0xcb, 0x9c, 0x7c, 0x1f, 0x7d, 0xa8, 0x89, 0xaa, 0xc5, 0xdd, 0xbe, 0x75,
+	0xd4, 0x23, 0x85, 0x72, 0x3a, 0x72, 0x1e, 0x3f, 0x87, 0x0f, 0xbf, 0xe7, 0x4a, 0x21, 0x9d, 0x57,
+	0x24, 0xfb, 0x14, 0x0c, 0xdb, 0x9f, 0x2e, 0x56, 0x33, 0x2a, 0xb3, 0x4b, 0x76, 0x2c, 0x78, 0xf1,
+	0x97, 0xf0, 0xe8, 0x15, 0x0d, 0x6e, 0x17, 0xd4, 0x61, 0x2c, 0xd1, 0x2f, 0x08, 0xf4, 0x5f, 0x07,
+	0xec, 0x4e, 0xb6, 0x2b, 0x31, 0xe6, 0xaa, 0x67, 0xc2, 0xe4, 0x19, 0x95, 0x27, 0x0c, 0x3f, 0x07,
+	0x94, 0x5d, 0x28, 0xa1, 0x23, 0xd0, 0xb9, 0x2d, 0x1b, 0xbd, 0x18, 0xef, 0x3a, 0x61, 0xfc, 0x05,
+	0x74, 0xc4, 0x25, 0x08, 0xdf, 0xe7, 0xb3, 0x3f, 0x86, 0xb6, 0xec, 0xf0, 0xf1, 0x9a, 0xc2, 0x8f,
+	0x2d, 0x4c, 0x60, 0x4f, 0xcc, 0x9f, 0xae, 0xe3, 0xaf, 0x64, 0x9a, 0x8e, 0x04, 0xa1, 0xe5, 0x40,
+	0x5c, 0x40, 0x67, 0xf8, 0x76, 0xc9, 0x82, 0xf7, 0xd9, 0x3b, 0x6f, 0xe9, 0x03, 0x76, 0xb7, 0x14,
+	0x9d, 0xb6, 0x22, 0xca, 0x9a, 0xd8, 0xf8, 0x18, 0xda, 0x27, 0xc1, 0xf4, 0xc6, 0xbb, 0xa7, 0x83,
+	0x9b, 0x95, 0x7f, 0xcb, 0xf3, 0x9d, 0xb9, 0x91, 0xab, 0x2a, 0xc2, 0xc7, 0x3b, 0xf4, 0xf3, 0x6f,
+	0xc1, 0xb0, 0xef, 0x62, 0x28, 0xa9, 0x7a, 0x89, 0x3d, 0x74, 0xa6, 0xa4, 0x83, 0xb2, 0x79, 0x5e,
+	0xce, 0x7e, 0x4a, 0x99, 0xf2, 0x71, 0x66, 0x93, 0x95, 0xec, 0x26, 0x8f, 0xfe, 0xd6, 0x80, 0xe6,
+	0xb9, 0xfa, 0x55, 0x8b, 0x0e, 0x62, 0x99, 0xaf, 0x7e, 0xa4, 0xb4, 0x49, 0x46, 0xf4, 0x5b, 0x1d,
+	0x92, 0x95, 0xe8, 0xb8, 0x84, 0x8e, 0x12, 0x31, 0x3e, 0xa2, 0x6f, 0x04, 0x39, 0x75, 0x49, 0x5e,
+	0x9d, 0x5b, 0x59, 0x1d, 0x84, 0x4b, 0xcf, 0x34, 0x74, 0x28, 0x7f, 0x09, 0xa8, 0x4f, 0x74, 0x48,
+	0xf6, 0x87, 0x81, 0x65, 0x90, 0xfc, 0xef, 0x82, 0x12, 0x22, 0x50, 0x97, 0x3a, 0x15, 0x75, 0x49,
+	0x5e, 0x4c, 0x5b, 0x3d, 0x52, 0x90, 0xb0, 0xb8, 0x84, 0x8e, 0xa1, 0x9d, 0x15, 0xca, 0x68, 0x8f,
+	0x6c, 0xd1, 0xcd, 0x5b, 0x57, 0x1e, 0x42, 0x55, 0xa8, 0x53, 0x84, 0xc8, 0x86, 0x74, 0xb5, 0x0c,
+	0x92, 0x53, 0xae, 0x62, 0x2f, 0x47, 0x60, 0x8c, 0x58, 0xe4, 0x5d, 0xaf, 0x95, 0xb6, 0x44, 0xd9,
+	0xed, 0x5a, 0x8f, 0x48, 0x51, 0x73, 0xe2, 0x12, 0xfa, 0x12, 0x9a, 0x4a, 0x04, 0x52, 0xf4, 0x98,
+	0x6c, 0xaa, 0x4c, 0xab, 0x4b, 0xf2, 0x2a, 0x11, 0x97, 0xfa, 0xda, 0x33, 0x0d, 0xf5, 0xa1, 0x2a,
+	0x94, 0x0d, 0xea, 0x90, 0xac, 0x64, 0xb2, 0x0c, 0x92, 0x13, 0x3c, 0xb8, 0x84, 0x6c, 0xf8, 0x48,
+	0xf6, 0xf5, 0x0d, 0xd9, 0xf1, 0x68, 0x43, 0x68, 0x58, 0x4f, 0xc8, 0xae, 0x56, 0x2e, 0x8a, 0xdf,
+	0x7c, 0x41, 0x23, 0xd9, 0x46, 0x0c, 0x92, 0x6b, 0xdf, 0x56, 0x97, 0xe4, 0x9b, 0x37, 0x2e, 0xa1,
+	0x0b, 0xd8, 0x7b, 0x41, 0xa3, 0x0d, 0xfa, 0x42, 0x4f, 0xc8, 0x86, 0x4f, 0x65, 0xb1, 0xc8, 0x4e,
+	0xb6, 0xc3, 0x25, 0xf4, 0x1c, 0xba, 0x05, 0x16, 0x43, 0x1f, 0x91, 0xed, 0xbc, 0x66, 0x6d, 0x70,
+	0xa1, 0x38, 0xa4, 0xaf, 0xa0, 0x23, 0x20, 0x29, 0x3e, 0x42, 0x88, 0x6c, 0xb0, 0x9a, 0xf5, 0x98,
+	0x6c, 0x12, 0x16, 0x2e, 0xa1, 0x03, 0xb1, 0xfd, 0x98, 0x89, 0x90, 0x41, 0x72, 0x94, 0xb4, 0x79,
+	0xb5, 0xfb, 0xd0, 0x50, 0xd1, 0xa8, 0x43, 0xb2, 0x54, 0x54, 0x88, 0x45, 0xc7, 0xd0, 0x53, 0x91,
+	0x8a, 0x8c, 0xd0, 0x07, 0x64, 0x1b, 0x39, 0x15, 0x57, 0xfe, 0x1c, 0x6a, 0x31, 0x27, 0x21, 0x83,
+	0xe4, 0xc8, 0xc9, 0xea, 0x90, 0x2c, 0xb7, 0x08, 0x40, 0x07, 0x50, 0x8b, 0x39, 0x01, 0xe5, 0x27,
+	0xad, 0x2e, 0xc9, 0xb3, 0x09, 0xbf, 0x62, 0xa7, 0xdd, 0xdf, 0x75, 0xdc, 0xa5, 0x77, 0x98, 0xfc,
+	0x8b, 0xeb, 0xaa, 0x26, 0x7a, 0xe9, 0x17, 0xff, 0x1d, 0x00, 0x44, 0x69, 0xaf, 0x0a, 0xf6, 0x12,
+	0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
 var _ grpc.ClientConnInterface
 
 // This is a compile-time assertion to ensure that this generated file
@@ -538,11 +2229,56 @@ const _ = grpc.SupportPackageIsVersion6
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type LightpeerClient interface {
+	// JoinNetwork tells the peer to join the network at the given address from JoinRequest.
+	// The peer will open a client connection to that address, and call ConnectNewPeer to join the network.
 	JoinNetwork(ctx context.Context, in *JoinRequest, opts ...grpc.CallOption) (*JoinResponse, error)
+	// ConnectNewPeer accepts a connection from another peer, adding it to the network.
+	// If successful, it returns a stream of all the messages which were stored in the network
 	ConnectNewPeer(ctx context.Context, in *ConnectRequest, opts ...grpc.CallOption) (Lightpeer_ConnectNewPeerClient, error)
+	// LeaveNetwork tells the peer to leave the network. The peer stops checking the health of the network,
+	// delivers the blocks queued for other peers, and asks the coordinator to remove it from the network.
+	LeaveNetwork(ctx context.Context, in *LeaveRequest, opts ...grpc.CallOption) (*LeaveResponse, error)
+	// Persist saves the state from the message on the chain
 	Persist(ctx context.Context, in *PersistRequest, opts ...grpc.CallOption) (*PersistResponse, error)
+	// PersistBatch saves all the payloads from the message in a single block,
+	// such that they appear on the chain together or not at all.
+	PersistBatch(ctx context.Context, in *PersistBatchRequest, opts ...grpc.CallOption) (*PersistResponse, error)
+	// Query returns all the chain states saved by a Persist or PersistBatch request, together with the block holding them
 	Query(ctx context.Context, in *EmptyQueryRequest, opts ...grpc.CallOption) (Lightpeer_QueryClient, error)
+	// NotifyNewBlock is used by peers to notify each other of block updates
 	NotifyNewBlock(ctx context.Context, in *Lightblock, opts ...grpc.CallOption) (*NewBlockResponse, error)
+	// Replicate is a long lived stream between two peers, carrying new blocks in order.
+	// The receiving peer acknowledges every message with its sequence number once the block was processed.
+	Replicate(ctx context.Context, opts ...grpc.CallOption) (Lightpeer_ReplicateClient, error)
+	// Probe is used by the failure detector to check that a peer is alive. When the target is set,
+	// the peer probes the target on behalf of the caller. Both sides exchange the membership states they know.
+	Probe(ctx context.Context, in *ProbeRequest, opts ...grpc.CallOption) (*ProbeResponse, error)
+	// ProposeMembershipChange asks the coordinator of the network to record a membership change.
+	// Peers which are not the coordinator refuse the proposal.
+	ProposeMembershipChange(ctx context.Context, in *MembershipChange, opts ...grpc.CallOption) (*MembershipChangeResponse, error)
+	// GetStatus returns the state of the peer, and its view of the network.
+	GetStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	// GetMembershipHistory returns the membership changes recorded by the NETWORK blocks, oldest first.
+	GetMembershipHistory(ctx context.Context, in *MembershipHistoryRequest, opts ...grpc.CallOption) (*MembershipHistoryResponse, error)
+	// WatchMembership streams the membership changes as they are recorded by the peer.
+	WatchMembership(ctx context.Context, in *WatchMembershipRequest, opts ...grpc.CallOption) (Lightpeer_WatchMembershipClient, error)
+	// GetMerkleRoot returns the Merkle root of the block IDs between the given heights, used by the
+	// anti-entropy exchange for comparing chains. The range is capped at the height of the chain.
+	GetMerkleRoot(ctx context.Context, in *MerkleRootRequest, opts ...grpc.CallOption) (*MerkleRootResponse, error)
+	// GetBlocks streams the blocks between the given heights, oldest first.
+	GetBlocks(ctx context.Context, in *BlocksRequest, opts ...grpc.CallOption) (Lightpeer_GetBlocksClient, error)
+	// GetBlock returns the block with the given ID, used by tools inspecting blocks and by peers repairing
+	// missing or corrupt blocks.
+	GetBlock(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*Lightblock, error)
+	// GetBlockByHeight returns the block at the given height of the chain.
+	GetBlockByHeight(ctx context.Context, in *BlockByHeightRequest, opts ...grpc.CallOption) (*Lightblock, error)
+	// Export streams an archive of the blocks between the given heights, for backups and for seeding
+	// other peers through Import.
+	Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (Lightpeer_ExportClient, error)
+	// Import seeds a peer which holds no chain and did not join a network with the blocks of an archive
+	// streamed by the client. The client is admitted by the join policy of the peer. Members of a network
+	// import archives while they are stopped, through lightserver -import.
+	Import(ctx context.Context, opts ...grpc.CallOption) (Lightpeer_ImportClient, error)
 }
 
 type lightpeerClient struct {
@@ -594,6 +2330,15 @@ func (x *lightpeerConnectNewPeerClient) Recv() (*Lightblock, error) {
 	return m, nil
 }
 
+func (c *lightpeerClient) LeaveNetwork(ctx context.Context, in *LeaveRequest, opts ...grpc.CallOption) (*LeaveResponse, error) {
+	out := new(LeaveResponse)
+	err := c.cc.Invoke(ctx, "/Lightpeer/LeaveNetwork", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *lightpeerClient) Persist(ctx context.Context, in *PersistRequest, opts ...grpc.CallOption) (*PersistResponse, error) {
 	out := new(PersistResponse)
 	err := c.cc.Invoke(ctx, "/Lightpeer/Persist", in, out, opts...)
@@ -603,6 +2348,15 @@ func (c *lightpeerClient) Persist(ctx context.Context, in *PersistRequest, opts
 	return out, nil
 }
 
+func (c *lightpeerClient) PersistBatch(ctx context.Context, in *PersistBatchRequest, opts ...grpc.CallOption) (*PersistResponse, error) {
+	out := new(PersistResponse)
+	err := c.cc.Invoke(ctx, "/Lightpeer/PersistBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *lightpeerClient) Query(ctx context.Context, in *EmptyQueryRequest, opts ...grpc.CallOption) (Lightpeer_QueryClient, error) {
 	stream, err := c.cc.NewStream(ctx, &_Lightpeer_serviceDesc.Streams[1], "/Lightpeer/Query", opts...)
 	if err != nil {
@@ -618,39 +2372,308 @@ func (c *lightpeerClient) Query(ctx context.Context, in *EmptyQueryRequest, opts
 	return x, nil
 }
 
-type Lightpeer_QueryClient interface {
-	Recv() (*QueryResponse, error)
+type Lightpeer_QueryClient interface {
+	Recv() (*QueryResponse, error)
+	grpc.ClientStream
+}
+
+type lightpeerQueryClient struct {
+	grpc.ClientStream
+}
+
+func (x *lightpeerQueryClient) Recv() (*QueryResponse, error) {
+	m := new(QueryResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *lightpeerClient) NotifyNewBlock(ctx context.Context, in *Lightblock, opts ...grpc.CallOption) (*NewBlockResponse, error) {
+	out := new(NewBlockResponse)
+	err := c.cc.Invoke(ctx, "/Lightpeer/NotifyNewBlock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lightpeerClient) Replicate(ctx context.Context, opts ...grpc.CallOption) (Lightpeer_ReplicateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Lightpeer_serviceDesc.Streams[2], "/Lightpeer/Replicate", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &lightpeerReplicateClient{stream}
+	return x, nil
+}
+
+type Lightpeer_ReplicateClient interface {
+	Send(*ReplicationMessage) error
+	Recv() (*ReplicationAck, error)
+	grpc.ClientStream
+}
+
+type lightpeerReplicateClient struct {
+	grpc.ClientStream
+}
+
+func (x *lightpeerReplicateClient) Send(m *ReplicationMessage) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *lightpeerReplicateClient) Recv() (*ReplicationAck, error) {
+	m := new(ReplicationAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *lightpeerClient) Probe(ctx context.Context, in *ProbeRequest, opts ...grpc.CallOption) (*ProbeResponse, error) {
+	out := new(ProbeResponse)
+	err := c.cc.Invoke(ctx, "/Lightpeer/Probe", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lightpeerClient) ProposeMembershipChange(ctx context.Context, in *MembershipChange, opts ...grpc.CallOption) (*MembershipChangeResponse, error) {
+	out := new(MembershipChangeResponse)
+	err := c.cc.Invoke(ctx, "/Lightpeer/ProposeMembershipChange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lightpeerClient) GetStatus(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, "/Lightpeer/GetStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lightpeerClient) GetMembershipHistory(ctx context.Context, in *MembershipHistoryRequest, opts ...grpc.CallOption) (*MembershipHistoryResponse, error) {
+	out := new(MembershipHistoryResponse)
+	err := c.cc.Invoke(ctx, "/Lightpeer/GetMembershipHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lightpeerClient) WatchMembership(ctx context.Context, in *WatchMembershipRequest, opts ...grpc.CallOption) (Lightpeer_WatchMembershipClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Lightpeer_serviceDesc.Streams[3], "/Lightpeer/WatchMembership", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &lightpeerWatchMembershipClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Lightpeer_WatchMembershipClient interface {
+	Recv() (*MembershipEvent, error)
+	grpc.ClientStream
+}
+
+type lightpeerWatchMembershipClient struct {
+	grpc.ClientStream
+}
+
+func (x *lightpeerWatchMembershipClient) Recv() (*MembershipEvent, error) {
+	m := new(MembershipEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *lightpeerClient) GetMerkleRoot(ctx context.Context, in *MerkleRootRequest, opts ...grpc.CallOption) (*MerkleRootResponse, error) {
+	out := new(MerkleRootResponse)
+	err := c.cc.Invoke(ctx, "/Lightpeer/GetMerkleRoot", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lightpeerClient) GetBlocks(ctx context.Context, in *BlocksRequest, opts ...grpc.CallOption) (Lightpeer_GetBlocksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Lightpeer_serviceDesc.Streams[4], "/Lightpeer/GetBlocks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &lightpeerGetBlocksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Lightpeer_GetBlocksClient interface {
+	Recv() (*Lightblock, error)
+	grpc.ClientStream
+}
+
+type lightpeerGetBlocksClient struct {
+	grpc.ClientStream
+}
+
+func (x *lightpeerGetBlocksClient) Recv() (*Lightblock, error) {
+	m := new(Lightblock)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *lightpeerClient) GetBlock(ctx context.Context, in *BlockRequest, opts ...grpc.CallOption) (*Lightblock, error) {
+	out := new(Lightblock)
+	err := c.cc.Invoke(ctx, "/Lightpeer/GetBlock", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lightpeerClient) GetBlockByHeight(ctx context.Context, in *BlockByHeightRequest, opts ...grpc.CallOption) (*Lightblock, error) {
+	out := new(Lightblock)
+	err := c.cc.Invoke(ctx, "/Lightpeer/GetBlockByHeight", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *lightpeerClient) Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (Lightpeer_ExportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Lightpeer_serviceDesc.Streams[5], "/Lightpeer/Export", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &lightpeerExportClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Lightpeer_ExportClient interface {
+	Recv() (*ArchiveChunk, error)
+	grpc.ClientStream
+}
+
+type lightpeerExportClient struct {
+	grpc.ClientStream
+}
+
+func (x *lightpeerExportClient) Recv() (*ArchiveChunk, error) {
+	m := new(ArchiveChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *lightpeerClient) Import(ctx context.Context, opts ...grpc.CallOption) (Lightpeer_ImportClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Lightpeer_serviceDesc.Streams[6], "/Lightpeer/Import", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &lightpeerImportClient{stream}
+	return x, nil
+}
+
+type Lightpeer_ImportClient interface {
+	Send(*ArchiveChunk) error
+	CloseAndRecv() (*ImportResponse, error)
+	grpc.ClientStream
+}
+
+type lightpeerImportClient struct {
 	grpc.ClientStream
 }
 
-type lightpeerQueryClient struct {
-	grpc.ClientStream
+func (x *lightpeerImportClient) Send(m *ArchiveChunk) error {
+	return x.ClientStream.SendMsg(m)
 }
 
-func (x *lightpeerQueryClient) Recv() (*QueryResponse, error) {
-	m := new(QueryResponse)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
+func (x *lightpeerImportClient) CloseAndRecv() (*ImportResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
 		return nil, err
 	}
-	return m, nil
-}
-
-func (c *lightpeerClient) NotifyNewBlock(ctx context.Context, in *Lightblock, opts ...grpc.CallOption) (*NewBlockResponse, error) {
-	out := new(NewBlockResponse)
-	err := c.cc.Invoke(ctx, "/Lightpeer/NotifyNewBlock", in, out, opts...)
-	if err != nil {
+	m := new(ImportResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
-	return out, nil
+	return m, nil
 }
 
 // LightpeerServer is the server API for Lightpeer service.
 type LightpeerServer interface {
+	// JoinNetwork tells the peer to join the network at the given address from JoinRequest.
+	// The peer will open a client connection to that address, and call ConnectNewPeer to join the network.
 	JoinNetwork(context.Context, *JoinRequest) (*JoinResponse, error)
+	// ConnectNewPeer accepts a connection from another peer, adding it to the network.
+	// If successful, it returns a stream of all the messages which were stored in the network
 	ConnectNewPeer(*ConnectRequest, Lightpeer_ConnectNewPeerServer) error
+	// LeaveNetwork tells the peer to leave the network. The peer stops checking the health of the network,
+	// delivers the blocks queued for other peers, and asks the coordinator to remove it from the network.
+	LeaveNetwork(context.Context, *LeaveRequest) (*LeaveResponse, error)
+	// Persist saves the state from the message on the chain
 	Persist(context.Context, *PersistRequest) (*PersistResponse, error)
+	// PersistBatch saves all the payloads from the message in a single block,
+	// such that they appear on the chain together or not at all.
+	PersistBatch(context.Context, *PersistBatchRequest) (*PersistResponse, error)
+	// Query returns all the chain states saved by a Persist or PersistBatch request, together with the block holding them
 	Query(*EmptyQueryRequest, Lightpeer_QueryServer) error
+	// NotifyNewBlock is used by peers to notify each other of block updates
 	NotifyNewBlock(context.Context, *Lightblock) (*NewBlockResponse, error)
+	// Replicate is a long lived stream between two peers, carrying new blocks in order.
+	// The receiving peer acknowledges every message with its sequence number once the block was processed.
+	Replicate(Lightpeer_ReplicateServer) error
+	// Probe is used by the failure detector to check that a peer is alive. When the target is set,
+	// the peer probes the target on behalf of the caller. Both sides exchange the membership states they know.
+	Probe(context.Context, *ProbeRequest) (*ProbeResponse, error)
+	// ProposeMembershipChange asks the coordinator of the network to record a membership change.
+	// Peers which are not the coordinator refuse the proposal.
+	ProposeMembershipChange(context.Context, *MembershipChange) (*MembershipChangeResponse, error)
+	// GetStatus returns the state of the peer, and its view of the network.
+	GetStatus(context.Context, *StatusRequest) (*StatusResponse, error)
+	// GetMembershipHistory returns the membership changes recorded by the NETWORK blocks, oldest first.
+	GetMembershipHistory(context.Context, *MembershipHistoryRequest) (*MembershipHistoryResponse, error)
+	// WatchMembership streams the membership changes as they are recorded by the peer.
+	WatchMembership(*WatchMembershipRequest, Lightpeer_WatchMembershipServer) error
+	// GetMerkleRoot returns the Merkle root of the block IDs between the given heights, used by the
+	// anti-entropy exchange for comparing chains. The range is capped at the height of the chain.
+	GetMerkleRoot(context.Context, *MerkleRootRequest) (*MerkleRootResponse, error)
+	// GetBlocks streams the blocks between the given heights, oldest first.
+	GetBlocks(*BlocksRequest, Lightpeer_GetBlocksServer) error
+	// GetBlock returns the block with the given ID, used by tools inspecting blocks and by peers repairing
+	// missing or corrupt blocks.
+	GetBlock(context.Context, *BlockRequest) (*Lightblock, error)
+	// GetBlockByHeight returns the block at the given height of the chain.
+	GetBlockByHeight(context.Context, *BlockByHeightRequest) (*Lightblock, error)
+	// Export streams an archive of the blocks between the given heights, for backups and for seeding
+	// other peers through Import.
+	Export(*ExportRequest, Lightpeer_ExportServer) error
+	// Import seeds a peer which holds no chain and did not join a network with the blocks of an archive
+	// streamed by the client. The client is admitted by the join policy of the peer. Members of a network
+	// import archives while they are stopped, through lightserver -import.
+	Import(Lightpeer_ImportServer) error
 }
 
 // UnimplementedLightpeerServer can be embedded to have forward compatible implementations.
@@ -663,15 +2686,57 @@ func (*UnimplementedLightpeerServer) JoinNetwork(ctx context.Context, req *JoinR
 func (*UnimplementedLightpeerServer) ConnectNewPeer(req *ConnectRequest, srv Lightpeer_ConnectNewPeerServer) error {
 	return status.Errorf(codes.Unimplemented, "method ConnectNewPeer not implemented")
 }
+func (*UnimplementedLightpeerServer) LeaveNetwork(ctx context.Context, req *LeaveRequest) (*LeaveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LeaveNetwork not implemented")
+}
 func (*UnimplementedLightpeerServer) Persist(ctx context.Context, req *PersistRequest) (*PersistResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Persist not implemented")
 }
+func (*UnimplementedLightpeerServer) PersistBatch(ctx context.Context, req *PersistBatchRequest) (*PersistResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PersistBatch not implemented")
+}
 func (*UnimplementedLightpeerServer) Query(req *EmptyQueryRequest, srv Lightpeer_QueryServer) error {
 	return status.Errorf(codes.Unimplemented, "method Query not implemented")
 }
 func (*UnimplementedLightpeerServer) NotifyNewBlock(ctx context.Context, req *Lightblock) (*NewBlockResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method NotifyNewBlock not implemented")
 }
+func (*UnimplementedLightpeerServer) Replicate(srv Lightpeer_ReplicateServer) error {
+	return status.Errorf(codes.Unimplemented, "method Replicate not implemented")
+}
+func (*UnimplementedLightpeerServer) Probe(ctx context.Context, req *ProbeRequest) (*ProbeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Probe not implemented")
+}
+func (*UnimplementedLightpeerServer) ProposeMembershipChange(ctx context.Context, req *MembershipChange) (*MembershipChangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ProposeMembershipChange not implemented")
+}
+func (*UnimplementedLightpeerServer) GetStatus(ctx context.Context, req *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (*UnimplementedLightpeerServer) GetMembershipHistory(ctx context.Context, req *MembershipHistoryRequest) (*MembershipHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMembershipHistory not implemented")
+}
+func (*UnimplementedLightpeerServer) WatchMembership(req *WatchMembershipRequest, srv Lightpeer_WatchMembershipServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchMembership not implemented")
+}
+func (*UnimplementedLightpeerServer) GetMerkleRoot(ctx context.Context, req *MerkleRootRequest) (*MerkleRootResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMerkleRoot not implemented")
+}
+func (*UnimplementedLightpeerServer) GetBlocks(req *BlocksRequest, srv Lightpeer_GetBlocksServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetBlocks not implemented")
+}
+func (*UnimplementedLightpeerServer) GetBlock(ctx context.Context, req *BlockRequest) (*Lightblock, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlock not implemented")
+}
+func (*UnimplementedLightpeerServer) GetBlockByHeight(ctx context.Context, req *BlockByHeightRequest) (*Lightblock, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlockByHeight not implemented")
+}
+func (*UnimplementedLightpeerServer) Export(req *ExportRequest, srv Lightpeer_ExportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Export not implemented")
+}
+func (*UnimplementedLightpeerServer) Import(srv Lightpeer_ImportServer) error {
+	return status.Errorf(codes.Unimplemented, "method Import not implemented")
+}
 
 func RegisterLightpeerServer(s *grpc.Server, srv LightpeerServer) {
 	s.RegisterService(&_Lightpeer_serviceDesc, srv)
@@ -716,6 +2781,24 @@ func (x *lightpeerConnectNewPeerServer) Send(m *Lightblock) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _Lightpeer_LeaveNetwork_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightpeerServer).LeaveNetwork(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Lightpeer/LeaveNetwork",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightpeerServer).LeaveNetwork(ctx, req.(*LeaveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Lightpeer_Persist_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(PersistRequest)
 	if err := dec(in); err != nil {
@@ -734,6 +2817,24 @@ func _Lightpeer_Persist_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Lightpeer_PersistBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PersistBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightpeerServer).PersistBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Lightpeer/PersistBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightpeerServer).PersistBatch(ctx, req.(*PersistBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Lightpeer_Query_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(EmptyQueryRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -773,6 +2874,247 @@ func _Lightpeer_NotifyNewBlock_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Lightpeer_Replicate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LightpeerServer).Replicate(&lightpeerReplicateServer{stream})
+}
+
+type Lightpeer_ReplicateServer interface {
+	Send(*ReplicationAck) error
+	Recv() (*ReplicationMessage, error)
+	grpc.ServerStream
+}
+
+type lightpeerReplicateServer struct {
+	grpc.ServerStream
+}
+
+func (x *lightpeerReplicateServer) Send(m *ReplicationAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *lightpeerReplicateServer) Recv() (*ReplicationMessage, error) {
+	m := new(ReplicationMessage)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Lightpeer_Probe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProbeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightpeerServer).Probe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Lightpeer/Probe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightpeerServer).Probe(ctx, req.(*ProbeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lightpeer_ProposeMembershipChange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MembershipChange)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightpeerServer).ProposeMembershipChange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Lightpeer/ProposeMembershipChange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightpeerServer).ProposeMembershipChange(ctx, req.(*MembershipChange))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lightpeer_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightpeerServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Lightpeer/GetStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightpeerServer).GetStatus(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lightpeer_GetMembershipHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MembershipHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightpeerServer).GetMembershipHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Lightpeer/GetMembershipHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightpeerServer).GetMembershipHistory(ctx, req.(*MembershipHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lightpeer_WatchMembership_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchMembershipRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LightpeerServer).WatchMembership(m, &lightpeerWatchMembershipServer{stream})
+}
+
+type Lightpeer_WatchMembershipServer interface {
+	Send(*MembershipEvent) error
+	grpc.ServerStream
+}
+
+type lightpeerWatchMembershipServer struct {
+	grpc.ServerStream
+}
+
+func (x *lightpeerWatchMembershipServer) Send(m *MembershipEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Lightpeer_GetMerkleRoot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleRootRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightpeerServer).GetMerkleRoot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Lightpeer/GetMerkleRoot",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightpeerServer).GetMerkleRoot(ctx, req.(*MerkleRootRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lightpeer_GetBlocks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BlocksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LightpeerServer).GetBlocks(m, &lightpeerGetBlocksServer{stream})
+}
+
+type Lightpeer_GetBlocksServer interface {
+	Send(*Lightblock) error
+	grpc.ServerStream
+}
+
+type lightpeerGetBlocksServer struct {
+	grpc.ServerStream
+}
+
+func (x *lightpeerGetBlocksServer) Send(m *Lightblock) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Lightpeer_GetBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightpeerServer).GetBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Lightpeer/GetBlock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightpeerServer).GetBlock(ctx, req.(*BlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lightpeer_GetBlockByHeight_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockByHeightRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LightpeerServer).GetBlockByHeight(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Lightpeer/GetBlockByHeight",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LightpeerServer).GetBlockByHeight(ctx, req.(*BlockByHeightRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Lightpeer_Export_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LightpeerServer).Export(m, &lightpeerExportServer{stream})
+}
+
+type Lightpeer_ExportServer interface {
+	Send(*ArchiveChunk) error
+	grpc.ServerStream
+}
+
+type lightpeerExportServer struct {
+	grpc.ServerStream
+}
+
+func (x *lightpeerExportServer) Send(m *ArchiveChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Lightpeer_Import_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LightpeerServer).Import(&lightpeerImportServer{stream})
+}
+
+type Lightpeer_ImportServer interface {
+	SendAndClose(*ImportResponse) error
+	Recv() (*ArchiveChunk, error)
+	grpc.ServerStream
+}
+
+type lightpeerImportServer struct {
+	grpc.ServerStream
+}
+
+func (x *lightpeerImportServer) SendAndClose(m *ImportResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *lightpeerImportServer) Recv() (*ArchiveChunk, error) {
+	m := new(ArchiveChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _Lightpeer_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "Lightpeer",
 	HandlerType: (*LightpeerServer)(nil),
@@ -781,14 +3123,50 @@ var _Lightpeer_serviceDesc = grpc.ServiceDesc{
 			MethodName: "JoinNetwork",
 			Handler:    _Lightpeer_JoinNetwork_Handler,
 		},
+		{
+			MethodName: "LeaveNetwork",
+			Handler:    _Lightpeer_LeaveNetwork_Handler,
+		},
 		{
 			MethodName: "Persist",
 			Handler:    _Lightpeer_Persist_Handler,
 		},
+		{
+			MethodName: "PersistBatch",
+			Handler:    _Lightpeer_PersistBatch_Handler,
+		},
 		{
 			MethodName: "NotifyNewBlock",
 			Handler:    _Lightpeer_NotifyNewBlock_Handler,
 		},
+		{
+			MethodName: "Probe",
+			Handler:    _Lightpeer_Probe_Handler,
+		},
+		{
+			MethodName: "ProposeMembershipChange",
+			Handler:    _Lightpeer_ProposeMembershipChange_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _Lightpeer_GetStatus_Handler,
+		},
+		{
+			MethodName: "GetMembershipHistory",
+			Handler:    _Lightpeer_GetMembershipHistory_Handler,
+		},
+		{
+			MethodName: "GetMerkleRoot",
+			Handler:    _Lightpeer_GetMerkleRoot_Handler,
+		},
+		{
+			MethodName: "GetBlock",
+			Handler:    _Lightpeer_GetBlock_Handler,
+		},
+		{
+			MethodName: "GetBlockByHeight",
+			Handler:    _Lightpeer_GetBlockByHeight_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -801,6 +3179,32 @@ var _Lightpeer_serviceDesc = grpc.ServiceDesc{
 			Handler:       _Lightpeer_Query_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Replicate",
+			Handler:       _Lightpeer_Replicate_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchMembership",
+			Handler:       _Lightpeer_WatchMembership_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "GetBlocks",
+			Handler:       _Lightpeer_GetBlocks_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Export",
+			Handler:       _Lightpeer_Export_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Import",
+			Handler:       _Lightpeer_Import_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "lightpeer.proto",
 }
@@ -0,0 +1,154 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discovery finds the peers of a klight network from the Kubernetes API, such that the peers
+// can form the network by themselves, without the klight controller.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+
+	lpack "github.com/stefanprisca/lightchain/src/lightpeer"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// the label and port name conventions are shared with the klight controller
+	klightNetworkLabel string = "klight.networkId"
+	klightPodPort      string = "klightPort"
+	defaultPort        int32  = 9081
+)
+
+// EndpointSlices discovers the peers of a network from the EndpointSlices labelled with the klight.networkId
+// of the network. EndpointSlices inherit the labels of their Service, so labelling the Service covering the
+// peers, typically the headless Service of a StatefulSet, is enough.
+type EndpointSlices struct {
+	Client    kubernetes.Interface
+	Namespace string
+	NetworkID string
+}
+
+func (es *EndpointSlices) selector() string {
+	return labels.Set{klightNetworkLabel: es.NetworkID}.String()
+}
+
+// Discover lists the addresses of the ready endpoints of the network.
+func (es *EndpointSlices) Discover(ctx context.Context) ([]string, error) {
+	slices, err := es.Client.DiscoveryV1().EndpointSlices(es.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: es.selector(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list the endpoints of network %s: %v", es.NetworkID, err)
+	}
+
+	addresses := []string{}
+	for i := range slices.Items {
+		addresses = append(addresses, endpointAddresses(&slices.Items[i], true)...)
+	}
+	return addresses, nil
+}
+
+// Watch removes peers from the network when their endpoints are deleted, until the context is done.
+// Endpoints which are only not ready are kept, since the failure detector takes care of unresponsive peers.
+func (es *EndpointSlices) Watch(ctx context.Context, lp *lpack.Lightpeer) {
+	factory := informers.NewSharedInformerFactoryWithOptions(es.Client, 0,
+		informers.WithNamespace(es.Namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = es.selector()
+		}))
+	informer := factory.Discovery().V1().EndpointSlices()
+
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			es.evictRemoved(ctx, lp, informer.Lister().EndpointSlices(es.Namespace).List)
+		},
+		DeleteFunc: func(obj interface{}) {
+			es.evictRemoved(ctx, lp, informer.Lister().EndpointSlices(es.Namespace).List)
+		},
+	})
+	if err != nil {
+		log.Printf("could not watch the endpoints of network %s: %v", es.NetworkID, err)
+		return
+	}
+
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+}
+
+// evictRemoved evicts the members of the network which are not listed by any of the slices.
+func (es *EndpointSlices) evictRemoved(ctx context.Context, lp *lpack.Lightpeer,
+	list func(labels.Selector) ([]*discoveryv1.EndpointSlice, error)) {
+
+	slices, err := list(labels.Everything())
+	if err != nil {
+		log.Printf("could not list the endpoints of network %s: %v", es.NetworkID, err)
+		return
+	}
+
+	listed := map[string]bool{}
+	for _, slice := range slices {
+		for _, address := range endpointAddresses(slice, false) {
+			listed[address] = true
+		}
+	}
+
+	if len(listed) == 0 {
+		// the network is not published by the Service, rather than being gone
+		return
+	}
+
+	removed := []string{}
+	for _, peer := range lp.GetNetwork() {
+		if !listed[peer.Address] {
+			removed = append(removed, peer.Address)
+		}
+	}
+	if len(removed) == 0 {
+		return
+	}
+	if err := lp.EvictPeers(ctx, removed); err != nil {
+		log.Printf("could not evict removed peers %v: %v", removed, err)
+	}
+}
+
+// endpointAddresses returns the lightpeer addresses of the endpoints in the slice.
+func endpointAddresses(slice *discoveryv1.EndpointSlice, readyOnly bool) []string {
+	port := defaultPort
+	for _, p := range slice.Ports {
+		if p.Port != nil && (len(slice.Ports) == 1 || (p.Name != nil && *p.Name == klightPodPort)) {
+			port = *p.Port
+		}
+	}
+
+	addresses := []string{}
+	for _, endpoint := range slice.Endpoints {
+		if readyOnly && endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+			continue
+		}
+		for _, ip := range endpoint.Addresses {
+			addresses = append(addresses, net.JoinHostPort(ip, strconv.Itoa(int(port))))
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	lpack "github.com/stefanprisca/lightchain/src/lightpeer"
+	"go.opentelemetry.io/otel/api/global"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func endpointSlice(name, networkId string, port int32, ready map[string]bool) *discoveryv1.EndpointSlice {
+	portName := klightPodPort
+	slice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{klightNetworkLabel: networkId},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Ports:       []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+	}
+	for ip, isReady := range ready {
+		isReady := isReady
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{ip},
+			Conditions: discoveryv1.EndpointConditions{Ready: &isReady},
+		})
+	}
+	return slice
+}
+
+func TestDiscoverListsReadyEndpointsOfTheNetwork(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		endpointSlice("klight-a", "a", 9090, map[string]bool{"10.0.0.1": true, "10.0.0.2": false}),
+		endpointSlice("klight-b", "b", 9090, map[string]bool{"10.0.1.1": true}))
+
+	es := &EndpointSlices{Client: client, Namespace: "default", NetworkID: "a"}
+	addresses, err := es.Discover(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:9090"}, addresses)
+}
+
+func TestWatchEvictsRemovedEndpoints(t *testing.T) {
+	self := pb.PeerInfo{Address: "127.0.0.1:9081"}
+	removed := pb.PeerInfo{Address: "127.0.0.2:9081"}
+	lp := &lpack.Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        self,
+		Network:     []pb.PeerInfo{self, removed},
+	}
+
+	slice := endpointSlice("klight-a", "a", 9081, map[string]bool{"127.0.0.1": true, "127.0.0.2": true})
+	client := fake.NewSimpleClientset(slice)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	es := &EndpointSlices{Client: client, Namespace: "default", NetworkID: "a"}
+	go es.Watch(ctx, lp)
+
+	<-time.After(100 * time.Millisecond)
+	notReady := endpointSlice("klight-a", "a", 9081, map[string]bool{"127.0.0.1": true, "127.0.0.2": false})
+	_, err := client.DiscoveryV1().EndpointSlices("default").Update(ctx, notReady, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	<-time.After(100 * time.Millisecond)
+	assert.Len(t, lp.Network, 2, "peers which are not ready should be left to the failure detector")
+
+	deleted := endpointSlice("klight-a", "a", 9081, map[string]bool{"127.0.0.1": true})
+	_, err = client.DiscoveryV1().EndpointSlices("default").Update(ctx, deleted, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return len(lp.Network) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, self.Address, lp.Network[0].Address)
+	assert.Equal(t, pb.Lightblock_NETWORK, lp.GetState().Type)
+}
@@ -39,6 +39,10 @@ func (klp *klightpeer) Persist(ctx context.Context, tReq *pb.PersistRequest) (*p
 	return klp.LightpeerServer.Persist(ctx, tReq)
 }
 
+func (klp *klightpeer) PersistBatch(ctx context.Context, bReq *pb.PersistBatchRequest) (*pb.PersistResponse, error) {
+	return klp.LightpeerServer.PersistBatch(ctx, bReq)
+}
+
 func (klp *klightpeer) Query(qReq *pb.EmptyQueryRequest, stream pb.Lightpeer_QueryServer) error {
 	return klp.LightpeerServer.Query(qReq, stream)
 }
@@ -61,7 +65,42 @@ func (klp *klightpeer) NotifyNewBlock(ctx context.Context, newBlock *pb.Lightblo
 	return newBlockRsvp, err
 }
 
+func (klp *klightpeer) Replicate(stream pb.Lightpeer_ReplicateServer) error {
+	return klp.LightpeerServer.Replicate(&stateFileReplicateStream{
+		Lightpeer_ReplicateServer: stream,
+		klp:                       klp,
+		blocks:                    map[uint64]*pb.Lightblock{},
+	})
+}
+
+// stateFileReplicateStream updates the state file with the replicated blocks which were accepted by the peer.
+type stateFileReplicateStream struct {
+	pb.Lightpeer_ReplicateServer
+	klp    *klightpeer
+	blocks map[uint64]*pb.Lightblock
+}
+
+func (s *stateFileReplicateStream) Recv() (*pb.ReplicationMessage, error) {
+	msg, err := s.Lightpeer_ReplicateServer.Recv()
+	if err == nil && msg.Block != nil {
+		s.blocks[msg.Sequence] = msg.Block
+	}
+	return msg, err
+}
+
+func (s *stateFileReplicateStream) Send(ack *pb.ReplicationAck) error {
+	block, ok := s.blocks[ack.Sequence]
+	delete(s.blocks, ack.Sequence)
+	if ok && ack.Error == "" && ack.MissingBlockID == "" && block.Type != pb.Lightblock_NETWORK {
+		s.klp.updateStateFile(block)
+	}
+	return s.Lightpeer_ReplicateServer.Send(ack)
+}
+
 func (klp *klightpeer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if hs, ok := klp.LightpeerServer.(healthpb.HealthServer); ok {
+		return hs.Check(ctx, in)
+	}
 	return &healthpb.HealthCheckResponse{
 		Status: healthpb.HealthCheckResponse_SERVING,
 	}, nil
@@ -97,5 +136,10 @@ func (klp *klightpeer) startFileListener() {
 
 func (klp *klightpeer) updateStateFile(block *pb.Lightblock) error {
 	klp.lastModTime = time.Now()
-	return ioutil.WriteFile(klp.statePath, block.Payload, 0644)
+	payload := block.Payload
+	// group commits hold several states, the last entry being the latest one
+	if len(block.Entries) > 0 {
+		payload = block.Entries[len(block.Entries)-1].Payload
+	}
+	return ioutil.WriteFile(klp.statePath, payload, 0644)
 }
@@ -17,6 +17,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -114,6 +115,68 @@ func TestKLightPeerIgnoresNetworkBlocks(t *testing.T) {
 	assert.Equal(t, expectedPayload, actualPayload)
 }
 
+func TestKLightPeerWritesReplicatedBlocks(t *testing.T) {
+
+	statePath := "testdata/listenertest"
+	os.Create(statePath)
+
+	mockLP := &mockLightpeer{}
+	klp := klightpeer{LightpeerServer: mockLP, statePath: statePath}
+
+	expectedPayload := []byte("foo")
+	stream := &mockReplicateStream{messages: []*pb.ReplicationMessage{
+		{Sequence: 1, Block: &pb.Lightblock{Type: pb.Lightblock_CLIENT, Payload: expectedPayload}},
+		{Sequence: 2, Block: &pb.Lightblock{Type: pb.Lightblock_NETWORK, Payload: []byte("bar")}},
+	}}
+	err := klp.Replicate(stream)
+	assert.Nil(t, err)
+	assert.Len(t, stream.acks, 2)
+
+	actualPayload, err := ioutil.ReadFile(statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedPayload, actualPayload)
+}
+
+func TestKLightPeerIgnoresRejectedReplicatedBlocks(t *testing.T) {
+
+	statePath := "testdata/listenertest"
+	os.Create(statePath)
+
+	mockLP := &mockLightpeer{errorOnNewBlock: fmt.Errorf("error")}
+	klp := klightpeer{LightpeerServer: mockLP, statePath: statePath}
+
+	stream := &mockReplicateStream{messages: []*pb.ReplicationMessage{
+		{Sequence: 1, Block: &pb.Lightblock{Type: pb.Lightblock_CLIENT, Payload: []byte("foo")}},
+	}}
+	err := klp.Replicate(stream)
+	assert.Nil(t, err)
+
+	expectedPayload := []byte{}
+	actualPayload, err := ioutil.ReadFile(statePath)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedPayload, actualPayload)
+}
+
+type mockReplicateStream struct {
+	pb.Lightpeer_ReplicateServer
+	messages []*pb.ReplicationMessage
+	acks     []*pb.ReplicationAck
+}
+
+func (s *mockReplicateStream) Recv() (*pb.ReplicationMessage, error) {
+	if len(s.messages) == 0 {
+		return nil, io.EOF
+	}
+	msg := s.messages[0]
+	s.messages = s.messages[1:]
+	return msg, nil
+}
+
+func (s *mockReplicateStream) Send(ack *pb.ReplicationAck) error {
+	s.acks = append(s.acks, ack)
+	return nil
+}
+
 type mockLightpeer struct {
 	lpb.UnimplementedLightpeerServer
 
@@ -126,6 +189,20 @@ func (mlp *mockLightpeer) Persist(ctx context.Context, tReq *pb.PersistRequest)
 	return nil, nil
 }
 
+func (mlp *mockLightpeer) Replicate(stream pb.Lightpeer_ReplicateServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		ack := &pb.ReplicationAck{Sequence: msg.Sequence}
+		if mlp.errorOnNewBlock != nil {
+			ack.Error = mlp.errorOnNewBlock.Error()
+		}
+		stream.Send(ack)
+	}
+}
+
 func (mlp *mockLightpeer) NotifyNewBlock(ctx context.Context, newBlock *pb.Lightblock) (*pb.NewBlockResponse, error) {
 	return nil, mlp.errorOnNewBlock
 }
@@ -15,56 +15,82 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
 
+	"github.com/stefanprisca/lightchain/klight/klightpeer/discovery"
 	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
 	lpack "github.com/stefanprisca/lightchain/src/lightpeer"
 	grpctrace "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc"
 	"go.opentelemetry.io/otel/api/global"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
+// namespaceFile holds the namespace of the pod, mounted with the service account.
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
 func main() {
 	var verbose = flag.Bool("v", false, "runs verbose - gathering traces with otel")
 	var blockRepo = flag.String("repo", "testdata", "repo for storing the generated blocks")
 	var otlpBackend = flag.String("otlp", lpack.OTLPAddress, "backend address for otlp traces and metrics")
 	var host = flag.String("host", "", "the host to listen to")
 	var port = flag.Int("port", 9081, "the port")
+	var advertise = flag.String("advertise", "", "address advertised to the other peers, as host or host:port, defaults to the address of -interface")
+	var iface = flag.String("interface", "", "network interface whose address is advertised, defaults to the -host address or the first interface which is up")
 
 	var statePath = flag.String("statePath", "", "the path to the state file")
+	var networkId = flag.String("networkId", "", "klight.networkId of the EndpointSlices to discover peers from, empty leaves joining to the klight controller")
+	var namespace = flag.String("namespace", "", "namespace of the EndpointSlices, defaults to the namespace of the pod")
+	var discoveryInterval = flag.Duration("discoveryInterval", lpack.DefaultDiscoveryInterval, "interval between looking for peers to join while the peer is alone")
 	flag.Parse()
 
 	log.Printf("Starting the lightpeer with options: v: %v ; repo: %s ; otlp: %s\n",
 		*verbose, *blockRepo, *otlpBackend)
 
+	// the identity is loaded before anything is started, such that a broken repo stops the peer right away
+	nodeID, err := lpack.NodeID(*blockRepo)
+	if err != nil {
+		log.Fatalf("failed to load the node ID: %v", err)
+	}
+	var endpoints *discovery.EndpointSlices
+	if *networkId != "" {
+		endpoints, err = newEndpointSlices(*networkId, *namespace)
+		if err != nil {
+			log.Fatalf("failed to set up the discovery: %v", err)
+		}
+	}
+
 	if *verbose {
 		otelFinalizer := lpack.InitOtel(*otlpBackend, lpack.ServiceName)
 		defer otelFinalizer()
 	}
-	listenerAddress := fmt.Sprintf("%s:%d", *host, *port)
+	listenerAddress := net.JoinHostPort(*host, strconv.Itoa(*port))
 	lis, err := net.Listen("tcp", listenerAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	localIp, err := getLocalIP()
+	peerAddress, err := lpack.AdvertiseAddress(*advertise, *iface, *host, *port)
 	if err != nil {
-		log.Fatalf("failed to get ip: %v", err)
+		log.Fatalf("failed to get the advertise address: %v", err)
 	}
-
-	peerAddress := fmt.Sprintf("%s:%d", localIp, *port)
 	tr := global.Tracer(fmt.Sprintf("%s-server@%s", lpack.ServiceName, peerAddress))
 	grpcServer := grpc.NewServer(
 		grpc.UnaryInterceptor(grpctrace.UnaryServerInterceptor(tr)),
 		grpc.StreamInterceptor(grpctrace.StreamServerInterceptor(tr)))
 
-	meta := pb.PeerInfo{Address: peerAddress}
+	meta := pb.PeerInfo{Address: peerAddress, ID: nodeID}
 	lp := &lpack.Lightpeer{
 		Tracer:      tr,
 		StoragePath: *blockRepo,
@@ -82,23 +108,48 @@ func main() {
 	pb.RegisterLightpeerServer(grpcServer, klp)
 	healthpb.RegisterHealthServer(grpcServer, klp)
 
-	nhc := lpack.NetworkHealthChecker{Lp: lp}
-	nhc.StartPeerHealthCheck()
+	nhc := &lpack.NetworkHealthChecker{Lp: lp}
+	nhc.StartPeerHealthCheck(context.Background())
 
 	defer nhc.StopPeerHealthCheck()
+
+	discoveryCtx, stopDiscovery := context.WithCancel(context.Background())
+	defer stopDiscovery()
+	if endpoints != nil {
+		go lp.AutoJoin(discoveryCtx, endpoints, *discoveryInterval)
+		go endpoints.Watch(discoveryCtx, lp)
+	}
+
+	lpack.LeaveOnTerminate(grpcServer, klp, stopDiscovery)
 	log.Println("Start serving gRPC connections @ ", listenerAddress)
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
 
-func getLocalIP() (string, error) {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// newEndpointSlices creates the discovery of the peers through the EndpointSlices of the network. The peer
+// joins the discovered peers, and evicts the peers whose endpoints are removed. It is created before the
+// peer starts serving, such that a peer which cannot reach the cluster stops right away.
+func newEndpointSlices(networkId, namespace string) (*discovery.EndpointSlices, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the cluster config: %v", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to create the kubernetes client: %v", err)
+	}
+
+	if namespace == "" {
+		rawNamespace, err := ioutil.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the pod namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(rawNamespace))
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("the pod namespace is empty")
 	}
 
-	defer conn.Close()
-	localAddr := conn.LocalAddr().(*net.UDPAddr).IP
-	return fmt.Sprintf("%v", localAddr), nil
+	return &discovery.EndpointSlices{Client: clientset, Namespace: namespace, NetworkID: networkId}, nil
 }
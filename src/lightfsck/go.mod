@@ -0,0 +1,14 @@
+module github.com/stefanprisca/lightchain/src/lightfsck
+
+go 1.15
+
+replace (
+	github.com/stefanprisca/lightchain => ../../
+	github.com/stefanprisca/lightchain/src/lightpeer => ../lightpeer
+	go.opentelemetry.io/otel => go.opentelemetry.io/otel v0.11.0
+)
+
+require (
+	github.com/stefanprisca/lightchain v0.0.0-20200930090534-72e6139961be
+	github.com/stefanprisca/lightchain/src/lightpeer v0.0.0-20200929093804-5c21f182115a
+)
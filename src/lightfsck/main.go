@@ -0,0 +1,112 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// lightfsck checks the blocks stored by a stopped lightpeer, and optionally repairs the repo.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	lpack "github.com/stefanprisca/lightchain/src/lightpeer"
+)
+
+func main() {
+	var blockRepo = flag.String("repo", "testdata", "repo holding the blocks of a stopped peer")
+	var head = flag.String("head", "", "check the chain ending at this block instead of the saved head")
+	var gc = flag.Bool("gc", false, "remove the blocks which are not on the chain, from forks and orphans, requires -writeHead unless the chain ends at the saved head")
+	var writeHead = flag.Bool("writeHead", false, "save the head of the checked chain as the head of the peer")
+	flag.Parse()
+
+	report, err := lpack.CheckRepo(*blockRepo, *head)
+	if err != nil {
+		log.Fatalf("failed to check %s: %v", *blockRepo, err)
+	}
+	printReport(*blockRepo, report)
+
+	// the blocks above the checked head are removed as a fork, so the peer must restore the checked chain
+	if *gc && report.Head != report.SavedHead && !*writeHead {
+		log.Fatalf("not removing blocks, the checked head %s is not the saved head %q, use -writeHead to save it",
+			report.Head, report.SavedHead)
+	}
+	if *writeHead && report.Head != "" && report.Head != report.SavedHead {
+		if err := lpack.SaveHead(*blockRepo, report.Head); err != nil {
+			log.Fatalf("failed to save the head: %v", err)
+		}
+		fmt.Printf("saved head %s\n", report.Head)
+	}
+	if *gc {
+		removed, err := lpack.RemoveUnreachable(*blockRepo, report)
+		fmt.Printf("removed %d unreachable blocks\n", len(removed))
+		if err != nil {
+			log.Fatalf("failed to remove unreachable blocks: %v", err)
+		}
+	}
+
+	if !report.Healthy() {
+		os.Exit(1)
+	}
+}
+
+func printReport(blockRepo string, report *lpack.RepoReport) {
+	fmt.Printf("%s: %d blocks, chain of height %d with head %s\n", blockRepo, report.Blocks, report.Height, report.Head)
+	if report.SavedHead != "" && report.SavedHead != report.Head {
+		fmt.Printf("saved head %s differs from the checked head\n", report.SavedHead)
+	}
+
+	files := []string{}
+	for file := range report.Corrupt {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		fmt.Printf("corrupt file %s: %s\n", file, report.Corrupt[file])
+	}
+	for _, broken := range report.Broken {
+		fmt.Printf("broken chain: %s\n", broken)
+	}
+	for _, fork := range report.Forks {
+		fmt.Printf("fork of %d blocks after %s, ending with %s\n", len(fork.Blocks), fork.Parent, fork.Blocks[len(fork.Blocks)-1])
+	}
+	for _, orphan := range report.Orphans {
+		fmt.Printf("orphan block %s\n", orphan)
+	}
+
+	for _, event := range report.NetworkHistory {
+		fmt.Printf("network at height %d (%s): %s", event.Height, event.BlockID, addresses(event.Peers))
+		if len(event.Joined) > 0 {
+			fmt.Printf("; joined %s", addresses(event.Joined))
+		}
+		if len(event.Left) > 0 {
+			fmt.Printf("; left %s", addresses(event.Left))
+		}
+		if len(event.Updated) > 0 {
+			fmt.Printf("; moved %s", addresses(event.Updated))
+		}
+		fmt.Println()
+	}
+}
+
+func addresses(peers []*pb.PeerInfo) string {
+	list := []string{}
+	for _, peer := range peers {
+		list = append(list, peer.Address)
+	}
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/metric"
+	"go.opentelemetry.io/otel/label"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// JoinPolicy decides which peers are admitted into the network by ConnectNewPeer, and by the coordinator
+// recording their JOIN. For JOIN proposals, the caller is the member forwarding the request.
+// Admit returns the reason for refusing the peer, or nil if the peer may join.
+type JoinPolicy interface {
+	Admit(ctx context.Context, req *pb.ConnectRequest) error
+}
+
+// admit checks the connect request against the join policy of the peer. Peers without a policy admit everyone.
+func (lp *Lightpeer) admit(ctx context.Context, req *pb.ConnectRequest) error {
+	if lp.JoinPolicy == nil {
+		return nil
+	}
+
+	err := lp.JoinPolicy.Admit(ctx, req)
+	if err == nil {
+		return nil
+	}
+	lp.rejectedJoins().Add(ctx, 1, label.String("peer", lp.Meta.Address))
+	return status.Errorf(codes.PermissionDenied, "%s may not join the network: %v", req.Peer.Address, err)
+}
+
+func (lp *Lightpeer) rejectedJoins() metric.Int64Counter {
+	lp.admissionOnce.Do(func() {
+		meter := global.Meter(ServiceName)
+		counter, err := meter.NewInt64Counter("lightchain.joins.rejected",
+			metric.WithDescription("number of peers refused by the join policy"))
+		if err != nil {
+			log.Printf("could not register the join metrics: %v", err)
+		}
+		lp.rejectedJoinsCounter = counter
+	})
+	return lp.rejectedJoinsCounter
+}
+
+// TokenPolicy admits peers presenting a join token signed with the shared secret, until the token expires.
+type TokenPolicy struct {
+	Secret []byte
+}
+
+// NewJoinToken creates a join token accepted by a TokenPolicy with the same secret until expiresAt.
+func NewJoinToken(secret []byte, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + signJoinToken(secret, expiry)
+}
+
+func (tp *TokenPolicy) Admit(ctx context.Context, req *pb.ConnectRequest) error {
+	if req.Token == "" {
+		return fmt.Errorf("no join token")
+	}
+
+	parts := strings.SplitN(req.Token, ".", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("malformed join token")
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(signJoinToken(tp.Secret, parts[0]))) {
+		return fmt.Errorf("invalid join token")
+	}
+
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed join token expiry: %v", err)
+	}
+	if time.Now().After(time.Unix(expiry, 0)) {
+		return fmt.Errorf("join token expired at %v", time.Unix(expiry, 0))
+	}
+	return nil
+}
+
+func signJoinToken(secret []byte, expiry string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// AllowlistPolicy admits peers connecting from the listed networks. The address of the connection is
+// checked rather than the address advertised by the peer, which the peer is free to choose.
+type AllowlistPolicy struct {
+	Networks []*net.IPNet
+}
+
+// NewAllowlistPolicy parses the allowed networks, given as CIDRs or single IP addresses.
+func NewAllowlistPolicy(entries []string) (*AllowlistPolicy, error) {
+	ap := &AllowlistPolicy{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid allowlist entry %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ap.Networks = append(ap.Networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist entry %q: %v", entry, err)
+		}
+		ap.Networks = append(ap.Networks, ipNet)
+	}
+	return ap, nil
+}
+
+func (ap *AllowlistPolicy) Admit(ctx context.Context, req *pb.ConnectRequest) error {
+	caller, ok := peer.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("unknown caller address")
+	}
+	host, _, err := net.SplitHostPort(caller.Addr.String())
+	if err != nil {
+		host = caller.Addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("caller address %s is not an IP address", caller.Addr)
+	}
+
+	for _, ipNet := range ap.Networks {
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not on the allowlist", ip)
+}
+
+// AllOf admits the peers admitted by all the policies.
+func AllOf(policies ...JoinPolicy) JoinPolicy {
+	return allOf(policies)
+}
+
+type allOf []JoinPolicy
+
+func (policies allOf) Admit(ctx context.Context, req *pb.ConnectRequest) error {
+	for _, policy := range policies {
+		if err := policy.Admit(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// AdvertiseAddress returns the address other peers use for reaching this peer, which becomes its
+// PeerInfo.Address. In order of precedence, the address is:
+//   - advertise, given as a host name or IP address with an optional port, for peers behind NAT or a Service
+//   - the address of the network interface named iface
+//   - bindHost, if the peer listens on a specific address
+//   - the address of the first network interface which is up, preferring IPv4 over IPv6
+//
+// The port is used unless advertise has its own.
+func AdvertiseAddress(advertise, iface, bindHost string, port int) (string, error) {
+	portStr := strconv.Itoa(port)
+	if advertise != "" {
+		if host, advertisedPort, err := net.SplitHostPort(advertise); err == nil {
+			return net.JoinHostPort(host, advertisedPort), nil
+		}
+		host := strings.TrimSuffix(strings.TrimPrefix(advertise, "["), "]")
+		return net.JoinHostPort(host, portStr), nil
+	}
+
+	if iface != "" {
+		netIface, err := net.InterfaceByName(iface)
+		if err != nil {
+			return "", fmt.Errorf("could not find interface %s: %v", iface, err)
+		}
+		ip, err := interfaceIP(*netIface)
+		if err != nil {
+			return "", err
+		}
+		return net.JoinHostPort(ip.String(), portStr), nil
+	}
+
+	if ip := net.ParseIP(bindHost); bindHost != "" && (ip == nil || !ip.IsUnspecified()) {
+		return net.JoinHostPort(bindHost, portStr), nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", fmt.Errorf("could not list the network interfaces: %v", err)
+	}
+	var fallback net.IP
+	for _, netIface := range ifaces {
+		if netIface.Flags&net.FlagUp == 0 || netIface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ip, err := interfaceIP(netIface)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			return net.JoinHostPort(ip.String(), portStr), nil
+		}
+		if fallback == nil {
+			fallback = ip
+		}
+	}
+	if fallback == nil {
+		return "", fmt.Errorf("no network interface to advertise, use an explicit advertise address")
+	}
+	return net.JoinHostPort(fallback.String(), portStr), nil
+}
+
+// interfaceIP returns the first IPv4 address of the interface, or its first IPv6 address. Link local
+// addresses are only used for loopback interfaces.
+func interfaceIP(netIface net.Interface) (net.IP, error) {
+	addrs, err := netIface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the addresses of interface %s: %v", netIface.Name, err)
+	}
+
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP
+		if !ip.IsGlobalUnicast() && !ip.IsLoopback() {
+			continue
+		}
+		if ip.To4() != nil {
+			return ip, nil
+		}
+		if ipv6 == nil {
+			ipv6 = ip
+		}
+	}
+	if ipv6 == nil {
+		return nil, fmt.Errorf("interface %s has no usable address", netIface.Name)
+	}
+	return ipv6, nil
+}
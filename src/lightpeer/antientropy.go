@@ -0,0 +1,341 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/metric"
+	"go.opentelemetry.io/otel/label"
+)
+
+// DefaultAntiEntropyInterval is the period at which AntiEntropy compares chains when no interval is given.
+const DefaultAntiEntropyInterval = 30 * time.Second
+
+// Anti-entropy makes the chains of the peers converge, whichever way blocks were lost. Every interval, the
+// peer compares the Merkle root of its chain with the root of a random member. When the roots differ, the
+// first height where the chains diverge is found by a binary search over the roots of prefixes of the chains,
+// in O(log n) round trips, and the blocks of the member are fetched from that height on. Missing blocks are
+// appended to the chain. Mismatched blocks mean that the chain forked, and they are replaced when the chain
+// of the member is longer, or as long and with a smaller block ID at the divergent height, such that both
+// peers settle on the same chain.
+
+// AntiEntropy compares the chain with a random member every interval, repairing the differences, until the
+// context is done.
+func (lp *Lightpeer) AntiEntropy(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultAntiEntropyInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		peers := []string{}
+		for _, peer := range lp.GetNetwork() {
+			if peer.Address != lp.Meta.Address && !lp.members().suspected(peer.Address) {
+				peers = append(peers, peer.Address)
+			}
+		}
+		if len(peers) == 0 {
+			continue
+		}
+		lp.syncChain(ctx, peers[rand.Intn(len(peers))], false)
+	}
+}
+
+// syncChain compares the chain with the chain of the peer at the address, and repairs the differences.
+// Forks are replaced by the chain of the peer if replaceForks is set, regardless of their length.
+// It returns the number of blocks taken from the peer.
+func (lp *Lightpeer) syncChain(ctx context.Context, address string, replaceForks bool) (int, error) {
+	syncCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - anti-entropy with %s", lp.Meta.Address, address))
+	defer span.End()
+
+	mt, err := lp.syncMerkleTree()
+	if err != nil {
+		span.RecordError(syncCtx, err)
+		return 0, err
+	}
+	head, height := mt.blockID(mt.height()), mt.height()
+
+	conn, err := lp.dial(address)
+	if err != nil {
+		span.RecordError(syncCtx, err)
+		return 0, err
+	}
+	defer conn.Close()
+	client := pb.NewLightpeerClient(conn)
+
+	remote, err := client.GetMerkleRoot(syncCtx, &pb.MerkleRootRequest{From: 1, To: height})
+	if err != nil {
+		err = fmt.Errorf("could not get the Merkle root of %s: %v", address, err)
+		span.RecordError(syncCtx, err)
+		return 0, err
+	}
+
+	// the peer capped the range at its own height
+	common := height
+	if remote.Height < common {
+		common = remote.Height
+	}
+	diverged := common + 1
+	if local, _ := mt.root(1, common); common > 0 && !bytes.Equal(local, remote.Root) {
+		// the chains diverge at the lowest height whose prefix roots differ
+		low, high := uint64(1), common
+		for low < high {
+			mid := low + (high-low)/2
+			prefix, err := client.GetMerkleRoot(syncCtx, &pb.MerkleRootRequest{From: 1, To: mid})
+			if err != nil {
+				err = fmt.Errorf("could not get the Merkle root of %s: %v", address, err)
+				span.RecordError(syncCtx, err)
+				return 0, err
+			}
+			if local, _ := mt.root(1, mid); bytes.Equal(local, prefix.Root) {
+				low = mid + 1
+			} else {
+				high = mid
+			}
+		}
+		diverged = low
+		span.AddEvent(syncCtx, fmt.Sprintf("chain diverges from %s at height %d", address, diverged))
+	}
+
+	if diverged > remote.Height || (diverged <= height && remote.Height < height && !replaceForks) {
+		// the peer has no blocks this peer is missing, or its fork is shorter
+		return 0, nil
+	}
+
+	blocks, err := lp.fetchBlocks(syncCtx, client, diverged, remote.Height)
+	if err != nil {
+		err = fmt.Errorf("could not fetch blocks from %s: %v", address, err)
+		span.RecordError(syncCtx, err)
+		return 0, err
+	}
+	if len(blocks) == 0 {
+		return 0, nil
+	}
+	if diverged <= height && remote.Height == height && blocks[0].ID >= mt.blockID(diverged) && !replaceForks {
+		// forks of the same length are settled in favour of the smaller block ID
+		return 0, nil
+	}
+
+	discarded, err := lp.adoptBlocks(head, mt.blockID(diverged-1), diverged-1, blocks)
+	if err != nil {
+		err = fmt.Errorf("could not repair the chain from %s: %v", address, err)
+		span.RecordError(syncCtx, err)
+		return 0, err
+	}
+	if len(discarded) > 0 {
+		lp.discardedBlocks().Add(syncCtx, int64(len(discarded)), label.String("peer", lp.Meta.Address))
+		span.AddEvent(syncCtx, fmt.Sprintf("discarded the forked blocks %s", strings.Join(discarded, ", ")))
+	}
+	span.AddEvent(syncCtx, fmt.Sprintf("repaired %d blocks from height %d with the chain of %s", len(blocks), diverged, address))
+	return len(blocks), nil
+}
+
+func (lp *Lightpeer) fetchBlocks(ctx context.Context, client pb.LightpeerClient, from, to uint64) ([]pb.Lightblock, error) {
+	stream, err := client.GetBlocks(ctx, &pb.BlocksRequest{From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	blocks := []pb.Lightblock{}
+	for {
+		block, err := stream.Recv()
+		if err == io.EOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, *block)
+	}
+}
+
+// adoptBlocks replaces the blocks following the parent at parentHeight with blocks fetched from another
+// peer, as long as the head of the chain is still the expected one. It returns the IDs of the replaced blocks.
+func (lp *Lightpeer) adoptBlocks(expectedHead, parentID string, parentHeight uint64, blocks []pb.Lightblock) ([]string, error) {
+	// runs after the locks are released
+	defer lp.saveHead()
+	lp.commitLock.Lock()
+	defer lp.commitLock.Unlock()
+	lp.stateLock.Lock()
+	defer lp.stateLock.Unlock()
+
+	if lp.state.ID != expectedHead {
+		return nil, fmt.Errorf("the chain changed during the exchange")
+	}
+	prevID := parentID
+	height := parentHeight
+	// the membership changes along the blocks, so each NETWORK block is checked against the one before it,
+	// while the first block of a chain starts the membership
+	network := lp.GetNetwork()
+	for _, block := range blocks {
+		height++
+		if block.PrevID != prevID {
+			return nil, fmt.Errorf("block %s does not link to %s", block.ID, prevID)
+		}
+		if err := verifyBlock(block, block.ID, lp.NetworkID, height); err != nil {
+			return nil, fmt.Errorf("block %s is invalid: %v", block.ID, err)
+		}
+		if err := lp.checkNetwork(block); err != nil {
+			return nil, err
+		}
+		if block.PrevID != "" {
+			if err := checkProposer(network, block); err != nil {
+				return nil, err
+			}
+		}
+		switch block.Type {
+		case pb.Lightblock_NETWORK:
+			var err error
+			if network, err = decodeNetwork(block); err != nil {
+				return nil, err
+			}
+		case pb.Lightblock_GENESIS:
+			genesis, err := DecodeGenesis(block)
+			if err != nil {
+				return nil, err
+			}
+			network = []pb.PeerInfo{}
+			for _, peer := range genesis.Network {
+				network = append(network, *peer)
+			}
+		}
+		prevID = block.ID
+	}
+
+	// the blocks after the parent are replaced, the blocks of a damaged chain are not all known
+	discarded := []string{}
+	for blockID := lp.state.ID; blockID != parentID && blockID != ""; {
+		discarded = append(discarded, blockID)
+		block, err := lp.readBlock(blockID)
+		if err != nil {
+			break
+		}
+		blockID = block.PrevID
+	}
+
+	for _, block := range blocks {
+		if err := lp.writeBlock(block); err != nil {
+			return nil, fmt.Errorf("could not persist block %s: %v", block.ID, err)
+		}
+		if block.Type == pb.Lightblock_GENESIS {
+			lp.genesisID = block.ID
+		}
+		lp.requests().recordBlock(block)
+		lp.gossip().markSeen(block.ID)
+	}
+	lp.setState(blocks[len(blocks)-1])
+
+	networkChanged := false
+	for _, block := range blocks {
+		if block.Type == pb.Lightblock_NETWORK {
+			if err := lp.adoptNetwork(block); err != nil {
+				return discarded, err
+			}
+			lp.publishMembership(block)
+			networkChanged = true
+		}
+	}
+	if networkChanged || lp.networkHeight < blocks[0].Height {
+		return discarded, nil
+	}
+
+	// a replaced block changed the membership, which is taken from the last NETWORK block before the fork
+	for blockID := parentID; blockID != ""; {
+		block, err := lp.readBlock(blockID)
+		if err != nil {
+			return discarded, fmt.Errorf("could not read block %s: %v", blockID, err)
+		}
+		if block.Type == pb.Lightblock_NETWORK {
+			return discarded, lp.adoptNetwork(block)
+		}
+		blockID = block.PrevID
+	}
+	return discarded, nil
+}
+
+func (lp *Lightpeer) discardedBlocks() metric.Int64Counter {
+	lp.antiEntropyOnce.Do(func() {
+		meter := global.Meter(ServiceName)
+		counter, err := meter.NewInt64Counter("lightchain.blocks.discarded",
+			metric.WithDescription("number of forked blocks replaced by the chain of another peer"))
+		if err != nil {
+			log.Printf("could not register the anti-entropy metrics: %v", err)
+		}
+		lp.discardedBlocksCounter = counter
+	})
+	return lp.discardedBlocksCounter
+}
+
+// adoptNetwork takes the membership from the NETWORK block. Must be called holding the state lock.
+func (lp *Lightpeer) adoptNetwork(block pb.Lightblock) error {
+	network, err := decodeNetwork(block)
+	if err != nil {
+		return err
+	}
+	lp.setNetwork(network)
+	lp.networkHeight = block.Height
+	lp.closeReplicationStreams(network)
+	return nil
+}
+
+// GetMerkleRoot returns the Merkle root of the block IDs between two heights.
+func (lp *Lightpeer) GetMerkleRoot(ctx context.Context, req *pb.MerkleRootRequest) (*pb.MerkleRootResponse, error) {
+	mt, err := lp.syncMerkleTree()
+	if err != nil {
+		return nil, err
+	}
+	root, height := mt.root(req.From, req.To)
+	return &pb.MerkleRootResponse{Root: root, Height: height}, nil
+}
+
+// GetBlocks streams the blocks between two heights, oldest first.
+func (lp *Lightpeer) GetBlocks(req *pb.BlocksRequest, stream pb.Lightpeer_GetBlocksServer) error {
+	getCtx, span := lp.Tracer.Start(stream.Context(), fmt.Sprintf("@%s - get blocks %d-%d", lp.Meta.Address, req.From, req.To))
+	defer span.End()
+
+	idx, err := lp.syncIndex()
+	if err != nil {
+		span.RecordError(getCtx, err)
+		return err
+	}
+	for _, blockID := range idx.blockIDs(req.From, req.To) {
+		block, err := lp.readBlock(blockID)
+		if err != nil {
+			err = fmt.Errorf("failed to read block %s: %v", blockID, err)
+			span.RecordError(getCtx, err)
+			return err
+		}
+		if err := stream.Send(&block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
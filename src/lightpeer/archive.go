@@ -0,0 +1,338 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ArchiveVersion is the version of the archive format written by ExportChain.
+const ArchiveVersion = 1
+
+// An archive holds a range of blocks of the chain as newline delimited JSON, optionally gzipped. The first
+// line is the manifest, followed by one line per block, oldest first, and a last line holding the SHA-256 of
+// all the lines before it. Blocks are encoded as they are stored, so archives can be inspected with the
+// usual JSON tools.
+
+// ArchiveManifest describes the blocks of an archive.
+type ArchiveManifest struct {
+	Version   int
+	NetworkID string
+	// From and To are the heights of the first and the last block of the archive.
+	From   uint64
+	To     uint64
+	Blocks int
+	// PrevID is the parent of the first block, empty for archives starting with the first block of the chain.
+	PrevID  string
+	Head    string
+	Created time.Time
+}
+
+type archiveChecksum struct {
+	SHA256 string
+}
+
+// ExportChain writes an archive of the blocks between two heights, both included. A zero to exports up to
+// the head.
+func (lp *Lightpeer) ExportChain(w io.Writer, from, to uint64, compress bool) (*ArchiveManifest, error) {
+	idx, err := lp.syncIndex()
+	if err != nil {
+		return nil, fmt.Errorf("could not index the chain: %v", err)
+	}
+	if from == 0 {
+		from = 1
+	}
+	blockIDs := idx.blockIDs(from, to)
+	if len(blockIDs) == 0 {
+		return nil, fmt.Errorf("no blocks from height %d, the chain has height %d", from, idx.height())
+	}
+	manifest := &ArchiveManifest{
+		Version:   ArchiveVersion,
+		NetworkID: lp.networkID(),
+		From:      from,
+		To:        from + uint64(len(blockIDs)) - 1,
+		Blocks:    len(blockIDs),
+		PrevID:    idx.blockID(from - 1),
+		Head:      blockIDs[len(blockIDs)-1],
+		Created:   time.Now().UTC(),
+	}
+
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	out := bufio.NewWriter(w)
+	hash := sha256.New()
+	body := io.MultiWriter(out, hash)
+
+	if err := writeLine(body, manifest); err != nil {
+		return nil, err
+	}
+	for i, blockID := range blockIDs {
+		block, err := lp.loadBlock(blockID, from+uint64(i))
+		if err != nil {
+			return nil, fmt.Errorf("could not read block %s: %v", blockID, err)
+		}
+		if err := writeLine(body, block); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeLine(out, archiveChecksum{SHA256: hex.EncodeToString(hash.Sum(nil))}); err != nil {
+		return nil, err
+	}
+
+	if err := out.Flush(); err != nil {
+		return nil, fmt.Errorf("could not write the archive: %v", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return nil, fmt.Errorf("could not write the archive: %v", err)
+		}
+	}
+	return manifest, nil
+}
+
+func writeLine(w io.Writer, v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode the archive: %v", err)
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write the archive: %v", err)
+	}
+	return nil
+}
+
+// ImportChain adds the blocks of an archive to the chain, after checking the archive against its checksum
+// and the linkage of its blocks. The archive must start with the first block of the chain, or at most at
+// the height following the head; the blocks the chain already holds must be the same. It returns the
+// manifest of the archive and the number of blocks added to the chain.
+func (lp *Lightpeer) ImportChain(r io.Reader) (*ArchiveManifest, int, error) {
+	manifest, blocks, err := readArchive(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	idx, err := lp.syncIndex()
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not index the chain: %v", err)
+	}
+	// the index describes the stored chain until it is restored, while blocks are imported on top of the head
+	height := uint64(0)
+	if lp.GetState().ID != "" {
+		height = idx.height()
+	}
+	if manifest.From > height+1 {
+		return nil, 0, fmt.Errorf("the archive starts at height %d, above the chain of height %d", manifest.From, height)
+	}
+	if parent := idx.blockID(manifest.From - 1); parent != manifest.PrevID {
+		return nil, 0, fmt.Errorf("the archive links to block %s, while the chain has %q at height %d",
+			manifest.PrevID, parent, manifest.From-1)
+	}
+	for len(blocks) > 0 && manifest.From+uint64(manifest.Blocks-len(blocks)) <= height {
+		at := manifest.From + uint64(manifest.Blocks-len(blocks))
+		if idx.blockID(at) != blocks[0].ID {
+			return nil, 0, fmt.Errorf("the archive diverges from the chain at height %d", at)
+		}
+		blocks = blocks[1:]
+	}
+	if len(blocks) == 0 {
+		return manifest, 0, nil
+	}
+
+	head := idx.blockID(height)
+	if head == "" && lp.networkID() == "" {
+		lp.setNetworkID(manifest.NetworkID)
+	}
+	if _, err := lp.adoptBlocks(head, head, height, blocks); err != nil {
+		return nil, 0, fmt.Errorf("could not import the archive: %v", err)
+	}
+	return manifest, len(blocks), nil
+}
+
+// readArchive decodes an archive, checking its checksum and the linkage of its blocks.
+func readArchive(r io.Reader) (*ArchiveManifest, []pb.Lightblock, error) {
+	in := bufio.NewReader(r)
+	if magic, err := in.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not decompress the archive: %v", err)
+		}
+		defer gz.Close()
+		in = bufio.NewReader(gz)
+	}
+
+	// the last line is the checksum of the lines before it, so lines are decoded one line behind
+	hash := sha256.New()
+	var manifest *ArchiveManifest
+	blocks := []pb.Lightblock{}
+	var previous []byte
+	for {
+		line, err := in.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, nil, fmt.Errorf("could not read the archive: %v", err)
+		}
+		if previous != nil {
+			hash.Write(previous)
+			if manifest == nil {
+				manifest = &ArchiveManifest{}
+				if err := json.Unmarshal(previous, manifest); err != nil {
+					return nil, nil, fmt.Errorf("invalid archive manifest: %v", err)
+				}
+				if manifest.Version != ArchiveVersion {
+					return nil, nil, fmt.Errorf("unsupported archive version %d", manifest.Version)
+				}
+			} else {
+				block := pb.Lightblock{}
+				if err := json.Unmarshal(previous, &block); err != nil {
+					return nil, nil, fmt.Errorf("invalid block at line %d of the archive: %v", len(blocks)+2, err)
+				}
+				blocks = append(blocks, block)
+			}
+		}
+		previous = line
+	}
+
+	checksum := archiveChecksum{}
+	if manifest == nil || json.Unmarshal(previous, &checksum) != nil || checksum.SHA256 == "" {
+		return nil, nil, fmt.Errorf("the archive is truncated")
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != checksum.SHA256 {
+		return nil, nil, fmt.Errorf("the archive is corrupt, its checksum is %s instead of %s", sum, checksum.SHA256)
+	}
+
+	if len(blocks) != manifest.Blocks || len(blocks) == 0 || manifest.From == 0 {
+		return nil, nil, fmt.Errorf("the archive holds %d blocks, while its manifest lists %d from height %d",
+			len(blocks), manifest.Blocks, manifest.From)
+	}
+	prevID := manifest.PrevID
+	for i, block := range blocks {
+		if block.PrevID != prevID {
+			return nil, nil, fmt.Errorf("block %s of the archive does not link to %s", block.ID, prevID)
+		}
+		if height := manifest.From + uint64(i); block.Height > 0 && block.Height != height {
+			return nil, nil, fmt.Errorf("block %s of the archive records height %d instead of %d", block.ID, block.Height, height)
+		}
+		prevID = block.ID
+	}
+	if prevID != manifest.Head {
+		return nil, nil, fmt.Errorf("the archive ends with block %s instead of %s", prevID, manifest.Head)
+	}
+	return manifest, blocks, nil
+}
+
+// Export streams an archive of the blocks between the given heights.
+func (lp *Lightpeer) Export(req *pb.ExportRequest, stream pb.Lightpeer_ExportServer) error {
+	exportCtx, span := lp.Tracer.Start(stream.Context(), fmt.Sprintf("@%s - export %d-%d", lp.Meta.Address, req.From, req.To))
+	defer span.End()
+
+	manifest, err := lp.ExportChain(&chunkWriter{stream: stream}, req.From, req.To, req.Compress)
+	if err != nil {
+		span.RecordError(exportCtx, err)
+		return err
+	}
+	span.AddEvent(exportCtx, fmt.Sprintf("exported %d blocks up to %s", manifest.Blocks, manifest.Head))
+	return nil
+}
+
+// Import seeds the peer with the blocks of the archive streamed by the client. Imported blocks do not go
+// through the agreement of the network, and anti-entropy would spread them to the other members, so only
+// peers which hold no chain and did not join a network accept imports. The client is admitted like a peer
+// joining the network, with the token sent on the first chunk.
+func (lp *Lightpeer) Import(stream pb.Lightpeer_ImportServer) error {
+	importCtx, span := lp.Tracer.Start(stream.Context(), fmt.Sprintf("@%s - import", lp.Meta.Address))
+	defer span.End()
+
+	first, err := stream.Recv()
+	if err != nil {
+		span.RecordError(importCtx, err)
+		return err
+	}
+	importer := &pb.PeerInfo{Address: "unknown importer"}
+	if caller, ok := peer.FromContext(importCtx); ok {
+		importer.Address = caller.Addr.String()
+	}
+	if err := lp.admit(importCtx, &pb.ConnectRequest{Peer: importer, Token: first.Token}); err != nil {
+		span.RecordError(importCtx, err)
+		return err
+	}
+	if lp.GetState().ID != "" || len(lp.GetNetwork()) > 1 {
+		err := status.Errorf(codes.FailedPrecondition,
+			"%s holds a chain or belongs to a network, archives are imported into it while it is stopped", lp.Meta.Address)
+		span.RecordError(importCtx, err)
+		return err
+	}
+
+	_, imported, err := lp.ImportChain(&chunkReader{stream: stream, pending: first.Data})
+	if err != nil {
+		span.RecordError(importCtx, err)
+		return status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+	head := lp.GetState()
+	height, err := lp.height(head)
+	if err != nil {
+		span.RecordError(importCtx, err)
+		return err
+	}
+	span.AddEvent(importCtx, fmt.Sprintf("imported %d blocks, the chain ends with %s", imported, head.ID))
+	return stream.SendAndClose(&pb.ImportResponse{Imported: uint64(imported), Head: head.ID, Height: height})
+}
+
+// chunkWriter sends the archive written by ExportChain as chunks.
+type chunkWriter struct {
+	stream pb.Lightpeer_ExportServer
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if err := cw.stream.Send(&pb.ArchiveChunk{Data: append([]byte{}, p...)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// chunkReader reads the archive from the chunks received by Import.
+type chunkReader struct {
+	stream  pb.Lightpeer_ImportServer
+	pending []byte
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for len(cr.pending) == 0 {
+		chunk, err := cr.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		cr.pending = chunk.Data
+	}
+	n := copy(p, cr.pending)
+	cr.pending = cr.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxBatchSize is the number of requests packed into one block when Lightpeer.MaxBatchSize is not set.
+const DefaultMaxBatchSize = 64
+
+// The group committer packs persist requests arriving within Lightpeer.BatchWindow into a single block.
+// The block is replicated to the network once, and each caller gets a receipt with the index of its
+// payload inside the block.
+type groupCommitter struct {
+	lp       *Lightpeer
+	requests chan *pendingPersist
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+type pendingPersist struct {
+	req    *pb.PersistRequest
+	result chan persistResult
+}
+
+type persistResult struct {
+	receipt *pb.PersistResponse
+	err     error
+}
+
+func (lp *Lightpeer) batcher() *groupCommitter {
+	lp.batcherOnce.Do(func() {
+		lp.committer = &groupCommitter{
+			lp:       lp,
+			requests: make(chan *pendingPersist),
+			stop:     make(chan struct{}),
+			stopped:  make(chan struct{}),
+		}
+		go lp.committer.run()
+	})
+	return lp.committer
+}
+
+// StopGroupCommit stops the group committer, once the batch being committed completes. Later persist
+// requests are refused.
+func (lp *Lightpeer) StopGroupCommit() {
+	gc := lp.batcher()
+	gc.stopOnce.Do(func() { close(gc.stop) })
+	<-gc.stopped
+}
+
+// persist queues the request for the next group commit, and waits for its receipt.
+func (gc *groupCommitter) persist(ctx context.Context, req *pb.PersistRequest) (*pb.PersistResponse, error) {
+	pp := &pendingPersist{req: req, result: make(chan persistResult, 1)}
+
+	select {
+	case gc.requests <- pp:
+	case <-gc.stop:
+		return nil, status.Errorf(codes.Unavailable, "the group committer is stopped")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case res := <-pp.result:
+		return res.receipt, res.err
+	case <-ctx.Done():
+		// the request might still be committed, retrying with the same request ID returns its receipt.
+		return nil, ctx.Err()
+	}
+}
+
+// run commits the batches until the committer is stopped.
+func (gc *groupCommitter) run() {
+	defer close(gc.stopped)
+	for {
+		select {
+		case first := <-gc.requests:
+			gc.commit(gc.collect(first))
+		case <-gc.stop:
+			return
+		}
+	}
+}
+
+// collect gathers requests until the batch window expires or the batch is full.
+func (gc *groupCommitter) collect(first *pendingPersist) []*pendingPersist {
+	maxSize := gc.lp.MaxBatchSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBatchSize
+	}
+
+	batch := []*pendingPersist{first}
+	timeout := time.After(gc.lp.BatchWindow)
+	for len(batch) < maxSize {
+		select {
+		case pp := <-gc.requests:
+			batch = append(batch, pp)
+		case <-timeout:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (gc *groupCommitter) commit(batch []*pendingPersist) {
+	lp := gc.lp
+	commitCtx, span := lp.Tracer.Start(context.Background(), fmt.Sprintf("@%s - group commit", lp.Meta.Address))
+	defer span.End()
+
+	lightBlock := pb.Lightblock{
+		ID:   uuid.New().String(),
+		Type: pb.Lightblock_CLIENT,
+	}
+
+	// the committed requests are looked up under the commit lock, such that a retry committed by Persist
+	// in the meantime is not committed again
+	lp.commitLock.Lock()
+	defer lp.commitLock.Unlock()
+
+	// requests repeated inside the batch share the entry of the first one, and requests committed
+	// by a previous batch get their original receipt
+	indexes := make([]int, len(batch))
+	committed := make([]*pb.PersistResponse, len(batch))
+	entryOf := map[string]int{}
+	for i, pp := range batch {
+		if pp.req.RequestID != "" {
+			if receipt, ok := lp.requests().lookup(pp.req.RequestID); ok {
+				committed[i] = &receipt
+				continue
+			}
+			if idx, ok := entryOf[pp.req.RequestID]; ok {
+				indexes[i] = idx
+				continue
+			}
+			entryOf[pp.req.RequestID] = len(lightBlock.Entries)
+		}
+		indexes[i] = len(lightBlock.Entries)
+		lightBlock.Entries = append(lightBlock.Entries, &pb.BlockEntry{
+			Payload:   pp.req.Payload,
+			RequestID: pp.req.RequestID,
+		})
+	}
+
+	var err error
+	if len(lightBlock.Entries) > 0 {
+		span.AddEvent(commitCtx, fmt.Sprintf("committing %d requests in block %s", len(batch), lightBlock.ID))
+		err = lp.commitHeld(commitCtx, &lightBlock)
+		if err != nil {
+			span.RecordError(commitCtx, err)
+		}
+	}
+
+	for i, pp := range batch {
+		switch {
+		case committed[i] != nil:
+			pp.result <- persistResult{committed[i], nil}
+		case err != nil:
+			pp.result <- persistResult{nil, err}
+		default:
+			pp.result <- persistResult{&pb.PersistResponse{BlockID: lightBlock.ID, Index: int32(indexes[i])}, nil}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// membershipBlockTimeout bounds waiting for the NETWORK block of a change recorded by the coordinator.
+const membershipBlockTimeout = 5 * time.Second
+
+// Membership changes are funnelled through a single coordinator, such that concurrent changes do not create
+// competing NETWORK blocks. The coordinator is the first peer of the network which is not suspected by the
+// failure detector. All peers share the same network, so they agree on the coordinator, and when the
+// coordinator fails, the suspicion spreads and the next peer takes over.
+
+// coordinator returns the peer recording membership changes.
+func (lp *Lightpeer) coordinator() pb.PeerInfo {
+	for _, peer := range lp.GetNetwork() {
+		if peer.Address == lp.Meta.Address || !lp.members().suspected(peer.Address) {
+			return peer
+		}
+	}
+	return lp.Meta
+}
+
+// changeMembership records the change through the coordinator, returning the ID of the NETWORK block.
+func (lp *Lightpeer) changeMembership(ctx context.Context, change *pb.MembershipChange) (string, error) {
+	coordinator := lp.coordinator()
+	if coordinator.Address == lp.Meta.Address {
+		return lp.applyMembershipChange(ctx, change)
+	}
+
+	conn, err := lp.dial(coordinator.Address)
+	if err != nil {
+		return "", fmt.Errorf("did not connect to the coordinator: %v", err)
+	}
+	defer conn.Close()
+
+	proposer := lp.Meta
+	change.Proposer = &proposer
+
+	resp, err := pb.NewLightpeerClient(conn).ProposeMembershipChange(ctx, change)
+	if code := status.Code(err); code == codes.Unavailable || code == codes.DeadlineExceeded {
+		// speed up the failover, the suspicion is cleared if the coordinator answers the next probe
+		lp.members().suspect(coordinator.Address)
+	}
+	if err != nil {
+		return "", fmt.Errorf("coordinator %s did not record the membership change: %v", coordinator.Address, err)
+	}
+	return resp.BlockID, nil
+}
+
+// ProposeMembershipChange records a membership change proposed by another peer, if this peer is the coordinator.
+func (lp *Lightpeer) ProposeMembershipChange(ctx context.Context, change *pb.MembershipChange) (*pb.MembershipChangeResponse, error) {
+	proposeCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - membership change %v", lp.Meta.Address, change.Type))
+	defer span.End()
+
+	if coordinator := lp.coordinator(); coordinator.Address != lp.Meta.Address {
+		err := status.Errorf(codes.FailedPrecondition, "%s is not the coordinator, the coordinator is %s",
+			lp.Meta.Address, coordinator.Address)
+		span.RecordError(proposeCtx, err)
+		return nil, err
+	}
+
+	// only members change the membership, and peers only leave on their own behalf
+	if change.Proposer == nil || !containsPeer(lp.GetNetwork(), *change.Proposer) {
+		err := status.Errorf(codes.PermissionDenied, "the proposer of the membership change is not a member")
+		span.RecordError(proposeCtx, err)
+		return nil, err
+	}
+	if change.Type == pb.MembershipChange_LEAVE {
+		for _, peer := range change.Peers {
+			if !samePeer(*peer, *change.Proposer) {
+				err := status.Errorf(codes.PermissionDenied, "%s cannot leave on behalf of %s",
+					change.Proposer.Address, peer.Address)
+				span.RecordError(proposeCtx, err)
+				return nil, err
+			}
+		}
+	}
+
+	// joins are admitted by the coordinator too, such that proposals cannot bypass the join policy
+	if change.Type == pb.MembershipChange_JOIN {
+		for _, peer := range change.Peers {
+			err := lp.admit(proposeCtx, &pb.ConnectRequest{Peer: peer, NetworkID: lp.networkID(), Token: change.Token})
+			if err != nil {
+				span.RecordError(proposeCtx, err)
+				return nil, err
+			}
+		}
+	}
+
+	blockID, err := lp.applyMembershipChange(proposeCtx, change)
+	if err != nil {
+		span.RecordError(proposeCtx, err)
+		return nil, err
+	}
+	return &pb.MembershipChangeResponse{BlockID: blockID}, nil
+}
+
+// applyMembershipChange commits the NETWORK block for the change. Changes which do not modify the network
+// are ignored, such that repeated proposals for the same change produce a single block.
+func (lp *Lightpeer) applyMembershipChange(ctx context.Context, change *pb.MembershipChange) (string, error) {
+	lp.membershipLock.Lock()
+	defer lp.membershipLock.Unlock()
+
+	// a coordinator in the minority of a partition must not change the agreed membership
+	if err := lp.checkQuorum(); err != nil {
+		return "", err
+	}
+
+	oldNetwork := lp.GetNetwork()
+	newNetwork := []pb.PeerInfo{}
+	switch change.Type {
+	case pb.MembershipChange_JOIN:
+		newNetwork = append(newNetwork, oldNetwork...)
+		for _, peer := range change.Peers {
+			// a known peer joining with a new address keeps its place in the network
+			if i := indexOfPeer(newNetwork, *peer); i >= 0 {
+				newNetwork[i] = *peer
+			} else {
+				newNetwork = append(newNetwork, *peer)
+			}
+		}
+	case pb.MembershipChange_EVICT, pb.MembershipChange_LEAVE:
+		removed := []pb.PeerInfo{}
+		for _, peer := range change.Peers {
+			removed = append(removed, *peer)
+		}
+		for _, peer := range oldNetwork {
+			// the coordinator never evicts itself, but it can leave
+			isSelf := peer.Address == lp.Meta.Address && change.Type == pb.MembershipChange_EVICT
+			if isSelf || !containsPeer(removed, peer) {
+				newNetwork = append(newNetwork, peer)
+			}
+		}
+		// the removed peers are not notified about the new block
+		lp.setNetwork(newNetwork)
+	}
+
+	if sameNetwork(newNetwork, oldNetwork) {
+		return "", nil
+	}
+
+	blockID, err := lp.updateNetwork(ctx, newNetwork)
+	if err != nil {
+		lp.setNetwork(oldNetwork)
+		return "", err
+	}
+	return blockID, nil
+}
+
+// checkProposer refuses NETWORK blocks which were not recorded by a member of the network.
+func checkProposer(network []pb.PeerInfo, block pb.Lightblock) error {
+	if block.Type != pb.Lightblock_NETWORK {
+		return nil
+	}
+	if block.Proposer == nil || !containsPeer(network, *block.Proposer) {
+		return status.Errorf(codes.PermissionDenied, "network block %s was not proposed by a member", block.ID)
+	}
+	return nil
+}
+
+// EvictPeers removes the peers with the given addresses from the network, for example when the platform
+// running them reports that they are gone. Addresses which are not part of the network are ignored.
+func (lp *Lightpeer) EvictPeers(ctx context.Context, addresses []string) error {
+	evicted := []*pb.PeerInfo{}
+	for _, peer := range lp.GetNetwork() {
+		if peer.Address != lp.Meta.Address && contains(addresses, peer.Address) {
+			evictedPeer := peer
+			evicted = append(evicted, &evictedPeer)
+		}
+	}
+	if len(evicted) == 0 {
+		return nil
+	}
+
+	_, err := lp.changeMembership(ctx, &pb.MembershipChange{Type: pb.MembershipChange_EVICT, Peers: evicted})
+	if err != nil {
+		return fmt.Errorf("could not evict %d peers: %v", len(evicted), err)
+	}
+	for _, peer := range evicted {
+		lp.members().forget(peer.Address)
+	}
+	return nil
+}
+
+// waitForBlock waits until the block recorded by another peer was received.
+func (lp *Lightpeer) waitForBlock(ctx context.Context, blockID string) error {
+	waitCtx, cancel := context.WithTimeout(ctx, membershipBlockTimeout)
+	defer cancel()
+	for !lp.gossip().seen(blockID) {
+		select {
+		case <-waitCtx.Done():
+			return fmt.Errorf("block %s was not received: %v", blockID, waitCtx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return nil
+}
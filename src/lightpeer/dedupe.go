@@ -0,0 +1,89 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"sync"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+)
+
+// DefaultDedupeWindow is the number of request IDs remembered by a peer when
+// Lightpeer.DedupeWindow is not set.
+const DefaultDedupeWindow = 1024
+
+// The dedupe window remembers the receipts of the latest persisted requests, such that client retries
+// return the original receipt instead of creating a new block.
+// The request IDs are stored on the blocks themselves, so every peer builds the same window from the
+// blocks it receives, and a client can fail over to another peer while retrying.
+type dedupeWindow struct {
+	mu       sync.Mutex
+	receipts map[string]pb.PersistResponse
+	order    []string
+	next     int
+}
+
+func newDedupeWindow(size int) *dedupeWindow {
+	if size <= 0 {
+		size = DefaultDedupeWindow
+	}
+	return &dedupeWindow{
+		receipts: map[string]pb.PersistResponse{},
+		order:    make([]string, size),
+	}
+}
+
+// size returns the number of request IDs remembered by the window.
+func (dw *dedupeWindow) size() int {
+	return len(dw.order)
+}
+
+func (dw *dedupeWindow) lookup(requestID string) (pb.PersistResponse, bool) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	receipt, ok := dw.receipts[requestID]
+	return receipt, ok
+}
+
+// record adds the receipt to the window, evicting the oldest one if the window is full.
+func (dw *dedupeWindow) record(requestID string, receipt pb.PersistResponse) {
+	if requestID == "" {
+		return
+	}
+
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if _, ok := dw.receipts[requestID]; ok {
+		return
+	}
+
+	if oldest := dw.order[dw.next]; oldest != "" {
+		delete(dw.receipts, oldest)
+	}
+	dw.order[dw.next] = requestID
+	dw.next = (dw.next + 1) % len(dw.order)
+	dw.receipts[requestID] = receipt
+}
+
+// recordBlock adds the receipts of a client block to the window.
+func (dw *dedupeWindow) recordBlock(block pb.Lightblock) {
+	if block.Type != pb.Lightblock_CLIENT {
+		return
+	}
+	dw.record(block.RequestID, pb.PersistResponse{BlockID: block.ID})
+	for i, entry := range block.Entries {
+		dw.record(entry.RequestID, pb.PersistResponse{BlockID: block.ID, Index: int32(i)})
+	}
+}
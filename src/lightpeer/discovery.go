@@ -0,0 +1,177 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+)
+
+// DefaultDiscoveryInterval is the period at which AutoJoin looks for peers when no interval is given.
+const DefaultDiscoveryInterval = 10 * time.Second
+
+// Discoverer finds the addresses of peers which may be members of the network.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// StaticSeeds is a fixed list of peer addresses.
+type StaticSeeds []string
+
+func (seeds StaticSeeds) Discover(ctx context.Context) ([]string, error) {
+	return seeds, nil
+}
+
+// Resolver looks up DNS records. It is implemented by net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// DNSDiscoverer finds peers through the SRV records of a name, such as the records of a Kubernetes
+// headless service. Names without SRV records are resolved to addresses, which are combined with Port.
+type DNSDiscoverer struct {
+	Name string
+	Port int
+	// Resolver defaults to net.DefaultResolver.
+	Resolver Resolver
+}
+
+func (dd *DNSDiscoverer) Discover(ctx context.Context) ([]string, error) {
+	var resolver Resolver = net.DefaultResolver
+	if dd.Resolver != nil {
+		resolver = dd.Resolver
+	}
+
+	addresses := []string{}
+	_, records, err := resolver.LookupSRV(ctx, "", "", dd.Name)
+	if err == nil && len(records) > 0 {
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+		}
+		return addresses, nil
+	}
+
+	hosts, err := resolver.LookupHost(ctx, dd.Name)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve %s: %v", dd.Name, err)
+	}
+	for _, host := range hosts {
+		addresses = append(addresses, net.JoinHostPort(host, strconv.Itoa(dd.Port)))
+	}
+	return addresses, nil
+}
+
+// Discoverers combines the addresses found by several discoverers. It only fails if all of them fail.
+type Discoverers []Discoverer
+
+func (discoverers Discoverers) Discover(ctx context.Context) ([]string, error) {
+	addresses := []string{}
+	var err error
+	failed := 0
+	for _, discoverer := range discoverers {
+		found, discoverErr := discoverer.Discover(ctx)
+		if discoverErr != nil {
+			err = discoverErr
+			failed++
+			continue
+		}
+		addresses = append(addresses, found...)
+	}
+	if failed > 0 && failed == len(discoverers) {
+		return nil, err
+	}
+	return addresses, nil
+}
+
+// AutoJoin looks for peers every interval, and joins the network through the first one which answers
+// while this peer is alone. It returns when the context is done.
+func (lp *Lightpeer) AutoJoin(ctx context.Context, discoverer Discoverer, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultDiscoveryInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if len(lp.GetNetwork()) <= 1 {
+			lp.joinDiscoveredPeer(ctx, discoverer)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (lp *Lightpeer) joinDiscoveredPeer(ctx context.Context, discoverer Discoverer) {
+	discoveryCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - discovery", lp.Meta.Address))
+	defer span.End()
+
+	addresses, err := discoverer.Discover(discoveryCtx)
+	if err != nil {
+		span.RecordError(discoveryCtx, err)
+		return
+	}
+
+	for _, address := range addresses {
+		if address == lp.Meta.Address {
+			continue
+		}
+		if !lp.shouldJoin(discoveryCtx, address) {
+			continue
+		}
+
+		_, err := lp.JoinNetwork(discoveryCtx, &pb.JoinRequest{Address: address, Token: lp.JoinToken})
+		if err != nil {
+			span.AddEvent(discoveryCtx, fmt.Sprintf("could not join the network through %s: %v", address, err))
+			continue
+		}
+		span.AddEvent(discoveryCtx, fmt.Sprintf("joined the network through %s", address))
+		return
+	}
+}
+
+// shouldJoin tells whether to join the network of the peer at the address. When two lone peers discover
+// each other, only the one advertising the greater address joins, so they do not join each other at the
+// same time.
+func (lp *Lightpeer) shouldJoin(ctx context.Context, address string) bool {
+	conn, err := lp.dial(address)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	statusCtx, cancel := context.WithTimeout(ctx, lp.probeTimeout())
+	defer cancel()
+	peerStatus, err := pb.NewLightpeerClient(conn).GetStatus(statusCtx, &pb.StatusRequest{})
+	if err != nil {
+		return false
+	}
+	if peerStatus.Peer.GetAddress() == lp.Meta.Address {
+		// the name resolved to this peer
+		return false
+	}
+	return len(peerStatus.Members) > 1 || peerStatus.Peer.GetAddress() < lp.Meta.Address
+}
@@ -0,0 +1,247 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/metric"
+	"go.opentelemetry.io/otel/label"
+)
+
+const (
+	// ConsistencyService is the health service telling whether the chain of the peer diverged from the
+	// chain of another member.
+	ConsistencyService = "lightchain.consistency"
+	// DefaultConsistencyInterval is the period at which MonitorConsistency compares chains when no interval is given.
+	DefaultConsistencyInterval = 30 * time.Second
+)
+
+// Blocks are accepted whenever they link to the head of the chain, so two peers can extend the same head
+// with different blocks and fork the chain. Peers lagging behind are expected, so a member diverged only if
+// its chain is not a prefix of the chain of this peer, or the other way around. Chains are compared through
+// the heads and heights reported by GetStatus, and through the Merkle roots of the shorter chain. For the
+// same reason, the majority is made of the members whose chains agree up to the height of the shortest one,
+// rather than of the members holding the same head.
+
+// MonitorConsistency compares the chain with the chain of every member every interval, until the context
+// is done. If AutoResync is set, diverged peers replace their chain with the chain held by the majority.
+func (lp *Lightpeer) MonitorConsistency(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultConsistencyInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lp.checkConsistency(ctx)
+	}
+}
+
+// checkConsistency compares the chain with the chains of the members, returning the members whose chain diverged.
+func (lp *Lightpeer) checkConsistency(ctx context.Context) []string {
+	checkCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - consistency check", lp.Meta.Address))
+	defer span.End()
+
+	mt, err := lp.syncMerkleTree()
+	if err != nil {
+		span.RecordError(checkCtx, err)
+		return nil
+	}
+	height := mt.height()
+	head := mt.blockID(height)
+
+	network := lp.GetNetwork()
+	// this peer agrees with itself and with the members whose chain did not fork from it
+	agreeing := 1
+	divergedStatus := map[string]*pb.StatusResponse{}
+	diverged := []string{}
+	for _, peer := range network {
+		if peer.Address == lp.Meta.Address {
+			continue
+		}
+		peerStatus, forked, err := lp.compareWith(checkCtx, mt, peer.Address)
+		if err != nil {
+			span.AddEvent(checkCtx, fmt.Sprintf("could not compare the chain with %s: %v", peer.Address, err))
+			continue
+		}
+		if !forked {
+			agreeing++
+		} else {
+			span.AddEvent(checkCtx, fmt.Sprintf("chain diverged from %s, which has head %s at height %d, while this peer has head %s at height %d",
+				peer.Address, peerStatus.HeadID, peerStatus.Height, head, height))
+			lp.divergenceCounter().Add(checkCtx, 1, label.String("peer", lp.Meta.Address), label.String("member", peer.Address))
+			diverged = append(diverged, peer.Address)
+			divergedStatus[peer.Address] = peerStatus
+		}
+	}
+	lp.consistency().set(diverged)
+
+	if len(diverged) == 0 || !lp.AutoResync || 2*agreeing > len(network) {
+		return diverged
+	}
+	source, err := lp.majorityChain(checkCtx, divergedStatus, len(network))
+	if err != nil {
+		span.RecordError(checkCtx, err)
+		return diverged
+	}
+	if source == "" {
+		span.AddEvent(checkCtx, "no majority of the members agrees on a chain")
+		return diverged
+	}
+	if _, err := lp.syncChain(checkCtx, source, true); err != nil {
+		span.RecordError(checkCtx, fmt.Errorf("could not resync from %s: %v", source, err))
+		return diverged
+	}
+	span.AddEvent(checkCtx, fmt.Sprintf("resynced the chain from %s, holding the chain of the majority", source))
+	lp.resyncCounter().Add(checkCtx, 1, label.String("peer", lp.Meta.Address))
+	lp.consistency().set(nil)
+	return diverged
+}
+
+// majorityChain groups the diverged members by the Merkle root of their chain up to the height of the
+// shortest one, and returns the longest chain among the members of the group holding the majority of the
+// network, if any.
+func (lp *Lightpeer) majorityChain(ctx context.Context, statuses map[string]*pb.StatusResponse, networkSize int) (string, error) {
+	common := uint64(0)
+	for _, peerStatus := range statuses {
+		if common == 0 || peerStatus.Height < common {
+			common = peerStatus.Height
+		}
+	}
+
+	groups := map[string][]string{}
+	for address := range statuses {
+		conn, err := lp.dial(address)
+		if err != nil {
+			return "", err
+		}
+		prefix, err := pb.NewLightpeerClient(conn).GetMerkleRoot(ctx, &pb.MerkleRootRequest{From: 1, To: common})
+		conn.Close()
+		if err != nil {
+			return "", fmt.Errorf("could not get the Merkle root of %s: %v", address, err)
+		}
+		groups[string(prefix.Root)] = append(groups[string(prefix.Root)], address)
+	}
+
+	for _, addresses := range groups {
+		if 2*len(addresses) <= networkSize {
+			continue
+		}
+		source := addresses[0]
+		for _, address := range addresses[1:] {
+			if statuses[address].Height > statuses[source].Height {
+				source = address
+			}
+		}
+		return source, nil
+	}
+	return "", nil
+}
+
+// compareWith tells whether the chain of the peer at the address forked from the chain of this peer.
+func (lp *Lightpeer) compareWith(ctx context.Context, mt *merkleTree, address string) (*pb.StatusResponse, bool, error) {
+	conn, err := lp.dial(address)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	client := pb.NewLightpeerClient(conn)
+
+	peerStatus, err := client.GetStatus(ctx, &pb.StatusRequest{})
+	if err != nil {
+		return nil, false, err
+	}
+
+	height := mt.height()
+	if peerStatus.Height == 0 || height == 0 {
+		return peerStatus, false, nil
+	}
+	if peerStatus.Height <= height {
+		return peerStatus, mt.blockID(peerStatus.Height) != peerStatus.HeadID, nil
+	}
+
+	remote, err := client.GetMerkleRoot(ctx, &pb.MerkleRootRequest{From: 1, To: height})
+	if err != nil {
+		return nil, false, err
+	}
+	local, _ := mt.root(1, height)
+	return peerStatus, !bytes.Equal(local, remote.Root), nil
+}
+
+// consistencyState holds the members whose chain diverged at the last check.
+type consistencyState struct {
+	mu       sync.Mutex
+	diverged []string
+}
+
+func (lp *Lightpeer) consistency() *consistencyState {
+	lp.consistencyOnce.Do(func() {
+		lp.consistencyState = &consistencyState{}
+	})
+	return lp.consistencyState
+}
+
+func (cs *consistencyState) set(diverged []string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.diverged = diverged
+}
+
+func (cs *consistencyState) isDiverged() bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return len(cs.diverged) > 0
+}
+
+func (lp *Lightpeer) divergenceCounter() metric.Int64Counter {
+	lp.registerConsistencyMetrics()
+	return lp.divergences
+}
+
+func (lp *Lightpeer) resyncCounter() metric.Int64Counter {
+	lp.registerConsistencyMetrics()
+	return lp.resyncs
+}
+
+func (lp *Lightpeer) registerConsistencyMetrics() {
+	lp.consistencyMetricsOnce.Do(func() {
+		meter := global.Meter(ServiceName)
+		divergences, err := meter.NewInt64Counter("lightchain.chain.divergences",
+			metric.WithDescription("number of members found with a chain which diverged from the chain of the peer"))
+		if err != nil {
+			log.Printf("could not register the consistency metrics: %v", err)
+		}
+		resyncs, err := meter.NewInt64Counter("lightchain.chain.resyncs",
+			metric.WithDescription("number of times the peer replaced its chain with the chain of the majority"))
+		if err != nil {
+			log.Printf("could not register the consistency metrics: %v", err)
+		}
+		lp.divergences = divergences
+		lp.resyncs = resyncs
+	})
+}
@@ -0,0 +1,262 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+)
+
+// RepoReport describes the blocks stored in a StoragePath directory, as found by CheckRepo.
+type RepoReport struct {
+	// Blocks is the number of files holding a valid block.
+	Blocks int
+	// Head is the head of the chain, and Height the number of blocks on the chain.
+	Head   string
+	Height uint64
+	// SavedHead is the head saved by the peer, empty if it saved none.
+	SavedHead string
+	// Corrupt maps the files which do not hold a valid block to the reason.
+	Corrupt map[string]string
+	// Broken describes the linkage errors of the chain.
+	Broken []string
+	// Forks are the branches leaving the chain, and Orphans the blocks whose ancestors are missing.
+	Forks   []Fork
+	Orphans []string
+	// NetworkHistory decodes the NETWORK blocks of the chain, oldest first.
+	NetworkHistory []*pb.MembershipEvent
+}
+
+// Fork is a branch leaving the chain after the Parent block, holding Blocks from the oldest to its head.
+type Fork struct {
+	Parent string
+	Blocks []string
+}
+
+// Healthy tells whether every file holds a valid block, and the chain links down to its first block.
+func (r *RepoReport) Healthy() bool {
+	return len(r.Corrupt) == 0 && len(r.Broken) == 0
+}
+
+// Unreachable returns the blocks which are not on the chain, from forks and orphans.
+func (r *RepoReport) Unreachable() []string {
+	unreachable := map[string]bool{}
+	for _, fork := range r.Forks {
+		for _, blockID := range fork.Blocks {
+			unreachable[blockID] = true
+		}
+	}
+	for _, blockID := range r.Orphans {
+		unreachable[blockID] = true
+	}
+	return sortedKeys(unreachable)
+}
+
+// CheckRepo checks the blocks stored in the directory of a stopped peer. The chain is the chain ending at
+// the given head, or at the head saved by the peer if no head is given. Without a saved head, the chain is
+// the longest chain starting with a first block, the smaller head ID settling chains of the same height.
+func CheckRepo(storagePath, head string) (*RepoReport, error) {
+	files, err := ioutil.ReadDir(storagePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not list the blocks: %v", err)
+	}
+
+	report := &RepoReport{Corrupt: map[string]string{}}
+	lp := &Lightpeer{StoragePath: storagePath}
+	blocks := map[string]pb.Lightblock{}
+	children := map[string][]string{}
+	for _, f := range files {
+		// dot files hold the state of the peer rather than blocks
+		if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		block, err := lp.readBlock(f.Name())
+		if err != nil {
+			report.Corrupt[f.Name()] = err.Error()
+			continue
+		}
+		if block.ID != f.Name() {
+			report.Corrupt[f.Name()] = fmt.Sprintf("the file holds block %q", block.ID)
+			continue
+		}
+		blocks[block.ID] = block
+		children[block.PrevID] = append(children[block.PrevID], block.ID)
+	}
+	report.Blocks = len(blocks)
+	blockIDs := []string{}
+	for blockID := range blocks {
+		blockIDs = append(blockIDs, blockID)
+	}
+	sort.Strings(blockIDs)
+
+	rawHead, err := ioutil.ReadFile(path.Join(storagePath, headFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read the saved head: %v", err)
+	}
+	report.SavedHead = strings.TrimSpace(string(rawHead))
+	if head == "" {
+		head = report.SavedHead
+	}
+	if _, ok := blocks[head]; head != "" && !ok {
+		report.Broken = append(report.Broken, fmt.Sprintf("head %s is missing", head))
+		head = ""
+	}
+	if head == "" {
+		head = longestChain(blocks, blockIDs, children)
+	}
+	report.Head = head
+
+	// the chain, walking down from the head
+	onChain := map[string]bool{}
+	chain := []pb.Lightblock{}
+	for blockID := head; blockID != ""; {
+		block, ok := blocks[blockID]
+		if !ok {
+			report.Broken = append(report.Broken, fmt.Sprintf("block %s links to the missing block %s", chain[len(chain)-1].ID, blockID))
+			break
+		}
+		if onChain[blockID] {
+			report.Broken = append(report.Broken, fmt.Sprintf("block %s links to itself through its ancestors", blockID))
+			break
+		}
+		onChain[blockID] = true
+		chain = append(chain, block)
+		blockID = block.PrevID
+	}
+	report.Height = uint64(len(chain))
+
+	previous := []pb.PeerInfo{}
+	complete := len(report.Broken) == 0
+	for i := len(chain) - 1; i >= 0; i-- {
+		block := chain[i]
+		height := uint64(len(chain) - i)
+		if complete && block.Height > 0 && block.Height != height {
+			report.Broken = append(report.Broken, fmt.Sprintf("block %s records height %d but is at height %d", block.ID, block.Height, height))
+		}
+		if block.Type != pb.Lightblock_NETWORK {
+			continue
+		}
+		event, network, err := membershipEvent(block, previous)
+		if err != nil {
+			report.Broken = append(report.Broken, fmt.Sprintf("block %s: %v", block.ID, err))
+			continue
+		}
+		report.NetworkHistory = append(report.NetworkHistory, event)
+		previous = network
+	}
+
+	// branches are walked down from their heads, until they reach the chain or a missing block
+	orphans := map[string]bool{}
+	for _, blockID := range blockIDs {
+		if onChain[blockID] || len(children[blockID]) > 0 {
+			continue
+		}
+		branch := []string{}
+		seen := map[string]bool{}
+		parent := blockID
+		for parent != "" && !onChain[parent] {
+			block, ok := blocks[parent]
+			if !ok || seen[parent] {
+				break
+			}
+			branch = append(branch, parent)
+			seen[parent] = true
+			parent = block.PrevID
+		}
+		if onChain[parent] {
+			for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+				branch[i], branch[j] = branch[j], branch[i]
+			}
+			report.Forks = append(report.Forks, Fork{Parent: parent, Blocks: branch})
+			continue
+		}
+		for _, orphan := range branch {
+			orphans[orphan] = true
+		}
+	}
+	report.Orphans = sortedKeys(orphans)
+	return report, nil
+}
+
+// longestChain returns the head of the longest chain, preferring chains which start with a first block.
+func longestChain(blocks map[string]pb.Lightblock, blockIDs []string, children map[string][]string) string {
+	heights := map[string]uint64{}
+	complete := map[string]bool{}
+
+	head := ""
+	for _, tip := range blockIDs {
+		if len(children[tip]) > 0 {
+			continue
+		}
+		// walk down to a block of a chain measured before, the parent of a first block, or a missing block
+		branch := []string{}
+		seen := map[string]bool{}
+		blockID := tip
+		for {
+			if _, ok := heights[blockID]; ok {
+				break
+			}
+			block, ok := blocks[blockID]
+			if !ok || seen[blockID] {
+				break
+			}
+			branch = append(branch, blockID)
+			seen[blockID] = true
+			blockID = block.PrevID
+		}
+		height, whole := heights[blockID], blockID == "" || complete[blockID]
+		for i := len(branch) - 1; i >= 0; i-- {
+			height++
+			heights[branch[i]] = height
+			complete[branch[i]] = whole
+		}
+
+		if head == "" || (whole && !complete[head]) || (whole == complete[head] && height > heights[head]) {
+			head = tip
+		}
+	}
+	return head
+}
+
+// RemoveUnreachable deletes the blocks which are not on the chain of the report. Blocks are only removed
+// from healthy repos, since the blocks of a broken chain may be needed to repair it.
+func RemoveUnreachable(storagePath string, report *RepoReport) ([]string, error) {
+	if !report.Healthy() {
+		return nil, fmt.Errorf("the chain is broken, not removing blocks which may be needed to repair it")
+	}
+	removed := []string{}
+	for _, blockID := range report.Unreachable() {
+		if err := os.Remove(path.Join(storagePath, blockID)); err != nil {
+			return removed, fmt.Errorf("could not remove block %s: %v", blockID, err)
+		}
+		removed = append(removed, blockID)
+	}
+	return removed, nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
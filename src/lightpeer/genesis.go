@@ -0,0 +1,126 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/uuid"
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// A network starts with a GENESIS block, which gives the network its ID. Every block carries the ID of
+// its network, such that peers refuse blocks and peers from other networks instead of mixing histories.
+// Peers started without a network ID adopt the ID of the network they join.
+
+// CreateGenesis starts a new network by committing its genesis block, and returns the network ID.
+// The genesis uses the NetworkID of the peer, or a new one if it is not set.
+func (lp *Lightpeer) CreateGenesis(ctx context.Context, config map[string]string) (string, error) {
+	genesisCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - create genesis", lp.Meta.Address))
+	defer span.End()
+
+	if head := lp.GetState(); head.ID != "" {
+		err := fmt.Errorf("the chain already starts with block %s", head.ID)
+		span.RecordError(genesisCtx, err)
+		return "", err
+	}
+
+	networkID := lp.networkID()
+	if networkID == "" {
+		networkID = uuid.New().String()
+	}
+
+	genesis := &pb.Genesis{
+		NetworkID: networkID,
+		CreatedAt: ptypes.TimestampNow(),
+		Config:    config,
+	}
+	network := lp.GetNetwork()
+	for i := range network {
+		peer := network[i]
+		genesis.Network = append(genesis.Network, &peer)
+	}
+	rawGenesis, err := json.Marshal(genesis)
+	if err != nil {
+		err = fmt.Errorf("could not marshal the genesis: %v", err)
+		span.RecordError(genesisCtx, err)
+		return "", err
+	}
+
+	lp.setNetworkID(networkID)
+	err = lp.commitBlock(genesisCtx, &pb.Lightblock{
+		ID:      uuid.New().String(),
+		Payload: rawGenesis,
+		Type:    pb.Lightblock_GENESIS,
+	})
+	if err != nil {
+		span.RecordError(genesisCtx, err)
+		return "", err
+	}
+
+	span.AddEvent(genesisCtx, fmt.Sprintf("created network %s", networkID))
+	return networkID, nil
+}
+
+// DecodeGenesis returns the genesis described by a GENESIS block.
+func DecodeGenesis(block pb.Lightblock) (*pb.Genesis, error) {
+	if block.Type != pb.Lightblock_GENESIS {
+		return nil, fmt.Errorf("block %s is not a genesis block", block.ID)
+	}
+	genesis := &pb.Genesis{}
+	err := json.Unmarshal(block.Payload, genesis)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal genesis block: %v", err)
+	}
+	return genesis, nil
+}
+
+func (lp *Lightpeer) networkID() string {
+	lp.stateLock.Lock()
+	defer lp.stateLock.Unlock()
+	return lp.NetworkID
+}
+
+func (lp *Lightpeer) setNetworkID(networkID string) {
+	lp.stateLock.Lock()
+	defer lp.stateLock.Unlock()
+	lp.NetworkID = networkID
+}
+
+// checkNetwork refuses blocks from other networks. A peer without a network ID adopts the network of
+// the genesis block starting its chain. Must be called holding the state lock.
+func (lp *Lightpeer) checkNetwork(block pb.Lightblock) error {
+	if lp.NetworkID == "" && lp.state.ID == "" && block.Type == pb.Lightblock_GENESIS {
+		lp.NetworkID = block.NetworkID
+	}
+	if block.NetworkID != lp.NetworkID {
+		return fmt.Errorf("block %s belongs to network %q, not %q", block.ID, block.NetworkID, lp.NetworkID)
+	}
+	return nil
+}
+
+// checkGenesis refuses writes while the chain does not start with a genesis block, if the peer
+// requires one. Must be called holding the state lock.
+func (lp *Lightpeer) checkGenesis() error {
+	if lp.RequireGenesis && lp.genesisID == "" {
+		return status.Errorf(codes.FailedPrecondition, "the chain does not start with a genesis block")
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ replace (
 )
 
 require (
+	github.com/golang/protobuf v1.4.2
 	github.com/google/uuid v1.1.2
 	github.com/stefanprisca/lightchain v0.0.0-20200930090534-72e6139961be
 	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc v0.11.0
@@ -0,0 +1,254 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+)
+
+const (
+	// DefaultGossipInterval is the period of the head exchange when Lightpeer.GossipInterval is not set.
+	DefaultGossipInterval = time.Second
+
+	// gossipSeenWindow is the number of block IDs remembered for ignoring repeated gossip.
+	gossipSeenWindow = 4096
+	// gossipMaxOrphans is the number of out of order blocks held back while waiting for their parent.
+	gossipMaxOrphans = 1024
+	// gossipForwardTimeout bounds forwarding a block to one peer.
+	gossipForwardTimeout = 5 * time.Second
+)
+
+// In gossip mode, the writer sends a new block to Lightpeer.GossipFanout random peers instead of the
+// whole network. Every peer which applies the block forwards it to another random subset, such that
+// the block reaches the whole network in a logarithmic number of rounds.
+// Since blocks take different paths, they can arrive more than once or out of order. Repeated blocks
+// are recognised by their ID, and blocks arriving before their parent are held back until the parent
+// is applied. The receiver acknowledges a held back block with the ID of the missing parent, and the
+// sender follows up with the parents it has.
+// Forwarding is random, so a peer can miss a block altogether. To make the network converge, every
+// peer periodically sends its head to a random peer, which repairs any gap through the missing parents.
+type gossipState struct {
+	mu sync.Mutex
+
+	seenIDs  map[string]bool
+	seenRing []string
+	next     int
+
+	// orphans are keyed by the ID of the missing parent
+	orphans     map[string]pb.Lightblock
+	orphanOrder []string
+
+	// stop ends the periodic exchange of heads
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func (lp *Lightpeer) gossip() *gossipState {
+	lp.gossipOnce.Do(func() {
+		lp.gossipState = &gossipState{
+			seenIDs:  map[string]bool{},
+			seenRing: make([]string, gossipSeenWindow),
+			orphans:  map[string]pb.Lightblock{},
+			stop:     make(chan struct{}),
+		}
+		if lp.GossipFanout > 0 {
+			go lp.exchangeHeads(lp.gossipState.stop)
+		}
+	})
+	return lp.gossipState
+}
+
+func (gs *gossipState) seen(blockID string) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return gs.seenIDs[blockID]
+}
+
+// markSeen remembers the block, forgetting the oldest one if the window is full.
+func (gs *gossipState) markSeen(blockID string) {
+	if blockID == "" {
+		return
+	}
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.seenIDs[blockID] {
+		return
+	}
+
+	if oldest := gs.seenRing[gs.next]; oldest != "" {
+		delete(gs.seenIDs, oldest)
+	}
+	gs.seenRing[gs.next] = blockID
+	gs.next = (gs.next + 1) % len(gs.seenRing)
+	gs.seenIDs[blockID] = true
+}
+
+// holdBack keeps the block until its parent is applied, dropping the oldest orphan if the buffer is full.
+func (gs *gossipState) holdBack(block pb.Lightblock) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if _, ok := gs.orphans[block.PrevID]; ok {
+		return
+	}
+
+	if len(gs.orphanOrder) >= gossipMaxOrphans {
+		delete(gs.orphans, gs.orphanOrder[0])
+		gs.orphanOrder = gs.orphanOrder[1:]
+	}
+	gs.orphans[block.PrevID] = block
+	gs.orphanOrder = append(gs.orphanOrder, block.PrevID)
+}
+
+// release returns the block waiting for the given parent, if any.
+func (gs *gossipState) release(parentID string) (pb.Lightblock, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	block, ok := gs.orphans[parentID]
+	if !ok {
+		return block, false
+	}
+
+	delete(gs.orphans, parentID)
+	for i, id := range gs.orphanOrder {
+		if id == parentID {
+			gs.orphanOrder = append(gs.orphanOrder[:i], gs.orphanOrder[i+1:]...)
+			break
+		}
+	}
+	return block, true
+}
+
+// gossipTargets picks up to GossipFanout random peers from the network, other than this one.
+func (lp *Lightpeer) gossipTargets() []pb.PeerInfo {
+	peers := []pb.PeerInfo{}
+	for _, peer := range lp.GetNetwork() {
+		if peer.Address != lp.Meta.Address {
+			peers = append(peers, peer)
+		}
+	}
+
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > lp.GossipFanout {
+		peers = peers[:lp.GossipFanout]
+	}
+	return peers
+}
+
+// gossipBlock sends a new block to a random subset of the network. It fails if any of the peers rejects
+// the block, while unreachable peers are skipped, since the block reaches them through the other peers.
+func (lp *Lightpeer) gossipBlock(ctx context.Context, block pb.Lightblock) error {
+	targets := lp.gossipTargets()
+	errs := make(chan error, len(targets))
+	for _, peer := range targets {
+		go func(address string) {
+			err := lp.gossipTo(ctx, address, block)
+			if err != nil && !isRejection(err) {
+				err = nil
+			}
+			errs <- err
+		}(peer.Address)
+	}
+
+	for range targets {
+		if err := <-errs; err != nil {
+			return fmt.Errorf("could not gossip block %s: %v", block.ID, err)
+		}
+	}
+	return nil
+}
+
+// forwardBlock passes a block received through gossip on to a random subset of the network.
+func (lp *Lightpeer) forwardBlock(block pb.Lightblock) {
+	forwardCtx, span := lp.Tracer.Start(context.Background(), fmt.Sprintf("@%s - forward block %s", lp.Meta.Address, block.ID))
+	defer span.End()
+
+	var wg sync.WaitGroup
+	for _, peer := range lp.gossipTargets() {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(forwardCtx, gossipForwardTimeout)
+			defer cancel()
+			if err := lp.gossipTo(ctx, address, block); err != nil {
+				span.AddEvent(forwardCtx, fmt.Sprintf("could not forward block to %s: %v", address, err))
+			}
+		}(peer.Address)
+	}
+	wg.Wait()
+}
+
+// gossipTo sends the block to the peer, followed by the parents the peer is missing.
+func (lp *Lightpeer) gossipTo(ctx context.Context, address string, block pb.Lightblock) error {
+	err := lp.replicate(ctx, address, block)
+	for repaired := 0; ; repaired++ {
+		missing, ok := err.(*missingParentError)
+		if !ok {
+			return err
+		}
+		if repaired >= gossipMaxOrphans {
+			return fmt.Errorf("%s is missing more than %d blocks", address, gossipMaxOrphans)
+		}
+
+		parent, readErr := lp.readBlock(missing.blockID)
+		if readErr != nil {
+			return fmt.Errorf("could not read block %s missing on %s: %v", missing.blockID, address, readErr)
+		}
+		err = lp.replicate(ctx, address, parent)
+	}
+}
+
+// StopGossip stops the periodic exchange of heads, which runs from the first gossiped block on.
+func (lp *Lightpeer) StopGossip() {
+	gs := lp.gossip()
+	gs.stopOnce.Do(func() { close(gs.stop) })
+}
+
+// exchangeHeads periodically sends the head of the chain to a random peer, until stop is closed.
+func (lp *Lightpeer) exchangeHeads(stop <-chan struct{}) {
+	interval := lp.GossipInterval
+	if interval <= 0 {
+		interval = DefaultGossipInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		head := lp.GetState()
+		if head.ID == "" {
+			continue
+		}
+
+		peers := lp.gossipTargets()
+		if len(peers) == 0 {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), gossipForwardTimeout)
+		lp.gossipTo(ctx, peers[rand.Intn(len(peers))].Address, head)
+		cancel()
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const headFile = ".head"
+
+// Blocks are stored in one file each, and every read walking the chain goes through all the files below the
+// head, so a single file deleted or truncated on disk would break the chain for good. Walks repair such
+// blocks by fetching them by ID from the members of the network, which hold the same chain. A fetched block
+// is only trusted if it has the requested ID, belongs to the network and sits at the height expected from
+// its child; its own parent is checked the same way once the walk reaches it. The ID of the head is saved
+// next to the blocks, such that RestoreChain can check the whole chain when the peer starts.
+
+// loadBlock reads the block, fetching it from the members of the network if its file is missing or corrupt.
+// The height is the height expected for the block, or zero if it is not known.
+func (lp *Lightpeer) loadBlock(blockID string, height uint64) (pb.Lightblock, error) {
+	block, err := lp.readBlock(blockID)
+	if err == nil {
+		return block, nil
+	}
+	return lp.healBlock(blockID, height, err)
+}
+
+// parentHeight returns the height expected for the parent of the block, or zero if it is not known.
+func parentHeight(block pb.Lightblock) uint64 {
+	if block.Height > 1 {
+		return block.Height - 1
+	}
+	return 0
+}
+
+// healBlock fetches the block from the first member which holds a valid copy, and rewrites its file.
+func (lp *Lightpeer) healBlock(blockID string, height uint64, readErr error) (pb.Lightblock, error) {
+	healCtx, span := lp.Tracer.Start(context.Background(), fmt.Sprintf("@%s - heal block %s", lp.Meta.Address, blockID))
+	defer span.End()
+
+	log.Printf("warning: block %s is damaged, fetching it from the network: %v", blockID, readErr)
+	span.AddEvent(healCtx, fmt.Sprintf("could not read block %s: %v", blockID, readErr))
+
+	networkID := lp.networkID()
+	for _, peer := range lp.GetNetwork() {
+		if peer.Address == lp.Meta.Address {
+			continue
+		}
+		block, err := lp.fetchBlock(healCtx, peer.Address, blockID)
+		if err == nil {
+			err = verifyBlock(block, blockID, networkID, height)
+		}
+		if err != nil {
+			span.AddEvent(healCtx, fmt.Sprintf("could not fetch block %s from %s: %v", blockID, peer.Address, err))
+			continue
+		}
+
+		if err := lp.writeBlock(block); err != nil {
+			// the copy is still valid for this read, and the next read tries again
+			span.RecordError(healCtx, fmt.Errorf("could not rewrite block %s: %v", blockID, err))
+			return block, nil
+		}
+		log.Printf("repaired block %s with the copy of %s", blockID, peer.Address)
+		span.AddEvent(healCtx, fmt.Sprintf("repaired block %s with the copy of %s", blockID, peer.Address))
+		return block, nil
+	}
+
+	err := fmt.Errorf("block %s is damaged and no member could provide it: %v", blockID, readErr)
+	span.RecordError(healCtx, err)
+	return pb.Lightblock{}, err
+}
+
+func (lp *Lightpeer) fetchBlock(ctx context.Context, address, blockID string) (pb.Lightblock, error) {
+	conn, err := lp.dial(address)
+	if err != nil {
+		return pb.Lightblock{}, err
+	}
+	defer conn.Close()
+
+	block, err := pb.NewLightpeerClient(conn).GetBlock(ctx, &pb.BlockRequest{ID: blockID})
+	if err != nil {
+		return pb.Lightblock{}, err
+	}
+	return *block, nil
+}
+
+// verifyBlock checks that a block fetched from another peer is the requested one.
+func verifyBlock(block pb.Lightblock, blockID, networkID string, height uint64) error {
+	if block.ID != blockID {
+		return fmt.Errorf("got block %s instead", block.ID)
+	}
+	if networkID != "" && block.NetworkID != networkID {
+		return fmt.Errorf("block belongs to network %s", block.NetworkID)
+	}
+	if height > 0 && block.Height > 0 && block.Height != height {
+		return fmt.Errorf("block has height %d instead of %d", block.Height, height)
+	}
+	return nil
+}
+
+// GetBlock returns the block with the given ID. Damaged blocks are not repaired here, such that two peers
+// missing the same block do not keep asking each other for it.
+func (lp *Lightpeer) GetBlock(ctx context.Context, req *pb.BlockRequest) (*pb.Lightblock, error) {
+	if req.ID == "" || strings.ContainsAny(req.ID, `/\`) || strings.HasPrefix(req.ID, ".") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid block ID %q", req.ID)
+	}
+	block, err := lp.readBlock(req.ID)
+	if os.IsNotExist(err) {
+		return nil, status.Errorf(codes.NotFound, "block %s not found", req.ID)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.DataLoss, "could not read block %s: %v", req.ID, err)
+	}
+	return &block, nil
+}
+
+// setState moves the head of the chain to the block. Must be called holding the state lock.
+func (lp *Lightpeer) setState(block pb.Lightblock) {
+	lp.state = block
+	lp.index().extend(block)
+}
+
+// saveHead saves the ID of the head for RestoreChain, once the blocks of a commit, of a notification or of
+// a replaced fork are applied. A head which could not be saved only makes RestoreChain start from an older
+// block, so the failure is not returned. Must be called without holding the state lock.
+func (lp *Lightpeer) saveHead() {
+	lp.headLock.Lock()
+	defer lp.headLock.Unlock()
+	head := lp.GetState()
+	if head.ID == "" {
+		return
+	}
+	if err := SaveHead(lp.StoragePath, head.ID); err != nil {
+		log.Printf("warning: %v", err)
+	}
+}
+
+// SaveHead saves the head of the chain stored in the directory, from which RestoreChain loads the chain.
+func SaveHead(storagePath, headID string) error {
+	headPath := path.Join(storagePath, headFile)
+	err := ioutil.WriteFile(headPath+".tmp", []byte(headID+"\n"), 0666)
+	if err == nil {
+		err = os.Rename(headPath+".tmp", headPath)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save the head of the chain: %v", err)
+	}
+	return nil
+}
+
+// RestoreChain loads the chain stored by a previous run of the peer, starting from the saved head. Every
+// block of the chain is read, such that damaged blocks are repaired from the members of the stored network
+// when the peer starts rather than on the first read. The dedupe window is rebuilt from the latest client
+// blocks, such that requests retried across the restart are not committed again. Peers which never stored a
+// chain are left empty.
+func (lp *Lightpeer) RestoreChain(ctx context.Context) error {
+	restoreCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - restore chain", lp.Meta.Address))
+	defer span.End()
+
+	rawHead, err := ioutil.ReadFile(path.Join(lp.StoragePath, headFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		err = fmt.Errorf("could not read the head of the chain: %v", err)
+		span.RecordError(restoreCtx, err)
+		return err
+	}
+	headID := strings.TrimSpace(string(rawHead))
+	if headID == "" {
+		return nil
+	}
+
+	head, err := lp.loadBlock(headID, 0)
+	if err != nil {
+		err = fmt.Errorf("could not read the head of the chain %s: %v", headID, err)
+		span.RecordError(restoreCtx, err)
+		return err
+	}
+
+	// older blocks are repaired from the latest members, and the peer stays in its configured network if
+	// the chain cannot be restored
+	configured := lp.GetNetwork()
+	networkFound := false
+	networkHeight := uint64(0)
+	genesisID := ""
+	// every client block holds at least one request, so the window is filled by as many blocks
+	recentBlocks := []pb.Lightblock{}
+	for block := head; ; {
+		if block.Type == pb.Lightblock_CLIENT && len(recentBlocks) < lp.requests().size() {
+			recentBlocks = append(recentBlocks, block)
+		}
+		if block.Type == pb.Lightblock_NETWORK && !networkFound {
+			network, err := decodeNetwork(block)
+			if err != nil {
+				lp.setNetwork(configured)
+				span.RecordError(restoreCtx, err)
+				return err
+			}
+			lp.setNetwork(network)
+			networkFound = true
+			networkHeight = block.Height
+		}
+		if block.Type == pb.Lightblock_GENESIS {
+			genesisID = block.ID
+		}
+		if block.PrevID == "" {
+			break
+		}
+		parent, err := lp.loadBlock(block.PrevID, parentHeight(block))
+		if err != nil && !networkFound {
+			// the latest NETWORK block is below the damaged block, so the members are taken from the
+			// latest NETWORK block found among the stored files
+			if network, ok := lp.storedNetwork(); ok {
+				lp.setNetwork(network)
+				parent, err = lp.loadBlock(block.PrevID, parentHeight(block))
+			}
+		}
+		if err != nil {
+			lp.setNetwork(configured)
+			err = fmt.Errorf("could not restore the chain: %v", err)
+			span.RecordError(restoreCtx, err)
+			return err
+		}
+		block = parent
+	}
+
+	lp.stateLock.Lock()
+	lp.state = head
+	if lp.NetworkID == "" {
+		lp.NetworkID = head.NetworkID
+	}
+	lp.genesisID = genesisID
+	lp.networkHeight = networkHeight
+	lp.stateLock.Unlock()
+
+	// the blocks were read from the head, so they are recorded in reverse to keep the latest requests
+	for i := len(recentBlocks) - 1; i >= 0; i-- {
+		lp.requests().recordBlock(recentBlocks[i])
+	}
+
+	// the blocks queued for unreachable members before the restart are delivered again
+	lp.resumeOutboxes()
+
+	span.AddEvent(restoreCtx, fmt.Sprintf("restored the chain with head %s", head.ID))
+	return nil
+}
+
+// storedNetwork decodes the membership of the highest NETWORK block among the stored files, whether or not
+// it is still on the chain.
+func (lp *Lightpeer) storedNetwork() ([]pb.PeerInfo, bool) {
+	files, err := ioutil.ReadDir(lp.StoragePath)
+	if err != nil {
+		return nil, false
+	}
+
+	var latest *pb.Lightblock
+	for _, f := range files {
+		if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		block, err := lp.readBlock(f.Name())
+		if err != nil || block.Type != pb.Lightblock_NETWORK {
+			continue
+		}
+		if latest == nil || block.Height > latest.Height {
+			latest = &block
+		}
+	}
+	if latest == nil {
+		return nil, false
+	}
+	network, err := decodeNetwork(*latest)
+	if err != nil {
+		return nil, false
+	}
+	return network, true
+}
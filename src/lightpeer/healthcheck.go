@@ -17,79 +17,465 @@ package lightpeer
 import (
 	"context"
 	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 
 	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
-	grpctrace "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc"
-	"go.opentelemetry.io/otel/api/global"
-	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"go.opentelemetry.io/otel/api/trace"
 )
 
 const (
-	nhcRunning = 1
-	nhcStopped = 2
+	DefaultProbeInterval    = 500 * time.Millisecond
+	DefaultProbeTimeout     = 250 * time.Millisecond
+	DefaultIndirectProbes   = 3
+	DefaultSuspicionTimeout = 2 * time.Second
 )
 
+// The NetworkHealthChecker is a SWIM-like failure detector. Every probe interval, it probes the next peer
+// from the network, in a random round robin order. When the peer does not answer, a few other peers are
+// asked to probe it indirectly, such that a slow link between two peers does not fail the probe.
+// Peers failing both probes are only suspected, and are removed from the network once the suspicion
+// times out. Suspicions are shared with the other peers on every probe, so the suspected peer learns
+// about it and refutes it by increasing its incarnation number.
 type NetworkHealthChecker struct {
-	Lp     *Lightpeer
-	status int
+	Lp *Lightpeer
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	order  []string
 }
 
-func (nhc *NetworkHealthChecker) StartPeerHealthCheck() {
-	nhc.status = nhcRunning
+// StartPeerHealthCheck runs the failure detector until the context is done or the checker is stopped.
+func (nhc *NetworkHealthChecker) StartPeerHealthCheck(ctx context.Context) {
+	nhcCtx, cancel := context.WithCancel(ctx)
+	nhc.mu.Lock()
+	nhc.cancel = cancel
+	nhc.mu.Unlock()
+
 	lp := nhc.Lp
+	lp.healthCheckLock.Lock()
+	lp.healthChecker = nhc
+	lp.healthCheckLock.Unlock()
+	interval := lp.ProbeInterval
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+
 	go func() {
-		for nhc.status == nhcRunning {
-			ctx := context.Background()
-			nhcCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - Network healthcheck", lp.Meta.Address))
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-nhcCtx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			roundCtx, span := lp.Tracer.Start(nhcCtx, fmt.Sprintf("@%s - Network healthcheck", lp.Meta.Address))
+			if lp.members().excluded() {
+				// the view of the network is stale, so it is neither probed nor changed
+				lp.rejoin(roundCtx)
+			} else {
+				nhc.probeNext(roundCtx)
+				lp.updateWriteMode(roundCtx)
+				nhc.evictFailedPeers(roundCtx)
+			}
+			span.End()
+		}
+	}()
+}
 
-			oldNetwork := lp.Network
-			newNetwork := []pb.PeerInfo{}
+func (nhc *NetworkHealthChecker) StopPeerHealthCheck() {
+	nhc.mu.Lock()
+	defer nhc.mu.Unlock()
+	if nhc.cancel != nil {
+		nhc.cancel()
+	}
+}
 
-			for _, peer := range oldNetwork {
+// stopHealthCheck stops the failure detector running for the peer, if any.
+func (lp *Lightpeer) stopHealthCheck() {
+	lp.healthCheckLock.Lock()
+	defer lp.healthCheckLock.Unlock()
+	if lp.healthChecker != nil {
+		lp.healthChecker.StopPeerHealthCheck()
+		lp.healthChecker = nil
+	}
+}
 
-				if peer.Address == lp.Meta.Address {
-					newNetwork = append(newNetwork, peer)
-				} else if isAlive(nhcCtx, peer) {
-					newNetwork = append(newNetwork, peer)
+// nextTarget returns the next peer to probe. Every peer is probed once per round, in a new random order.
+func (nhc *NetworkHealthChecker) nextTarget() (string, bool) {
+	lp := nhc.Lp
+	for {
+		if len(nhc.order) == 0 {
+			for _, peer := range lp.GetNetwork() {
+				if peer.Address != lp.Meta.Address {
+					nhc.order = append(nhc.order, peer.Address)
 				}
 			}
+			if len(nhc.order) == 0 {
+				return "", false
+			}
+			rand.Shuffle(len(nhc.order), func(i, j int) { nhc.order[i], nhc.order[j] = nhc.order[j], nhc.order[i] })
+		}
 
-			if len(newNetwork) != len(oldNetwork) {
-				lp.Network = newNetwork
-				err := lp.updateNetwork(nhcCtx, newNetwork)
-				if err != nil {
-					lp.Network = oldNetwork
-				}
+		target := nhc.order[0]
+		nhc.order = nhc.order[1:]
+		if containsAddress(lp.GetNetwork(), target) {
+			return target, true
+		}
+	}
+}
+
+func (nhc *NetworkHealthChecker) probeNext(ctx context.Context) {
+	lp := nhc.Lp
+	target, ok := nhc.nextTarget()
+	if !ok {
+		return
+	}
+
+	incarnation, err := lp.probe(ctx, target)
+	if err == nil {
+		lp.members().ack(target, incarnation)
+		return
+	}
+
+	incarnation, err = nhc.probeIndirectly(ctx, target)
+	if err == nil {
+		lp.members().ack(target, incarnation)
+		return
+	}
+
+	if lp.members().suspect(target) {
+		trace.SpanFromContext(ctx).AddEvent(ctx, fmt.Sprintf("suspecting %s: %v", target, err))
+	}
+}
+
+// probeIndirectly asks random peers to probe the target, succeeding if any of them reaches it.
+func (nhc *NetworkHealthChecker) probeIndirectly(ctx context.Context, target string) (uint64, error) {
+	lp := nhc.Lp
+	count := lp.IndirectProbes
+	if count <= 0 {
+		count = DefaultIndirectProbes
+	}
+
+	helpers := []string{}
+	for _, peer := range lp.GetNetwork() {
+		if peer.Address != lp.Meta.Address && peer.Address != target {
+			helpers = append(helpers, peer.Address)
+		}
+	}
+	rand.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	if len(helpers) > count {
+		helpers = helpers[:count]
+	}
+	if len(helpers) == 0 {
+		return 0, fmt.Errorf("no peers for probing %s indirectly", target)
+	}
+
+	type probeResult struct {
+		incarnation uint64
+		err         error
+	}
+	results := make(chan probeResult, len(helpers))
+	for _, helper := range helpers {
+		go func(helper string) {
+			resp, err := lp.sendProbe(ctx, helper, target, 2*lp.probeTimeout())
+			if err == nil && !resp.Ack {
+				err = fmt.Errorf("%s could not reach %s", helper, target)
 			}
-			span.End()
+			if err != nil {
+				results <- probeResult{0, err}
+				return
+			}
+			results <- probeResult{resp.Incarnation, nil}
+		}(helper)
+	}
+
+	var err error
+	for range helpers {
+		res := <-results
+		if res.err == nil {
+			return res.incarnation, nil
+		}
+		err = res.err
+	}
+	return 0, err
+}
+
+// evictFailedPeers asks the coordinator to remove the peers whose suspicion timed out from the network.
+func (nhc *NetworkHealthChecker) evictFailedPeers(ctx context.Context) {
+	lp := nhc.Lp
+	timeout := lp.SuspicionTimeout
+	if timeout <= 0 {
+		timeout = DefaultSuspicionTimeout
+	}
 
-			time.Sleep(500 * time.Millisecond)
+	network := lp.GetNetwork()
+	lp.members().retain(network)
+	expired := lp.members().expired(timeout)
+	failed := []*pb.PeerInfo{}
+	for _, peer := range network {
+		if contains(expired, peer.Address) {
+			failedPeer := peer
+			failed = append(failed, &failedPeer)
 		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	if err := lp.checkEvictionQuorum(expired); err != nil {
+		trace.SpanFromContext(ctx).AddEvent(ctx, fmt.Sprintf("not evicting failed peers: %v", err))
+		return
+	}
 
-	}()
+	_, err := lp.changeMembership(ctx, &pb.MembershipChange{Type: pb.MembershipChange_EVICT, Peers: failed})
+	if err != nil {
+		trace.SpanFromContext(ctx).AddEvent(ctx, fmt.Sprintf("could not evict failed peers: %v", err))
+		return
+	}
+	for _, peer := range failed {
+		lp.members().forget(peer.Address)
+	}
+}
+
+// Probe answers a probe from the failure detector of another peer.
+func (lp *Lightpeer) Probe(ctx context.Context, req *pb.ProbeRequest) (*pb.ProbeResponse, error) {
+	lp.members().merge(req.Members, lp.Meta.Address)
+
+	resp := &pb.ProbeResponse{
+		Ack:           true,
+		Incarnation:   lp.members().incarnation(),
+		NetworkHeight: lp.getNetworkHeight(),
+	}
+	if req.Target != "" {
+		incarnation, err := lp.probe(ctx, req.Target)
+		resp.Ack = err == nil
+		resp.Incarnation = incarnation
+	}
+	resp.Members = lp.members().snapshot(lp.GetNetwork(), lp.Meta.Address)
+	return resp, nil
+}
+
+func (lp *Lightpeer) probeTimeout() time.Duration {
+	if lp.ProbeTimeout <= 0 {
+		return DefaultProbeTimeout
+	}
+	return lp.ProbeTimeout
+}
+
+// probe checks that the peer is alive, returning its incarnation.
+func (lp *Lightpeer) probe(ctx context.Context, address string) (uint64, error) {
+	resp, err := lp.sendProbe(ctx, address, "", lp.probeTimeout())
+	if err != nil {
+		return 0, err
+	}
+	return resp.Incarnation, nil
 }
 
-func isAlive(nhcCtx context.Context, peer pb.PeerInfo) bool {
-	conn, err := grpc.Dial(peer.Address, grpc.WithInsecure(),
-		grpc.WithUnaryInterceptor(grpctrace.UnaryClientInterceptor(
-			global.Tracer(fmt.Sprintf("client@%s", peer.Address)))),
-		grpc.WithStreamInterceptor(grpctrace.StreamClientInterceptor(
-			global.Tracer(fmt.Sprintf("stream-client@%s", peer.Address)))))
+func (lp *Lightpeer) sendProbe(ctx context.Context, address, target string, timeout time.Duration) (*pb.ProbeResponse, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := lp.dial(address)
+	if err != nil {
+		return nil, err
+	}
 	defer conn.Close()
+
+	resp, err := pb.NewLightpeerClient(conn).Probe(probeCtx, &pb.ProbeRequest{
+		Target:  target,
+		Members: lp.members().snapshot(lp.GetNetwork(), lp.Meta.Address),
+	})
+	if status.Code(err) == codes.Unimplemented && target == "" {
+		// the peer does not support probes, fall back to the health service.
+		if !isAlive(probeCtx, healthpb.NewHealthClient(conn)) {
+			return nil, fmt.Errorf("%s is not serving", address)
+		}
+		return &pb.ProbeResponse{Ack: true}, nil
+	}
 	if err != nil {
-		return false
+		return nil, err
+	}
+
+	lp.members().merge(resp.Members, lp.Meta.Address)
+	lp.detectExclusion(ctx, address, resp)
+	return resp, nil
+}
+
+func isAlive(ctx context.Context, client healthpb.HealthClient) bool {
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	return err == nil && resp.Status == healthpb.HealthCheckResponse_SERVING
+}
+
+func contains(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
+
+// membership holds the failure detector state of the other peers, and the incarnation of this peer.
+// Peers removed from the network record the members which excluded them, for rejoining through them,
+// and peers in the minority of a partition are read-only until they reach the majority again.
+type membership struct {
+	mu         sync.Mutex
+	self       uint64
+	members    map[string]*memberState
+	excludedBy []string
+	isReadOnly bool
+}
+
+type memberState struct {
+	status      pb.MemberState_MemberStatus
+	incarnation uint64
+	suspectedAt time.Time
+	lastSeen    time.Time
+}
+
+func (lp *Lightpeer) members() *membership {
+	lp.membersOnce.Do(func() {
+		lp.membership = &membership{members: map[string]*memberState{}}
+	})
+	return lp.membership
+}
+
+func (m *membership) incarnation() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.self
+}
+
+func (m *membership) suspected(address string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms, ok := m.members[address]
+	return ok && ms.status == pb.MemberState_SUSPECT
+}
+
+// lastSeen returns the last time the peer answered a probe.
+func (m *membership) lastSeen(address string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ms, ok := m.members[address]; ok {
+		return ms.lastSeen
+	}
+	return time.Time{}
+}
+
+func (m *membership) member(address string) *memberState {
+	ms, ok := m.members[address]
+	if !ok {
+		ms = &memberState{}
+		m.members[address] = ms
+	}
+	return ms
+}
+
+// ack records an answer of the peer, which clears any suspicion of the same or an older incarnation.
+func (m *membership) ack(address string, incarnation uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms := m.member(address)
+	ms.lastSeen = time.Now()
+	if incarnation >= ms.incarnation {
+		ms.incarnation = incarnation
+		ms.status = pb.MemberState_ALIVE
 	}
+}
 
-	client := healthpb.NewHealthClient(conn)
-	resp, err := client.Check(nhcCtx, &healthpb.HealthCheckRequest{})
-	if err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+// suspect marks the peer as suspected, returning whether it was alive before.
+func (m *membership) suspect(address string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms := m.member(address)
+	if ms.status == pb.MemberState_SUSPECT {
 		return false
 	}
+	ms.status = pb.MemberState_SUSPECT
+	ms.suspectedAt = time.Now()
 	return true
 }
 
-func (nhc *NetworkHealthChecker) StopPeerHealthCheck() {
-	nhc.status = nhcStopped
+// expired returns the peers which were suspected for longer than the timeout.
+func (m *membership) expired(timeout time.Duration) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failed := []string{}
+	for address, ms := range m.members {
+		if ms.status == pb.MemberState_SUSPECT && time.Since(ms.suspectedAt) > timeout {
+			failed = append(failed, address)
+		}
+	}
+	return failed
+}
+
+// retain forgets the peers which are no longer part of the network.
+func (m *membership) retain(network []pb.PeerInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for address := range m.members {
+		if !containsAddress(network, address) {
+			delete(m.members, address)
+		}
+	}
+}
+
+func (m *membership) forget(address string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.members, address)
+}
+
+// merge applies the states received from another peer. Newer incarnations override older ones, and
+// a suspicion overrides an alive state of the same incarnation. Suspicions about this peer are refuted.
+func (m *membership) merge(states []*pb.MemberState, selfAddress string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, state := range states {
+		if state.Address == selfAddress {
+			if state.Status == pb.MemberState_SUSPECT && state.Incarnation >= m.self {
+				m.self = state.Incarnation + 1
+			}
+			continue
+		}
+
+		ms := m.member(state.Address)
+		switch {
+		case state.Incarnation > ms.incarnation:
+			if state.Status == pb.MemberState_SUSPECT && ms.status != pb.MemberState_SUSPECT {
+				ms.suspectedAt = time.Now()
+			}
+			ms.incarnation = state.Incarnation
+			ms.status = state.Status
+		case state.Incarnation == ms.incarnation && state.Status == pb.MemberState_SUSPECT && ms.status == pb.MemberState_ALIVE:
+			ms.status = pb.MemberState_SUSPECT
+			ms.suspectedAt = time.Now()
+		}
+	}
+}
+
+// snapshot returns the states of the peers in the network, to be shared with other peers.
+func (m *membership) snapshot(network []pb.PeerInfo, selfAddress string) []*pb.MemberState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := []*pb.MemberState{}
+	for _, peer := range network {
+		state := &pb.MemberState{Address: peer.Address}
+		if peer.Address == selfAddress {
+			state.Incarnation = m.self
+		} else if ms, ok := m.members[peer.Address]; ok {
+			state.Status = ms.status
+			state.Incarnation = ms.incarnation
+		}
+		states = append(states, state)
+	}
+	return states
 }
@@ -0,0 +1,219 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// membershipWatcherBuffer is the number of membership events buffered for a slow watcher.
+const membershipWatcherBuffer = 16
+
+// GetMembershipHistory decodes the NETWORK blocks of the chain into membership changes, oldest first.
+func (lp *Lightpeer) GetMembershipHistory(ctx context.Context, req *pb.MembershipHistoryRequest) (*pb.MembershipHistoryResponse, error) {
+
+	historyCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - membership history", lp.Meta.Address))
+	defer span.End()
+
+	events, err := lp.membershipHistory()
+	if err != nil {
+		span.RecordError(historyCtx, err)
+		return nil, err
+	}
+	return &pb.MembershipHistoryResponse{Events: events}, nil
+}
+
+// WatchMembership streams the membership changes recorded by the peer, until the client goes away.
+func (lp *Lightpeer) WatchMembership(req *pb.WatchMembershipRequest, stream pb.Lightpeer_WatchMembershipServer) error {
+
+	watchCtx, span := lp.Tracer.Start(stream.Context(), fmt.Sprintf("@%s - watch membership", lp.Meta.Address))
+	defer span.End()
+
+	events, unsubscribe := lp.watchers().subscribe(lp.GetNetwork())
+	defer unsubscribe()
+
+	// the subscription is made before reading the history, so changes recorded meanwhile may be received twice
+	sent := map[string]bool{}
+	if req.IncludeHistory {
+		history, err := lp.membershipHistory()
+		if err != nil {
+			span.RecordError(watchCtx, err)
+			return err
+		}
+		for _, event := range history {
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+			sent[event.BlockID] = true
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				err := status.Errorf(codes.ResourceExhausted, "membership watcher fell behind")
+				span.RecordError(watchCtx, err)
+				return err
+			}
+			if sent[event.BlockID] {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (lp *Lightpeer) membershipHistory() ([]*pb.MembershipEvent, error) {
+	networkBlocks := []pb.Lightblock{}
+	var err error
+	for blockResp := range lp.readBlocks() {
+		if blockResp.err != nil {
+			err = fmt.Errorf("failed to read block: %v", blockResp.err)
+			continue
+		}
+		if blockResp.block.ID != "" && blockResp.block.Type == pb.Lightblock_NETWORK {
+			networkBlocks = append(networkBlocks, blockResp.block)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*pb.MembershipEvent{}
+	previous := []pb.PeerInfo{}
+	for i := len(networkBlocks) - 1; i >= 0; i-- {
+		event, network, err := membershipEvent(networkBlocks[i], previous)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+		previous = network
+	}
+	return events, nil
+}
+
+// membershipEvent describes the NETWORK block as a change from the previous network.
+func membershipEvent(block pb.Lightblock, previous []pb.PeerInfo) (*pb.MembershipEvent, []pb.PeerInfo, error) {
+	network, err := decodeNetwork(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	event := &pb.MembershipEvent{
+		BlockID:   block.ID,
+		Height:    block.Height,
+		Timestamp: block.LastUpdated,
+	}
+	for i := range network {
+		peer := network[i]
+		event.Peers = append(event.Peers, &peer)
+		if j := indexOfPeer(previous, peer); j < 0 {
+			event.Joined = append(event.Joined, &peer)
+		} else if previous[j].Address != peer.Address {
+			event.Updated = append(event.Updated, &peer)
+		}
+	}
+	for i := range previous {
+		peer := previous[i]
+		if !containsPeer(network, peer) {
+			event.Left = append(event.Left, &peer)
+		}
+	}
+	return event, network, nil
+}
+
+func decodeNetwork(block pb.Lightblock) ([]pb.PeerInfo, error) {
+	network := []pb.PeerInfo{}
+	err := json.Unmarshal(block.Payload, &network)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal network block: %v", err)
+	}
+	return network, nil
+}
+
+// membershipWatchers fans out the membership changes to the WatchMembership streams.
+type membershipWatchers struct {
+	mu          sync.Mutex
+	lastNetwork []pb.PeerInfo
+	subscribers map[chan *pb.MembershipEvent]bool
+}
+
+func (lp *Lightpeer) watchers() *membershipWatchers {
+	lp.watchersOnce.Do(func() {
+		lp.membershipWatchers = &membershipWatchers{
+			subscribers: map[chan *pb.MembershipEvent]bool{},
+		}
+	})
+	return lp.membershipWatchers
+}
+
+// subscribe returns the channel receiving the next membership changes. Watchers which do not keep up
+// have their channel closed.
+func (mw *membershipWatchers) subscribe(network []pb.PeerInfo) (<-chan *pb.MembershipEvent, func()) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if mw.lastNetwork == nil {
+		mw.lastNetwork = network
+	}
+
+	events := make(chan *pb.MembershipEvent, membershipWatcherBuffer)
+	mw.subscribers[events] = true
+	return events, func() {
+		mw.mu.Lock()
+		defer mw.mu.Unlock()
+		if mw.subscribers[events] {
+			delete(mw.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// publishMembership notifies the watchers about a NETWORK block recorded by the peer.
+func (lp *Lightpeer) publishMembership(block pb.Lightblock) {
+	mw := lp.watchers()
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	if len(mw.subscribers) == 0 {
+		// the previous network is taken from the peer when the next watcher subscribes
+		mw.lastNetwork = nil
+		return
+	}
+
+	event, network, err := membershipEvent(block, mw.lastNetwork)
+	if err != nil {
+		return
+	}
+	mw.lastNetwork = network
+	for events := range mw.subscribers {
+		select {
+		case events <- event:
+		default:
+			delete(mw.subscribers, events)
+			close(events)
+		}
+	}
+}
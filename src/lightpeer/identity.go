@@ -0,0 +1,84 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+)
+
+const nodeIDFile = ".nodeid"
+
+// NodeID returns the stable identity of the peer storing its blocks in storagePath. The ID is generated
+// on the first start and kept next to the blocks, such that a peer restarted with a new address is
+// recognised as the same member.
+func NodeID(storagePath string) (string, error) {
+	idPath := path.Join(storagePath, nodeIDFile)
+	rawID, err := ioutil.ReadFile(idPath)
+	if err == nil {
+		if id := strings.TrimSpace(string(rawID)); id != "" {
+			return id, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("could not read the node ID: %v", err)
+	}
+
+	id := uuid.New().String()
+	err = ioutil.WriteFile(idPath, []byte(id+"\n"), 0666)
+	if err != nil {
+		return "", fmt.Errorf("could not save the node ID: %v", err)
+	}
+	return id, nil
+}
+
+// samePeer tells whether the two entries are the same member. Peers are identified by their ID, while
+// peers without one fall back to their address and name.
+func samePeer(a, b pb.PeerInfo) bool {
+	if a.ID != "" || b.ID != "" {
+		return a.ID == b.ID
+	}
+	return a.Address == b.Address && a.Name == b.Name
+}
+
+func containsPeer(network []pb.PeerInfo, peer pb.PeerInfo) bool {
+	return indexOfPeer(network, peer) >= 0
+}
+
+func indexOfPeer(network []pb.PeerInfo, peer pb.PeerInfo) int {
+	for i, p := range network {
+		if samePeer(p, peer) {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameNetwork(a, b []pb.PeerInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || a[i].Address != b[i].Address || a[i].Name != b[i].Name {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,267 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sync"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const indexFile = ".index"
+
+// The index maps the heights of the chain to block IDs and back, such that single blocks and ranges of
+// blocks are found without walking the chain. It is kept in a file next to the blocks, with one "height ID"
+// line per entry. Blocks appended to the chain are appended to the file, while the file is rewritten when the
+// chain is replaced, such that it does not grow with the entries of replaced forks. An entry at a given height
+// replaces the entries above it, so older files holding such entries are compacted when loaded. Blocks
+// appended on top of the indexed head are indexed right away, while any other change of the head, such as
+// joining a network or adopting a fork, is indexed on the next lookup by walking back from the head to the
+// first indexed block.
+
+type blockIndex struct {
+	mu   sync.Mutex
+	path string
+	// ids holds the block IDs by height, the block at height 1 first
+	ids     []string
+	heights map[string]uint64
+}
+
+func (lp *Lightpeer) index() *blockIndex {
+	lp.indexOnce.Do(func() {
+		lp.blockIndex = loadIndex(path.Join(lp.StoragePath, indexFile))
+	})
+	return lp.blockIndex
+}
+
+// loadIndex replays the entries of the index file. A damaged entry ends the replay, and the entries lost
+// with it are indexed again by the next lookup.
+func loadIndex(indexPath string) *blockIndex {
+	idx := &blockIndex{path: indexPath, heights: map[string]uint64{}}
+	indexFile, err := os.Open(indexPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("warning: could not read the block index, rebuilding it: %v", err)
+		}
+		return idx
+	}
+	defer indexFile.Close()
+
+	entries := 0
+	scanner := bufio.NewScanner(indexFile)
+	for scanner.Scan() {
+		var height uint64
+		var blockID string
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %s", &height, &blockID); err != nil || height == 0 || height > uint64(len(idx.ids))+1 {
+			break
+		}
+		idx.truncate(height - 1)
+		idx.ids = append(idx.ids, blockID)
+		idx.heights[blockID] = height
+		entries++
+	}
+	if entries > len(idx.ids) {
+		if err := idx.rewrite(); err != nil {
+			log.Printf("warning: %v", err)
+		}
+	}
+	return idx
+}
+
+// syncIndex indexes the blocks between the head of the chain and the highest indexed block below it.
+func (lp *Lightpeer) syncIndex() (*blockIndex, error) {
+	head := lp.GetState()
+	idx := lp.index()
+
+	// blocks are read without holding the lock, since repairing them takes the state lock
+	missing := []pb.Lightblock{}
+	block := head
+	height, ok := idx.heightOf(block.ID)
+	for !ok && block.ID != "" {
+		missing = append(missing, block)
+		if block.PrevID == "" {
+			break
+		}
+		parent, err := lp.loadBlock(block.PrevID, parentHeight(block))
+		if err != nil {
+			return nil, fmt.Errorf("could not read block %s: %v", block.PrevID, err)
+		}
+		block = parent
+		height, ok = idx.heightOf(block.ID)
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if head.ID == "" {
+		// the head is empty until the chain is restored or received, while the index still describes the
+		// stored chain, so it is kept until a chain replaces it
+		return idx, nil
+	}
+	if headHeight, ok := idx.heights[head.ID]; ok && headHeight == uint64(len(idx.ids)) {
+		return idx, nil
+	}
+	if height > 0 && (height > uint64(len(idx.ids)) || idx.ids[height-1] != block.ID) {
+		return nil, fmt.Errorf("the block index changed while indexing the chain")
+	}
+
+	replaced := height < uint64(len(idx.ids))
+	idx.truncate(height)
+	for i := len(missing) - 1; i >= 0; i-- {
+		idx.ids = append(idx.ids, missing[i].ID)
+		idx.heights[missing[i].ID] = uint64(len(idx.ids))
+	}
+	if replaced {
+		return idx, idx.rewrite()
+	}
+	return idx, idx.save(height + 1)
+}
+
+// extend indexes the block if it links to the indexed head.
+func (idx *blockIndex) extend(block pb.Lightblock) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	height := uint64(len(idx.ids))
+	if height > 0 && idx.ids[height-1] == block.ID {
+		return
+	}
+	if (height == 0 && block.PrevID != "") || (height > 0 && idx.ids[height-1] != block.PrevID) {
+		return
+	}
+	idx.ids = append(idx.ids, block.ID)
+	idx.heights[block.ID] = height + 1
+	if err := idx.save(height + 1); err != nil {
+		log.Printf("warning: %v", err)
+	}
+}
+
+// save appends the entries from the height on to the index file. Must be called holding the lock.
+func (idx *blockIndex) save(from uint64) error {
+	indexFile, err := os.OpenFile(idx.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("could not open the block index: %v", err)
+	}
+	writer := bufio.NewWriter(indexFile)
+	for height := from; height <= uint64(len(idx.ids)); height++ {
+		fmt.Fprintf(writer, "%d %s\n", height, idx.ids[height-1])
+	}
+	if err := writer.Flush(); err != nil {
+		indexFile.Close()
+		return fmt.Errorf("could not write the block index: %v", err)
+	}
+	return indexFile.Close()
+}
+
+// rewrite replaces the index file with the entries of the index. The entries are written to a temporary
+// file first, such that the index file is never left half written. Must be called holding the lock.
+func (idx *blockIndex) rewrite() error {
+	tmpPath := idx.path + ".tmp"
+	os.Remove(tmpPath)
+	tmpIdx := &blockIndex{path: tmpPath, ids: idx.ids}
+	if err := tmpIdx.save(1); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, idx.path); err != nil {
+		return fmt.Errorf("could not replace the block index: %v", err)
+	}
+	return nil
+}
+
+// truncate drops the entries above the height. Must be called holding the lock.
+func (idx *blockIndex) truncate(height uint64) {
+	if height >= uint64(len(idx.ids)) {
+		return
+	}
+	for _, blockID := range idx.ids[height:] {
+		delete(idx.heights, blockID)
+	}
+	idx.ids = idx.ids[:height]
+}
+
+func (idx *blockIndex) height() uint64 {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return uint64(len(idx.ids))
+}
+
+// blockID returns the ID of the block at the height, or an empty ID for heights outside of the chain.
+func (idx *blockIndex) blockID(height uint64) string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if height == 0 || height > uint64(len(idx.ids)) {
+		return ""
+	}
+	return idx.ids[height-1]
+}
+
+// blockIDs returns the IDs of the blocks between the heights, both included. A zero to selects up to the head.
+func (idx *blockIndex) blockIDs(from, to uint64) []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if from == 0 {
+		from = 1
+	}
+	if to == 0 || to > uint64(len(idx.ids)) {
+		to = uint64(len(idx.ids))
+	}
+	if from > to {
+		return []string{}
+	}
+	return append([]string{}, idx.ids[from-1:to]...)
+}
+
+// heightOf returns the height of the block, and whether the block is on the chain.
+func (idx *blockIndex) heightOf(blockID string) (uint64, bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	height, ok := idx.heights[blockID]
+	return height, ok
+}
+
+// since returns the height up to which the IDs are the IDs of the index, and the IDs of the index above it.
+func (idx *blockIndex) since(ids []string) (uint64, []string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	height := uint64(len(ids))
+	if height > uint64(len(idx.ids)) {
+		height = uint64(len(idx.ids))
+	}
+	// a block ID at a height determines the blocks below it
+	for height > 0 && ids[height-1] != idx.ids[height-1] {
+		height--
+	}
+	return height, append([]string{}, idx.ids[height:]...)
+}
+
+// GetBlockByHeight returns the block at the given height of the chain.
+func (lp *Lightpeer) GetBlockByHeight(ctx context.Context, req *pb.BlockByHeightRequest) (*pb.Lightblock, error) {
+	idx, err := lp.syncIndex()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "could not index the chain: %v", err)
+	}
+	blockID := idx.blockID(req.Height)
+	if blockID == "" {
+		return nil, status.Errorf(codes.NotFound, "no block at height %d, the chain has height %d", req.Height, idx.height())
+	}
+	return lp.GetBlock(ctx, &pb.BlockRequest{ID: blockID})
+}
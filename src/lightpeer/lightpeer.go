@@ -21,7 +21,10 @@ import (
 	"io"
 	"io/ioutil"
 	"path"
+	"sync"
+	"time"
 
+	"github.com/golang/protobuf/ptypes"
 	"github.com/google/uuid"
 	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
 	"google.golang.org/grpc"
@@ -30,8 +33,7 @@ import (
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 
-	grpctrace "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc"
-	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/metric"
 	"go.opentelemetry.io/otel/api/trace"
 )
 
@@ -42,7 +44,96 @@ type Lightpeer struct {
 	StoragePath string
 	Network     []pb.PeerInfo
 	Meta        pb.PeerInfo
-	state       pb.Lightblock
+	// DedupeWindow is the number of persist request IDs remembered for idempotent retries.
+	// Defaults to DefaultDedupeWindow.
+	DedupeWindow int
+	// BatchWindow enables group commits: persist requests arriving within the window are
+	// packed into a single block. Zero disables batching.
+	BatchWindow time.Duration
+	// MaxBatchSize caps the number of requests in a group commit. Defaults to DefaultMaxBatchSize.
+	MaxBatchSize int
+	// ReplicationWindow caps the number of unacknowledged blocks on a replication stream.
+	// Defaults to DefaultReplicationWindow.
+	ReplicationWindow int
+	// RetryBackoff is the initial delay between retries of undelivered blocks, doubled after every
+	// failed attempt up to MaxRetryBackoff. Defaults to DefaultRetryBackoff and DefaultMaxRetryBackoff.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// GossipFanout enables gossip dissemination: new blocks are sent to GossipFanout random peers,
+	// which forward them further. Zero broadcasts new blocks to the whole network.
+	GossipFanout int
+	// GossipInterval is the period at which gossiping peers send their head to a random peer, such
+	// that peers which missed blocks catch up. Defaults to DefaultGossipInterval.
+	GossipInterval time.Duration
+	// ProbeInterval, ProbeTimeout, IndirectProbes and SuspicionTimeout configure the failure detector,
+	// defaulting to DefaultProbeInterval, DefaultProbeTimeout, DefaultIndirectProbes and DefaultSuspicionTimeout.
+	ProbeInterval    time.Duration
+	ProbeTimeout     time.Duration
+	IndirectProbes   int
+	SuspicionTimeout time.Duration
+	// DialOptions are added to the options used for connecting to other peers.
+	DialOptions []grpc.DialOption
+	// NetworkID is the network the peer belongs to. Blocks and peers from other networks are rejected.
+	// Peers without a network ID adopt the network of the chain they join.
+	NetworkID string
+	// RequireGenesis refuses client writes until the chain starts with a genesis block.
+	RequireGenesis bool
+	// JoinPolicy decides which peers may join the network. All peers are admitted if it is not set.
+	JoinPolicy JoinPolicy
+	// JoinToken is presented to the network when the peer rejoins after being removed from it.
+	JoinToken string
+	// AutoResync replaces the chain of the peer with the chain held by the majority of the network, when
+	// MonitorConsistency finds that the chain diverged.
+	AutoResync    bool
+	state         pb.Lightblock
+	genesisID     string
+	networkHeight uint64
+
+	commitLock     sync.Mutex
+	stateLock      sync.Mutex
+	membershipLock sync.Mutex
+	networkLock    sync.Mutex
+	headLock       sync.Mutex
+	dedupeOnce     sync.Once
+	dedupe         *dedupeWindow
+	batcherOnce    sync.Once
+	committer      *groupCommitter
+
+	replicasLock sync.Mutex
+	replicas     map[string]*replicationStream
+
+	outboxesLock sync.Mutex
+	outboxes     map[string]*outbox
+
+	gossipOnce  sync.Once
+	gossipState *gossipState
+
+	membersOnce sync.Once
+	membership  *membership
+
+	healthCheckLock sync.Mutex
+	healthChecker   *NetworkHealthChecker
+
+	watchersOnce       sync.Once
+	membershipWatchers *membershipWatchers
+
+	admissionOnce        sync.Once
+	rejectedJoinsCounter metric.Int64Counter
+
+	merkleOnce sync.Once
+	merkleTree *merkleTree
+
+	antiEntropyOnce        sync.Once
+	discardedBlocksCounter metric.Int64Counter
+
+	indexOnce  sync.Once
+	blockIndex *blockIndex
+
+	consistencyOnce        sync.Once
+	consistencyState       *consistencyState
+	consistencyMetricsOnce sync.Once
+	divergences            metric.Int64Counter
+	resyncs                metric.Int64Counter
 }
 
 // Persist creates a new state on the chain, and notifies the network about the new state
@@ -53,30 +144,83 @@ func (lp *Lightpeer) Persist(ctx context.Context, tReq *pb.PersistRequest) (*pb.
 	//log.Printf("got new persist request %v \n", *tReq)
 	span.AddEvent(persistCtx, fmt.Sprintf("got new persist request %v ", *tReq))
 
-	lightBlock := pb.Lightblock{
-		ID:      uuid.New().String(),
-		Payload: tReq.Payload,
-		Type:    pb.Lightblock_CLIENT,
+	if err := lp.checkWrites(); err != nil {
+		span.RecordError(persistCtx, err)
+		return nil, err
+	}
+
+	if tReq.RequestID != "" {
+		if receipt, ok := lp.requests().lookup(tReq.RequestID); ok {
+			span.AddEvent(persistCtx, fmt.Sprintf("request %s already persisted in block %s", tReq.RequestID, receipt.BlockID))
+			return &receipt, nil
+		}
 	}
 
-	if lp.state.ID != "" {
-		lightBlock.PrevID = lp.state.ID
+	if lp.BatchWindow > 0 {
+		receipt, err := lp.batcher().persist(persistCtx, tReq)
+		if err != nil {
+			span.RecordError(persistCtx, err)
+		}
+		return receipt, err
 	}
 
-	err := lp.sendNewBlockNotifications(persistCtx, lightBlock)
+	lightBlock := pb.Lightblock{
+		ID:        uuid.New().String(),
+		Payload:   tReq.Payload,
+		Type:      pb.Lightblock_CLIENT,
+		RequestID: tReq.RequestID,
+	}
+
+	receipt, err := lp.commitRequest(persistCtx, &lightBlock)
 	if err != nil {
-		err = fmt.Errorf("could not send new block notifications: %v", err)
 		span.RecordError(persistCtx, err)
 		return nil, err
 	}
 
-	err = lp.writeBlock(lightBlock)
+	return receipt, nil
+}
+
+// PersistBatch creates a single block holding all the payloads from the request, such that readers
+// never observe only a part of them.
+func (lp *Lightpeer) PersistBatch(ctx context.Context, bReq *pb.PersistBatchRequest) (*pb.PersistResponse, error) {
+	persistCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - persist batch", lp.Meta.Address))
+	defer span.End()
+
+	if len(bReq.Payloads) == 0 {
+		err := status.Errorf(codes.InvalidArgument, "persist batch request has no payloads")
+		span.RecordError(persistCtx, err)
+		return nil, err
+	}
+
+	if err := lp.checkWrites(); err != nil {
+		span.RecordError(persistCtx, err)
+		return nil, err
+	}
+
+	if bReq.RequestID != "" {
+		if receipt, ok := lp.requests().lookup(bReq.RequestID); ok {
+			span.AddEvent(persistCtx, fmt.Sprintf("request %s already persisted in block %s", bReq.RequestID, receipt.BlockID))
+			return &receipt, nil
+		}
+	}
+
+	lightBlock := pb.Lightblock{
+		ID:        uuid.New().String(),
+		Type:      pb.Lightblock_CLIENT,
+		RequestID: bReq.RequestID,
+	}
+	for _, payload := range bReq.Payloads {
+		lightBlock.Entries = append(lightBlock.Entries, &pb.BlockEntry{Payload: payload})
+	}
+
+	receipt, err := lp.commitRequest(persistCtx, &lightBlock)
 	if err != nil {
-		return &pb.PersistResponse{}, err
+		span.RecordError(persistCtx, err)
+		return nil, err
 	}
 
-	lp.state = lightBlock
-	return &pb.PersistResponse{}, nil
+	span.AddEvent(persistCtx, fmt.Sprintf("persisted %d payloads in block %s", len(bReq.Payloads), receipt.BlockID))
+	return receipt, nil
 }
 
 func (lp *Lightpeer) Query(qReq *pb.EmptyQueryRequest, stream pb.Lightpeer_QueryServer) error {
@@ -89,10 +233,24 @@ func (lp *Lightpeer) Query(qReq *pb.EmptyQueryRequest, stream pb.Lightpeer_Query
 			span.RecordError(queryCtx, fmt.Errorf("failed to read block %v", blockResp.err))
 			return blockResp.err
 		}
-		if blockResp.block.Type != pb.Lightblock_CLIENT {
+		block := blockResp.block
+		if block.Type != pb.Lightblock_CLIENT {
+			continue
+		}
+		if len(block.Entries) == 0 {
+			stream.Send(&pb.QueryResponse{Payload: block.Payload, BlockID: block.ID, BlockSize: 1})
 			continue
 		}
-		stream.Send(&pb.QueryResponse{Payload: blockResp.block.Payload})
+		// blocks are read from the newest one, so send the newest entries first as well
+		blockSize := int32(len(block.Entries))
+		for i := blockSize - 1; i >= 0; i-- {
+			stream.Send(&pb.QueryResponse{
+				Payload:   block.Entries[i].Payload,
+				BlockID:   block.ID,
+				Index:     i,
+				BlockSize: blockSize,
+			})
+		}
 	}
 
 	span.AddEvent(queryCtx, fmt.Sprintf("finished sending blocks \n"))
@@ -106,11 +264,7 @@ func (lp *Lightpeer) JoinNetwork(ctx context.Context, joinReq *pb.JoinRequest) (
 	joinCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - join %s", lp.Meta.Address, joinReq.Address))
 	defer span.End()
 
-	conn, err := grpc.Dial(joinReq.Address, grpc.WithInsecure(),
-		grpc.WithUnaryInterceptor(grpctrace.UnaryClientInterceptor(
-			global.Tracer(fmt.Sprintf("client@%s", joinReq.Address)))),
-		grpc.WithStreamInterceptor(grpctrace.StreamClientInterceptor(
-			global.Tracer(fmt.Sprintf("stream-client@%s", joinReq.Address)))))
+	conn, err := lp.dial(joinReq.Address)
 
 	if err != nil {
 		err = fmt.Errorf("failed to connect to grpc server: %v", err)
@@ -122,7 +276,11 @@ func (lp *Lightpeer) JoinNetwork(ctx context.Context, joinReq *pb.JoinRequest) (
 	client := pb.NewLightpeerClient(conn)
 	pi := &pb.PeerInfo{}
 	*pi = lp.Meta
-	blockStream, err := client.ConnectNewPeer(joinCtx, &pb.ConnectRequest{Peer: pi})
+	blockStream, err := client.ConnectNewPeer(joinCtx, &pb.ConnectRequest{
+		Peer:      pi,
+		NetworkID: lp.networkID(),
+		Token:     joinReq.Token,
+	})
 	if err != nil {
 		err := fmt.Errorf("connect new peer request failed: %v", err)
 		span.RecordError(joinCtx, err)
@@ -140,10 +298,48 @@ func (lp *Lightpeer) JoinNetwork(ctx context.Context, joinReq *pb.JoinRequest) (
 	return &pb.JoinResponse{}, nil
 }
 
+// LeaveNetwork removes the peer from the network, such that the other peers do not have to evict it.
+func (lp *Lightpeer) LeaveNetwork(ctx context.Context, leaveReq *pb.LeaveRequest) (*pb.LeaveResponse, error) {
+
+	leaveCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - leave", lp.Meta.Address))
+	defer span.End()
+
+	lp.stopHealthCheck()
+
+	err := lp.drainOutboxes(leaveCtx)
+	if err != nil {
+		// the blocks are lost for the unreachable peers, which are likely to be evicted anyway
+		span.RecordError(leaveCtx, err)
+	}
+
+	if len(lp.GetNetwork()) > 1 {
+		_, err = lp.changeMembership(leaveCtx, &pb.MembershipChange{
+			Type:  pb.MembershipChange_LEAVE,
+			Peers: []*pb.PeerInfo{&lp.Meta},
+		})
+		if err != nil {
+			err = fmt.Errorf("could not leave the network: %v", err)
+			span.RecordError(leaveCtx, err)
+			return &pb.LeaveResponse{}, err
+		}
+	}
+
+	alone := []pb.PeerInfo{lp.Meta}
+	lp.setNetwork(alone)
+	lp.closeReplicationStreams(alone)
+
+	span.AddEvent(leaveCtx, "successfully left the network")
+	return &pb.LeaveResponse{}, nil
+}
+
 func (lp *Lightpeer) updateFromBlockStream(blockStream pb.Lightpeer_ConnectNewPeerClient) error {
 
 	networkUpdated := false
+	networkID := lp.networkID()
+	genesisID := ""
+	networkHeight := uint64(0)
 	var state *pb.Lightblock = nil
+	clientBlocks := []pb.Lightblock{}
 	for {
 		block, err := blockStream.Recv()
 		if err == io.EOF {
@@ -154,6 +350,12 @@ func (lp *Lightpeer) updateFromBlockStream(blockStream pb.Lightpeer_ConnectNewPe
 			err = fmt.Errorf("error while receiving messages: %v", err)
 			return err
 		}
+		if state == nil && networkID == "" {
+			networkID = block.NetworkID
+		}
+		if block.NetworkID != networkID {
+			return fmt.Errorf("block %s belongs to network %q, not %q", block.ID, block.NetworkID, networkID)
+		}
 		err = lp.writeBlock(*block)
 		if err != nil {
 			err = fmt.Errorf("error while writing new block: %v", err)
@@ -164,18 +366,39 @@ func (lp *Lightpeer) updateFromBlockStream(blockStream pb.Lightpeer_ConnectNewPe
 			state = block
 		}
 
+		if block.Type == pb.Lightblock_CLIENT {
+			clientBlocks = append(clientBlocks, *block)
+		}
+		if block.Type == pb.Lightblock_GENESIS {
+			genesisID = block.ID
+		}
+		lp.gossip().markSeen(block.ID)
+
 		if block.Type == pb.Lightblock_NETWORK && !networkUpdated {
-			network := []pb.PeerInfo{}
-			err := json.Unmarshal(block.Payload, &network)
+			network, err := decodeNetwork(*block)
 			if err != nil {
-				return fmt.Errorf("could not unmarshal network block: %v", err)
+				return err
 			}
 
-			lp.Network = network
+			lp.setNetwork(network)
 			networkUpdated = true
+			networkHeight = block.Height
+			lp.publishMembership(*block)
 		}
 	}
-	lp.state = *state
+	lp.stateLock.Lock()
+	lp.setState(*state)
+	lp.NetworkID = networkID
+	lp.genesisID = genesisID
+	lp.networkHeight = networkHeight
+	lp.stateLock.Unlock()
+	lp.saveHead()
+
+	// blocks are streamed from the newest one, so record them in reverse to keep the latest requests in the window
+	for i := len(clientBlocks) - 1; i >= 0; i-- {
+		lp.requests().recordBlock(clientBlocks[i])
+	}
+
 	if !networkUpdated {
 		return fmt.Errorf("no network update blocks found, network state might be invalid")
 	}
@@ -188,9 +411,28 @@ func (lp *Lightpeer) ConnectNewPeer(cReq *pb.ConnectRequest, stream pb.Lightpeer
 	connectCtx, span := lp.Tracer.Start(stream.Context(), fmt.Sprintf("@%s - connect %s", lp.Meta.Address, cReq.Peer.Address))
 	defer span.End()
 
-	newNetwork := append(lp.Network, *cReq.Peer)
+	if networkID := lp.networkID(); cReq.NetworkID != "" && cReq.NetworkID != networkID {
+		err := status.Errorf(codes.FailedPrecondition, "%s belongs to network %q, not %q",
+			cReq.Peer.Address, cReq.NetworkID, networkID)
+		span.RecordError(connectCtx, err)
+		return err
+	}
+
+	if err := lp.admit(connectCtx, cReq); err != nil {
+		span.AddEvent(connectCtx, fmt.Sprintf("refused %s", cReq.Peer.Address))
+		span.RecordError(connectCtx, err)
+		return err
+	}
 
-	err := lp.updateNetwork(connectCtx, newNetwork)
+	blockID, err := lp.changeMembership(connectCtx, &pb.MembershipChange{
+		Type:  pb.MembershipChange_JOIN,
+		Peers: []*pb.PeerInfo{cReq.Peer},
+		Token: cReq.Token,
+	})
+	if err == nil && blockID != "" {
+		// the new peer must receive the block adding it to the network
+		err = lp.waitForBlock(connectCtx, blockID)
+	}
 	if err != nil {
 		span.RecordError(connectCtx, err)
 		return err
@@ -214,33 +456,92 @@ func (lp *Lightpeer) ConnectNewPeer(cReq *pb.ConnectRequest, stream pb.Lightpeer
 	return nil
 }
 
-func (lp *Lightpeer) updateNetwork(ctx context.Context, newNetwork []pb.PeerInfo) error {
+func (lp *Lightpeer) updateNetwork(ctx context.Context, newNetwork []pb.PeerInfo) (string, error) {
 	rawNetwork, err := json.Marshal(newNetwork)
 	if err != nil {
 		err = fmt.Errorf("could not marshal new network: %v", err)
-		return err
+		return "", err
 	}
+	proposer := lp.Meta
 	lightBlock := pb.Lightblock{
-		ID:      uuid.New().String(),
-		Payload: rawNetwork,
-		Type:    pb.Lightblock_NETWORK,
-		PrevID:  lp.state.ID,
+		ID:       uuid.New().String(),
+		Payload:  rawNetwork,
+		Type:     pb.Lightblock_NETWORK,
+		Proposer: &proposer,
 	}
 
-	err = lp.sendNewBlockNotifications(ctx, lightBlock)
+	err = lp.commitBlock(ctx, &lightBlock)
 	if err != nil {
-		err = fmt.Errorf("could not send new block notifications: %v", err)
-		return err
+		return "", err
 	}
 
-	err = lp.writeBlock(lightBlock)
+	lp.setNetwork(newNetwork)
+	lp.closeReplicationStreams(newNetwork)
+	return lightBlock.ID, nil
+}
+
+// commitRequest commits the client block, unless a retry of the same request was committed since the
+// caller checked the dedupe window, in which case the receipt of that commit is returned.
+func (lp *Lightpeer) commitRequest(ctx context.Context, block *pb.Lightblock) (*pb.PersistResponse, error) {
+	lp.commitLock.Lock()
+	defer lp.commitLock.Unlock()
+
+	if block.RequestID != "" {
+		if receipt, ok := lp.requests().lookup(block.RequestID); ok {
+			return &receipt, nil
+		}
+	}
+	if err := lp.commitHeld(ctx, block); err != nil {
+		return nil, err
+	}
+	return &pb.PersistResponse{BlockID: block.ID}, nil
+}
+
+// commitBlock links the block to the current state, notifies the network about it and persists it locally.
+// Commits are serialized, such that concurrent requests do not create blocks with the same parent.
+func (lp *Lightpeer) commitBlock(ctx context.Context, block *pb.Lightblock) error {
+	lp.commitLock.Lock()
+	defer lp.commitLock.Unlock()
+	return lp.commitHeld(ctx, block)
+}
+
+// commitHeld commits the block. Must be called holding the commit lock.
+func (lp *Lightpeer) commitHeld(ctx context.Context, block *pb.Lightblock) error {
+	head := lp.GetState()
+	block.PrevID = head.ID
+	parentHeight, err := lp.height(head)
 	if err != nil {
-		err = fmt.Errorf("could not write new network block: %v", err)
-		return err
+		return fmt.Errorf("could not compute the chain height: %v", err)
+	}
+	block.Height = parentHeight + 1
+	block.LastUpdated = ptypes.TimestampNow()
+	block.NetworkID = lp.networkID()
+
+	err = lp.sendNewBlockNotifications(ctx, *block)
+	if err != nil {
+		return fmt.Errorf("could not send new block notifications: %v", err)
 	}
 
-	lp.state = lightBlock
-	lp.Network = newNetwork
+	err = lp.writeBlock(*block)
+	if err != nil {
+		return fmt.Errorf("could not write new block: %v", err)
+	}
+
+	lp.stateLock.Lock()
+	lp.setState(*block)
+	if block.Type == pb.Lightblock_GENESIS {
+		lp.genesisID = block.ID
+	}
+	if block.Type == pb.Lightblock_NETWORK {
+		lp.networkHeight = block.Height
+	}
+	lp.stateLock.Unlock()
+	lp.saveHead()
+	lp.requests().recordBlock(*block)
+	lp.gossip().markSeen(block.ID)
+	if block.Type == pb.Lightblock_NETWORK {
+		lp.publishMembership(*block)
+	}
 	return nil
 }
 
@@ -249,71 +550,140 @@ type blockResponse struct {
 	err   error
 }
 
+// sendNewBlockNotifications replicates the block to all the peers from the network in parallel.
+// It fails if any of the peers rejects the block, while the blocks for unreachable peers are queued
+// and retried in the background. In gossip mode, the block is only sent to a random subset of peers.
 func (lp *Lightpeer) sendNewBlockNotifications(ctx context.Context, block pb.Lightblock) error {
+	if lp.GossipFanout > 0 {
+		return lp.gossipBlock(ctx, block)
+	}
 
-	for _, peer := range lp.Network {
+	network := lp.GetNetwork()
+	errs := make(chan error, len(network))
+	wg := sync.WaitGroup{}
+	for _, peer := range network {
 		if peer.Address == lp.Meta.Address {
 			continue
 		}
 
-		conn, err := grpc.Dial(peer.Address, grpc.WithInsecure(),
-			grpc.WithUnaryInterceptor(grpctrace.UnaryClientInterceptor(
-				global.Tracer(fmt.Sprintf("client@%s", peer.Address)))),
-			grpc.WithStreamInterceptor(grpctrace.StreamClientInterceptor(
-				global.Tracer(fmt.Sprintf("stream-client@%s", peer.Address)))))
-		if err != nil {
-			return fmt.Errorf("did not connect: %s", err)
+		// peers which missed previous blocks get the new ones in order, through their outbound queue
+		ob := lp.outbox(peer.Address)
+		if ob.depth() > 0 {
+			lp.enqueue(peer.Address, block.ID)
+			continue
 		}
 
-		client := pb.NewLightpeerClient(conn)
-
-		newBlock := &pb.Lightblock{}
-		*newBlock = block
-		_, err = client.NotifyNewBlock(ctx, newBlock)
-		conn.Close()
-
-		errStatus, _ := status.FromError(err)
-		if err != nil && errStatus.Code() == codes.Unknown {
-			return fmt.Errorf("could not notify new block for %v: %v", peer.Address, err)
-		}
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			err := lp.replicate(ctx, address, block)
+			if isRejection(err) {
+				errs <- fmt.Errorf("could not notify new block for %v: %v", address, err)
+			} else if err != nil {
+				lp.enqueue(address, block.ID)
+			}
+		}(peer.Address)
 	}
+	wg.Wait()
+	close(errs)
 
-	return nil
+	return <-errs
 }
 
 func (lp *Lightpeer) NotifyNewBlock(ctx context.Context, newBlock *pb.Lightblock) (*pb.NewBlockResponse, error) {
+	_, err := lp.receiveBlock(ctx, *newBlock)
+	return &pb.NewBlockResponse{}, err
+}
+
+// receiveBlock applies a block sent by another peer. In gossip mode, it returns the ID of the missing
+// parent when the block was held back, and forwards the applied blocks to the network.
+func (lp *Lightpeer) receiveBlock(ctx context.Context, newBlock pb.Lightblock) (string, error) {
 
 	notifyNewBlockCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - notifyNewBlock", lp.Meta.Address))
 	defer span.End()
-	if newBlock.PrevID != lp.state.ID {
-		err := fmt.Errorf("new block links to invalid parent")
-		span.RecordError(notifyNewBlockCtx, err)
-		return &pb.NewBlockResponse{}, err
-	}
 
-	err := lp.writeBlock(*newBlock)
+	lp.stateLock.Lock()
+	applied, missing, err := lp.applyBlock(newBlock)
+	lp.stateLock.Unlock()
+	if len(applied) > 0 {
+		lp.saveHead()
+	}
 	if err != nil {
-		err = fmt.Errorf("could not persist new block: %v", err)
 		span.RecordError(notifyNewBlockCtx, err)
-		return &pb.NewBlockResponse{}, err
+		return "", err
 	}
-	lp.state = *newBlock
 
-	if newBlock.Type == pb.Lightblock_NETWORK {
-		network := []pb.PeerInfo{}
-		err := json.Unmarshal(newBlock.Payload, &network)
-		if err != nil {
-			err = fmt.Errorf("could not unmarshal network block: %v", err)
-			span.RecordError(notifyNewBlockCtx, err)
-			return &pb.NewBlockResponse{}, err
+	if lp.GossipFanout > 0 {
+		for _, block := range applied {
+			go lp.forwardBlock(block)
 		}
+	}
 
-		lp.Network = network
+	if missing != "" {
+		span.AddEvent(notifyNewBlockCtx, fmt.Sprintf("block %s held back until %s is received", newBlock.ID, missing))
+	} else {
+		span.AddEvent(notifyNewBlockCtx, fmt.Sprintf("successfully recorded %d new blocks", len(applied)))
 	}
 
-	span.AddEvent(notifyNewBlockCtx, fmt.Sprintf("successfully recorded new block"))
+	return missing, nil
+}
 
-	return &pb.NewBlockResponse{}, nil
+// applyBlock adds the block on top of the current state, and returns the blocks which were applied.
+// In gossip mode, blocks can arrive out of order, so blocks with an unknown parent are held back
+// and applied together with their parent, and the ID of the missing parent is returned.
+// Must be called holding the state lock.
+func (lp *Lightpeer) applyBlock(newBlock pb.Lightblock) ([]pb.Lightblock, string, error) {
+	gossip := lp.GossipFanout > 0
+	if newBlock.ID != "" && newBlock.ID == lp.state.ID {
+		// the block was retried after its acknowledgement got lost
+		return nil, "", nil
+	}
+	if gossip && lp.gossip().seen(newBlock.ID) {
+		return nil, "", nil
+	}
+	if err := lp.checkNetwork(newBlock); err != nil {
+		return nil, "", err
+	}
+	if err := checkProposer(lp.GetNetwork(), newBlock); err != nil {
+		return nil, "", err
+	}
+
+	if newBlock.PrevID != lp.state.ID {
+		if gossip && newBlock.PrevID != "" && !lp.gossip().seen(newBlock.PrevID) {
+			lp.gossip().holdBack(newBlock)
+			return nil, newBlock.PrevID, nil
+		}
+		return nil, "", fmt.Errorf("new block links to invalid parent")
+	}
+
+	applied := []pb.Lightblock{}
+	for block, ok := newBlock, true; ok; block, ok = lp.gossip().release(block.ID) {
+		err := lp.writeBlock(block)
+		if err != nil {
+			return applied, "", fmt.Errorf("could not persist new block: %v", err)
+		}
+		lp.setState(block)
+		if block.Type == pb.Lightblock_GENESIS {
+			lp.genesisID = block.ID
+		}
+		lp.requests().recordBlock(block)
+		lp.gossip().markSeen(block.ID)
+		applied = append(applied, block)
+
+		if block.Type == pb.Lightblock_NETWORK {
+			network, err := decodeNetwork(block)
+			if err != nil {
+				return applied, "", err
+			}
+
+			lp.setNetwork(network)
+			lp.networkHeight = block.Height
+			lp.closeReplicationStreams(network)
+			lp.publishMembership(block)
+		}
+	}
+
+	return applied, "", nil
 }
 
 func (lp *Lightpeer) readBlocks() <-chan blockResponse {
@@ -321,29 +691,37 @@ func (lp *Lightpeer) readBlocks() <-chan blockResponse {
 	go func() {
 		defer close(outchan)
 
-		outchan <- blockResponse{lp.state, nil}
-		for blockID := lp.state.PrevID; blockID != ""; {
-			blockFilePath := path.Join(lp.StoragePath, blockID)
-			rawBlock, err := ioutil.ReadFile(blockFilePath)
+		head := lp.GetState()
+		outchan <- blockResponse{head, nil}
+		for block := head; block.PrevID != ""; {
+			parent, err := lp.loadBlock(block.PrevID, parentHeight(block))
 			if err != nil {
 				outchan <- blockResponse{pb.Lightblock{}, err}
 				return
 			}
-
-			block := &pb.Lightblock{}
-			err = json.Unmarshal(rawBlock, block)
-			if err != nil {
-				outchan <- blockResponse{pb.Lightblock{}, err}
-				return
-			}
-			outchan <- blockResponse{*block, nil}
-			blockID = block.PrevID
+			outchan <- blockResponse{parent, nil}
+			block = parent
 		}
 	}()
 
 	return outchan
 }
 
+func (lp *Lightpeer) readBlock(blockID string) (pb.Lightblock, error) {
+	blockFilePath := path.Join(lp.StoragePath, blockID)
+	rawBlock, err := ioutil.ReadFile(blockFilePath)
+	if err != nil {
+		return pb.Lightblock{}, err
+	}
+
+	block := pb.Lightblock{}
+	err = json.Unmarshal(rawBlock, &block)
+	if err != nil {
+		return pb.Lightblock{}, err
+	}
+	return block, nil
+}
+
 func (lp *Lightpeer) writeBlock(block pb.Lightblock) error {
 
 	out, err := json.Marshal(block)
@@ -353,21 +731,80 @@ func (lp *Lightpeer) writeBlock(block pb.Lightblock) error {
 	outPath := path.Join(lp.StoragePath, block.ID)
 
 	if err := ioutil.WriteFile(outPath, out, 0666); err != nil {
-		fmt.Errorf("failed to write block: %v", err)
+		return fmt.Errorf("failed to write block: %v", err)
 	}
 
 	return nil
 }
 
-// Check implements `service Health`.
+// Check implements `service Health`. The empty service reports that the peer is alive, WritesService
+// reports whether it accepts writes, and ConsistencyService whether its chain diverged from another member.
 func (lp *Lightpeer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	switch in.Service {
+	case "":
+	case WritesService:
+		if lp.checkWrites() != nil {
+			return &healthpb.HealthCheckResponse{
+				Status: healthpb.HealthCheckResponse_NOT_SERVING,
+			}, nil
+		}
+	case ConsistencyService:
+		if lp.consistency().isDiverged() {
+			return &healthpb.HealthCheckResponse{
+				Status: healthpb.HealthCheckResponse_NOT_SERVING,
+			}, nil
+		}
+	default:
+		return nil, status.Errorf(codes.NotFound, "unknown service %s", in.Service)
+	}
 	return &healthpb.HealthCheckResponse{
 		Status: healthpb.HealthCheckResponse_SERVING,
 	}, nil
 
 }
 
+func (lp *Lightpeer) requests() *dedupeWindow {
+	lp.dedupeOnce.Do(func() {
+		lp.dedupe = newDedupeWindow(lp.DedupeWindow)
+	})
+	return lp.dedupe
+}
+
+// checkWrites refuses client writes while the blocks of the peer would not be accepted by the network.
+func (lp *Lightpeer) checkWrites() error {
+	if lp.members().excluded() {
+		return status.Errorf(codes.FailedPrecondition, "%s was removed from the network and is rejoining", lp.Meta.Address)
+	}
+	if err := lp.checkQuorum(); err != nil {
+		return err
+	}
+	if lp.members().readOnly() {
+		return status.Errorf(codes.FailedPrecondition, "%s is catching up with the network", lp.Meta.Address)
+	}
+
+	lp.stateLock.Lock()
+	defer lp.stateLock.Unlock()
+	return lp.checkGenesis()
+}
+
 // GetState returns the current peer state
 func (lp *Lightpeer) GetState() pb.Lightblock {
+	lp.stateLock.Lock()
+	defer lp.stateLock.Unlock()
 	return lp.state
 }
+
+// GetNetwork returns a copy of the members of the network. Membership changes replace the network while
+// requests and background tasks use it, so it is only read through GetNetwork once the peer is serving.
+func (lp *Lightpeer) GetNetwork() []pb.PeerInfo {
+	lp.networkLock.Lock()
+	defer lp.networkLock.Unlock()
+	return append([]pb.PeerInfo{}, lp.Network...)
+}
+
+// setNetwork replaces the members of the network.
+func (lp *Lightpeer) setNetwork(network []pb.PeerInfo) {
+	lp.networkLock.Lock()
+	defer lp.networkLock.Unlock()
+	lp.Network = network
+}
@@ -15,18 +15,37 @@
 package lightpeer
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
 	"go.opentelemetry.io/otel/api/global"
 	"go.opentelemetry.io/otel/exporters/stdout"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
+func TestMain(m *testing.M) {
+	os.MkdirAll("./testdata", 0777)
+	os.Exit(m.Run())
+}
+
 func TestPersist(t *testing.T) {
 	lp := &Lightpeer{
 		StoragePath: "./testdata",
@@ -198,43 +217,2162 @@ func TestConnectReturnsNetworkTopology(t *testing.T) {
 	}
 }
 
-func initTestOtel() {
-	stdOutExp, err := stdout.NewExporter()
+func TestPersistIgnoresDuplicateRequests(t *testing.T) {
+	lp, err := initPeerFromBlocks("bar", []string{}, false)
 	if err != nil {
-		log.Fatal(err)
+		t.Fatal(err)
 	}
 
-	tp, err := sdktrace.NewProvider(
-		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
-		sdktrace.WithSyncer(stdOutExp))
+	ctxt := context.Background()
+	persistReq := &pb.PersistRequest{Payload: []byte("Hello"), RequestID: "req-1"}
+	first, err := lp.Persist(ctxt, persistReq)
 	if err != nil {
-		log.Fatalf("error creating trace provider: %v\n", err)
+		t.Fatal(err)
+	}
+	second, err := lp.Persist(ctxt, persistReq)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	global.SetTraceProvider(tp)
-}
+	if first.BlockID == "" || first.BlockID != second.BlockID {
+		t.Fatalf("expected the original receipt %s, got %s", first.BlockID, second.BlockID)
+	}
 
-func initPeerFromBlocks(name string, messages []string, verbose bool) (*Lightpeer, error) {
-	if verbose {
-		initTestOtel()
+	queryStream := mockQueryStream{nil, []*pb.QueryResponse{}}
+	lp.Query(&pb.EmptyQueryRequest{}, &queryStream)
+	if len(queryStream.responses) != 1 {
+		t.Fatalf("expected the payload to be persisted once, got %d", len(queryStream.responses))
 	}
+}
+
+func TestRestoredPeerIgnoresDuplicateRequests(t *testing.T) {
+	info := pb.PeerInfo{Address: "localhost:8348"}
 	lp := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        info,
+		Network:     []pb.PeerInfo{info},
+	}
+	ctx := context.Background()
+	persistReq := &pb.PersistRequest{Payload: []byte("Hello"), RequestID: "req-1"}
+	first, err := lp.Persist(ctx, persistReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte("again")}); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := &Lightpeer{
+		StoragePath: lp.StoragePath,
+		Tracer:      global.Tracer("test"),
+		Meta:        info,
+		Network:     []pb.PeerInfo{info},
+	}
+	if err := restarted.RestoreChain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	retried, err := restarted.Persist(ctx, persistReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.BlockID != first.BlockID || restarted.GetState().ID != lp.GetState().ID {
+		t.Fatalf("expected the original receipt %s after the restart, got %s", first.BlockID, retried.BlockID)
+	}
+}
+
+func TestConcurrentRetriesCreateOneBlock(t *testing.T) {
+	lp, err := initPeerFromBlocks("bar", []string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the retries all miss the dedupe window while the first commit holds the lock
+	lp.commitLock.Lock()
+	ctxt := context.Background()
+	receipts := make(chan string, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(receipts); i++ {
+		wg.Add(1)
+		go func(batch bool) {
+			defer wg.Done()
+			var receipt *pb.PersistResponse
+			var err error
+			if batch {
+				receipt, err = lp.PersistBatch(ctxt, &pb.PersistBatchRequest{Payloads: [][]byte{[]byte("Hello")}, RequestID: "req-1"})
+			} else {
+				receipt, err = lp.Persist(ctxt, &pb.PersistRequest{Payload: []byte("Hello"), RequestID: "req-1"})
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			receipts <- receipt.BlockID
+		}(i%2 == 0)
+	}
+	time.Sleep(50 * time.Millisecond)
+	lp.commitLock.Unlock()
+	wg.Wait()
+	close(receipts)
+
+	blockIDs := map[string]bool{}
+	for blockID := range receipts {
+		blockIDs[blockID] = true
+	}
+	if len(blockIDs) != 1 {
+		t.Fatalf("expected all the retries to get the same receipt, got %v", blockIDs)
+	}
+	if height, err := lp.height(lp.GetState()); err != nil || height != 1 {
+		t.Fatalf("expected a single block, got a chain of height %d: %v", height, err)
+	}
+}
+
+func TestQueriesAndNotificationsDuringPersist(t *testing.T) {
+	writer, err := initPeerFromBlocks("bar", []string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := &Lightpeer{StoragePath: t.TempDir(), Tracer: global.Tracer("test")}
+
+	ctxt := context.Background()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			if _, err := writer.Persist(ctxt, &pb.PersistRequest{Payload: []byte(fmt.Sprintf("block %d", i))}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		writer.Query(&pb.EmptyQueryRequest{}, &mockQueryStream{nil, []*pb.QueryResponse{}})
+		// blocks from another chain are refused, after reading the head
+		other.Persist(ctxt, &pb.PersistRequest{Payload: []byte("other")})
+		head := other.GetState()
+		writer.NotifyNewBlock(ctxt, &head)
+	}
+}
+
+func TestReplicatedBlocksDeduplicateRequests(t *testing.T) {
+	writer, err := initPeerFromBlocks("bar", []string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctxt := context.Background()
+	persistReq := &pb.PersistRequest{Payload: []byte("Hello"), RequestID: "req-1"}
+	receipt, err := writer.Persist(ctxt, persistReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the client fails over to a peer which only saw the replicated block
+	replica := &Lightpeer{
 		StoragePath: "./testdata",
 		Tracer:      global.Tracer("test"),
-		Network: []pb.PeerInfo{
-			pb.PeerInfo{Name: name},
-		},
 	}
+	newBlock := writer.GetState()
+	_, err = replica.NotifyNewBlock(ctxt, &newBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retryReceipt, err := replica.Persist(ctxt, persistReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retryReceipt.BlockID != receipt.BlockID {
+		t.Fatalf("expected the original receipt %s, got %s", receipt.BlockID, retryReceipt.BlockID)
+	}
+	if replica.GetState().ID != receipt.BlockID {
+		t.Fatalf("duplicate request created a new block on the replica")
+	}
+}
+
+func TestDedupeWindowIsBounded(t *testing.T) {
+	dw := newDedupeWindow(2)
+	dw.record("req-1", pb.PersistResponse{BlockID: "1"})
+	dw.record("req-2", pb.PersistResponse{BlockID: "2"})
+	dw.record("req-3", pb.PersistResponse{BlockID: "3"})
+
+	if _, ok := dw.lookup("req-1"); ok {
+		t.Fatalf("oldest request was not evicted from the window")
+	}
+	for _, reqID := range []string{"req-2", "req-3"} {
+		if _, ok := dw.lookup(reqID); !ok {
+			t.Fatalf("request %s missing from the window", reqID)
+		}
+	}
+}
+
+func TestGroupCommitPacksConcurrentRequests(t *testing.T) {
+	lp, err := initPeerFromBlocks("bar", []string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp.BatchWindow = 100 * time.Millisecond
+
+	messages := []string{"Hello", "from", "the", "test", "side!"}
+	receipts := make([]*pb.PersistResponse, len(messages))
+	wg := sync.WaitGroup{}
+	for i, msg := range messages {
+		wg.Add(1)
+		go func(i int, msg string) {
+			defer wg.Done()
+			receipt, err := lp.Persist(context.Background(), &pb.PersistRequest{Payload: []byte(msg)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			receipts[i] = receipt
+		}(i, msg)
+	}
+	wg.Wait()
+
+	state := lp.GetState()
+	if len(state.Entries) != len(messages) {
+		t.Fatalf("expected one block with %d entries, got %d", len(messages), len(state.Entries))
+	}
+	for i, receipt := range receipts {
+		if receipt.BlockID != state.ID {
+			t.Fatalf("receipt %d points to block %s instead of %s", i, receipt.BlockID, state.ID)
+		}
+		entry := state.Entries[receipt.Index]
+		if string(entry.Payload) != messages[i] {
+			t.Fatalf("receipt %d points to the wrong entry: expected %s, got %s", i, messages[i], entry.Payload)
+		}
+	}
+
+	queryStream := mockQueryStream{nil, []*pb.QueryResponse{}}
+	lp.Query(&pb.EmptyQueryRequest{}, &queryStream)
+	if len(queryStream.responses) != len(messages) {
+		t.Fatalf("expected %d entries from query, got %d", len(messages), len(queryStream.responses))
+	}
+}
+
+func TestGroupCommitRespectsMaxBatchSize(t *testing.T) {
+	lp, err := initPeerFromBlocks("bar", []string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp.BatchWindow = time.Second
+	lp.MaxBatchSize = 2
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lp.Persist(context.Background(), &pb.PersistRequest{Payload: []byte("Hello")})
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("full batch was not committed before the batch window expired")
+	}
+}
+
+func TestGroupCommitDeduplicatesRequests(t *testing.T) {
+	lp, err := initPeerFromBlocks("bar", []string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp.BatchWindow = 50 * time.Millisecond
+
+	persistReq := &pb.PersistRequest{Payload: []byte("Hello"), RequestID: "req-1"}
+	first, err := lp.Persist(context.Background(), persistReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := lp.Persist(context.Background(), persistReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.BlockID != second.BlockID || first.Index != second.Index {
+		t.Fatalf("expected the original receipt %v, got %v", first, second)
+	}
+}
+
+func TestStoppedGroupCommitRefusesRequests(t *testing.T) {
+	lp, err := initPeerFromBlocks("bar", []string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp.BatchWindow = 10 * time.Millisecond
+
+	ctx := context.Background()
+	if _, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")}); err != nil {
+		t.Fatal(err)
+	}
+	lp.StopGroupCommit()
+	lp.StopGroupCommit()
+	if _, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected the stopped group committer to refuse requests, got %v", err)
+	}
+}
+
+func TestPersistBatchWritesOneBlock(t *testing.T) {
+	lp, err := initPeerFromBlocks("bar", []string{"before"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := [][]byte{[]byte("debit"), []byte("credit")}
+	receipt, err := lp.PersistBatch(context.Background(), &pb.PersistBatchRequest{Payloads: payloads})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queryStream := mockQueryStream{nil, []*pb.QueryResponse{}}
+	lp.Query(&pb.EmptyQueryRequest{}, &queryStream)
+	if len(queryStream.responses) != 3 {
+		t.Fatalf("expected 3 payloads, got %d", len(queryStream.responses))
+	}
+
+	for i, rsp := range queryStream.responses[:2] {
+		if rsp.BlockID != receipt.BlockID || rsp.BlockSize != 2 {
+			t.Fatalf("payload %d is not part of the batch block: %v", i, rsp)
+		}
+		if string(rsp.Payload) != string(payloads[rsp.Index]) {
+			t.Fatalf("wrong payload on index %d: expected %s, got %s", rsp.Index, payloads[rsp.Index], rsp.Payload)
+		}
+	}
+
+	last := queryStream.responses[2]
+	if last.BlockID == receipt.BlockID || last.BlockSize != 1 || string(last.Payload) != "before" {
+		t.Fatalf("unexpected payload before the batch: %v", last)
+	}
+}
+
+func TestPersistBatchRejectsEmptyBatches(t *testing.T) {
+	lp, err := initPeerFromBlocks("bar", []string{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = lp.PersistBatch(context.Background(), &pb.PersistBatchRequest{})
+	if err == nil {
+		t.Fatalf("expected empty batch to be rejected")
+	}
+	if lp.GetState().ID != "" {
+		t.Fatalf("empty batch created a block")
+	}
+}
+
+func TestUndeliveredBlocksAreRetried(t *testing.T) {
+	writerInfo := pb.PeerInfo{Address: "localhost:8290"}
+	replicaInfo := pb.PeerInfo{Address: "localhost:8291"}
+	writer := &Lightpeer{
+		StoragePath:  "./testdata",
+		Tracer:       global.Tracer("test"),
+		Meta:         writerInfo,
+		Network:      []pb.PeerInfo{writerInfo, replicaInfo},
+		RetryBackoff: 10 * time.Millisecond,
+	}
+
 	ctxt := context.Background()
+	_, err := writer.Persist(ctxt, &pb.PersistRequest{Payload: []byte("Hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = writer.Persist(ctxt, &pb.PersistRequest{Payload: []byte("again")})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	for _, msg := range messages {
-		persistReq := &pb.PersistRequest{
-			Payload: []byte(msg),
+	if depth := writer.OutboundQueueDepth()[replicaInfo.Address]; depth != 2 {
+		t.Fatalf("expected 2 queued blocks for the unreachable peer, got %d", depth)
+	}
+
+	// a new peer loads the queue left behind by the previous one
+	restarted := &Lightpeer{StoragePath: "./testdata", Meta: writerInfo}
+	if depth := restarted.outbox(replicaInfo.Address).depth(); depth != 2 {
+		t.Fatalf("expected the queue to be persisted, got %d blocks", depth)
+	}
+	restarted.outbox(replicaInfo.Address).drop()
+
+	replica := &Lightpeer{
+		StoragePath: "./testdata",
+		Tracer:      global.Tracer("test"),
+		Meta:        replicaInfo,
+		Network:     []pb.PeerInfo{writerInfo, replicaInfo},
+	}
+	stop, err := serveTestPeer(replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for writer.OutboundQueueDepth()[replicaInfo.Address] != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queued blocks were not delivered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if replica.GetState().ID != writer.GetState().ID {
+		t.Fatalf("replica did not receive the queued blocks in order")
+	}
+}
+
+func TestOutboundQueueIsDroppedForRemovedPeers(t *testing.T) {
+	writerInfo := pb.PeerInfo{Address: "localhost:8292"}
+	removedInfo := pb.PeerInfo{Address: "localhost:8293"}
+	writer := &Lightpeer{
+		StoragePath:  "./testdata",
+		Tracer:       global.Tracer("test"),
+		Meta:         writerInfo,
+		Network:      []pb.PeerInfo{writerInfo, removedInfo},
+		RetryBackoff: 10 * time.Millisecond,
+	}
+
+	_, err := writer.Persist(context.Background(), &pb.PersistRequest{Payload: []byte("Hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.setNetwork([]pb.PeerInfo{writerInfo})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(writer.OutboundQueueDepth()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue of the removed peer was not dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueuedBlocksAreDeliveredAfterRestart(t *testing.T) {
+	writerInfo := pb.PeerInfo{Address: "localhost:8344"}
+	replicaInfo := pb.PeerInfo{Address: "localhost:8345"}
+	network := []pb.PeerInfo{writerInfo, replicaInfo}
+	writer := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        writerInfo,
+		Network:     network,
+		// the writer stops retrying, as if it was stopped
+		RetryBackoff:    time.Hour,
+		MaxRetryBackoff: time.Hour,
+	}
+	ctx := context.Background()
+	if _, err := writer.updateNetwork(ctx, network); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the restarted writer finds the chain and the queue left behind by the previous one
+	restarted := &Lightpeer{
+		StoragePath:  t.TempDir(),
+		Tracer:       global.Tracer("test"),
+		Meta:         writerInfo,
+		Network:      []pb.PeerInfo{writerInfo},
+		RetryBackoff: 10 * time.Millisecond,
+	}
+	files, err := ioutil.ReadDir(writer.StoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		raw, err := ioutil.ReadFile(path.Join(writer.StoragePath, f.Name()))
+		if err == nil {
+			err = ioutil.WriteFile(path.Join(restarted.StoragePath, f.Name()), raw, 0666)
 		}
-		_, err := lp.Persist(ctxt, persistReq)
 		if err != nil {
-			return nil, err
+			t.Fatal(err)
 		}
 	}
-	return lp, nil
+	if err := restarted.RestoreChain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if depth := restarted.OutboundQueueDepth()[replicaInfo.Address]; depth != 2 {
+		t.Fatalf("expected the 2 queued blocks to be resumed, got %d", depth)
+	}
+
+	replica := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        replicaInfo,
+		Network:     network,
+	}
+	stop, err := serveTestPeer(replica)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for restarted.OutboundQueueDepth()[replicaInfo.Address] != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queued blocks were not delivered after the restart")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if replica.GetState().ID != writer.GetState().ID {
+		t.Fatalf("replica did not receive the queued blocks")
+	}
+}
+
+func TestDroppedOutboxesAreNotReused(t *testing.T) {
+	info := pb.PeerInfo{Address: "localhost:8346"}
+	lp := &Lightpeer{
+		StoragePath:     t.TempDir(),
+		Tracer:          global.Tracer("test"),
+		Meta:            info,
+		Network:         []pb.PeerInfo{info, {Address: "localhost:8340"}},
+		RetryBackoff:    time.Hour,
+		MaxRetryBackoff: time.Hour,
+	}
+
+	stale := lp.outbox("localhost:8340")
+	stale.drop()
+	if stale.enqueue("block") {
+		t.Fatalf("expected a dropped outbox to refuse blocks")
+	}
+
+	lp.enqueue("localhost:8340", "block")
+	if current := lp.outbox("localhost:8340"); current == stale || current.depth() != 1 {
+		t.Fatalf("expected the block to be queued in a new outbox")
+	}
+}
+
+func TestGossipAppliesOutOfOrderBlocks(t *testing.T) {
+	lp := &Lightpeer{
+		StoragePath:  t.TempDir(),
+		Tracer:       global.Tracer("test"),
+		GossipFanout: 3,
+	}
+	defer lp.StopGossip()
+
+	first := pb.Lightblock{ID: "gossip-1", Payload: []byte("Hello"), Type: pb.Lightblock_CLIENT}
+	second := pb.Lightblock{ID: "gossip-2", PrevID: "gossip-1", Payload: []byte("again"), Type: pb.Lightblock_CLIENT}
+
+	missing, err := lp.receiveBlock(context.Background(), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing != first.ID {
+		t.Fatalf("expected the peer to ask for %s, got %q", first.ID, missing)
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err = lp.NotifyNewBlock(context.Background(), &first)
+		if err != nil {
+			t.Fatalf("repeated block was not ignored: %v", err)
+		}
+	}
+	if lp.GetState().ID != second.ID {
+		t.Fatalf("held back block was not applied after its parent, head is %s", lp.GetState().ID)
+	}
+
+	_, err = lp.NotifyNewBlock(context.Background(), &pb.Lightblock{ID: "fork", PrevID: "gossip-1"})
+	if err == nil {
+		t.Fatalf("expected blocks forking the chain to be rejected")
+	}
+}
+
+func TestGossipConvergesOnLargeNetworks(t *testing.T) {
+	const peerCount = 50
+	const blockCount = 20
+
+	listeners := map[string]*bufconn.Listener{}
+	dialer := grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+		lis, ok := listeners[address]
+		if !ok {
+			return nil, fmt.Errorf("unknown peer %s", address)
+		}
+		return lis.Dial()
+	})
+
+	network := []pb.PeerInfo{}
+	for i := 0; i < peerCount; i++ {
+		address := fmt.Sprintf("gossip-peer-%d", i)
+		network = append(network, pb.PeerInfo{Name: address, Address: address})
+		listeners[address] = bufconn.Listen(1 << 16)
+	}
+
+	peers := []*Lightpeer{}
+	for _, info := range network {
+		lp := &Lightpeer{
+			StoragePath:    t.TempDir(),
+			Tracer:         global.Tracer("test"),
+			Meta:           info,
+			Network:        network,
+			GossipFanout:   3,
+			GossipInterval: 50 * time.Millisecond,
+			DialOptions:    []grpc.DialOption{dialer},
+		}
+		grpcServer := grpc.NewServer()
+		pb.RegisterLightpeerServer(grpcServer, lp)
+		go grpcServer.Serve(listeners[info.Address])
+		defer grpcServer.Stop()
+		defer lp.StopGossip()
+		peers = append(peers, lp)
+	}
+
+	writer := peers[0]
+	for i := 0; i < blockCount; i++ {
+		_, err := writer.Persist(context.Background(), &pb.PersistRequest{Payload: []byte(fmt.Sprintf("block %d", i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	head := writer.GetState().ID
+
+	deadline := time.Now().Add(30 * time.Second)
+	for _, lp := range peers {
+		for lp.GetState().ID != head {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s did not converge to the head of the writer", lp.Meta.Address)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		chainLength := 0
+		for blockID := head; blockID != ""; chainLength++ {
+			block, err := lp.readBlock(blockID)
+			if err != nil {
+				t.Fatalf("%s has an incomplete chain: %v", lp.Meta.Address, err)
+			}
+			blockID = block.PrevID
+		}
+		if chainLength != blockCount {
+			t.Fatalf("%s has %d blocks, expected %d", lp.Meta.Address, chainLength, blockCount)
+		}
+	}
+}
+
+func TestSuspectedPeerRefutesSuspicion(t *testing.T) {
+	suspected := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        pb.PeerInfo{Address: "suspected"},
+	}
+	prober := &Lightpeer{Meta: pb.PeerInfo{Address: "prober"}}
+	prober.members().suspect("suspected")
+
+	resp, err := suspected.Probe(context.Background(), &pb.ProbeRequest{
+		Members: []*pb.MemberState{{Address: "suspected", Status: pb.MemberState_SUSPECT}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Incarnation != 1 {
+		t.Fatalf("expected the suspected peer to increase its incarnation, got %d", resp.Incarnation)
+	}
+
+	prober.members().ack("suspected", resp.Incarnation)
+	if failed := prober.members().expired(0); len(failed) != 0 {
+		t.Fatalf("refuted suspicion was not cleared: %v", failed)
+	}
+
+	// older suspicions do not override the refutation
+	prober.members().merge([]*pb.MemberState{{Address: "suspected", Status: pb.MemberState_SUSPECT}}, "prober")
+	if failed := prober.members().expired(0); len(failed) != 0 {
+		t.Fatalf("old suspicion overrode the refutation: %v", failed)
+	}
+}
+
+func TestFailedPeerIsEvictedAfterSuspicion(t *testing.T) {
+	checkerInfo := pb.PeerInfo{Address: "localhost:8294"}
+	liveInfo := pb.PeerInfo{Address: "localhost:8295"}
+	failedInfo := pb.PeerInfo{Address: "localhost:8296"}
+	network := []pb.PeerInfo{checkerInfo, liveInfo, failedInfo}
+
+	checker := &Lightpeer{
+		StoragePath:      t.TempDir(),
+		Tracer:           global.Tracer("test"),
+		Meta:             checkerInfo,
+		Network:          network,
+		ProbeInterval:    20 * time.Millisecond,
+		ProbeTimeout:     50 * time.Millisecond,
+		SuspicionTimeout: 200 * time.Millisecond,
+	}
+	live := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        liveInfo,
+		Network:     network,
+	}
+	stop, err := serveTestPeer(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nhc := &NetworkHealthChecker{Lp: checker}
+	nhc.StartPeerHealthCheck(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for live.GetState().ID == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("failed peer was not evicted, network is %v", checker.GetNetwork())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(live.GetNetwork()) != 2 || containsAddress(live.GetNetwork(), failedInfo.Address) {
+		t.Fatalf("expected only the failed peer to be evicted, got %v", live.GetNetwork())
+	}
+}
+
+func TestConcurrentEvictionsCreateOneNetworkBlock(t *testing.T) {
+	coordinatorInfo := pb.PeerInfo{Address: "localhost:8297"}
+	peerInfo := pb.PeerInfo{Address: "localhost:8298"}
+	failedInfo := pb.PeerInfo{Address: "localhost:8299"}
+	network := []pb.PeerInfo{coordinatorInfo, peerInfo, failedInfo}
+
+	peers := []*Lightpeer{}
+	for _, info := range []pb.PeerInfo{coordinatorInfo, peerInfo} {
+		lp := &Lightpeer{
+			StoragePath: t.TempDir(),
+			Tracer:      global.Tracer("test"),
+			Meta:        info,
+			Network:     network,
+		}
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+
+	var wg sync.WaitGroup
+	for _, lp := range peers {
+		wg.Add(1)
+		go func(lp *Lightpeer) {
+			defer wg.Done()
+			// each proposal gets its own message, which gRPC marshals concurrently
+			failed := failedInfo
+			_, err := lp.changeMembership(context.Background(), &pb.MembershipChange{
+				Type:  pb.MembershipChange_EVICT,
+				Peers: []*pb.PeerInfo{&failed},
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(lp)
+	}
+	wg.Wait()
+
+	networkBlocks := 0
+	for blockResp := range peers[0].readBlocks() {
+		if blockResp.err != nil {
+			t.Fatal(blockResp.err)
+		}
+		if blockResp.block.Type == pb.Lightblock_NETWORK {
+			networkBlocks++
+		}
+	}
+	if networkBlocks != 1 {
+		t.Fatalf("expected one NETWORK block for the eviction, got %d", networkBlocks)
+	}
+	if peers[1].GetState().ID != peers[0].GetState().ID || len(peers[1].GetNetwork()) != 2 {
+		t.Fatalf("peer did not receive the eviction from the coordinator")
+	}
+}
+
+func TestCoordinatorFailover(t *testing.T) {
+	failedInfo := pb.PeerInfo{Address: "localhost:8300"}
+	nextInfo := pb.PeerInfo{Address: "localhost:8301"}
+	peerInfo := pb.PeerInfo{Address: "localhost:8302"}
+	network := []pb.PeerInfo{failedInfo, nextInfo, peerInfo}
+
+	peers := []*Lightpeer{}
+	for _, info := range []pb.PeerInfo{nextInfo, peerInfo} {
+		lp := &Lightpeer{
+			StoragePath: t.TempDir(),
+			Tracer:      global.Tracer("test"),
+			Meta:        info,
+			Network:     network,
+		}
+		lp.members().suspect(failedInfo.Address)
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+
+	if coordinator := peers[1].coordinator(); coordinator.Address != nextInfo.Address {
+		t.Fatalf("expected %s to take over as coordinator, got %s", nextInfo.Address, coordinator.Address)
+	}
+
+	blockID, err := peers[1].changeMembership(context.Background(), &pb.MembershipChange{
+		Type:  pb.MembershipChange_EVICT,
+		Peers: []*pb.PeerInfo{&failedInfo},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers[0].GetState().ID != blockID || peers[1].GetState().ID != blockID {
+		t.Fatalf("eviction was not recorded by the new coordinator")
+	}
+}
+
+func TestLeaveNetworkRemovesPeer(t *testing.T) {
+	coordinatorInfo := pb.PeerInfo{Address: "localhost:8303"}
+	leavingInfo := pb.PeerInfo{Address: "localhost:8304"}
+	network := []pb.PeerInfo{coordinatorInfo, leavingInfo}
+
+	peers := []*Lightpeer{}
+	for _, info := range network {
+		lp := &Lightpeer{
+			StoragePath: t.TempDir(),
+			Tracer:      global.Tracer("test"),
+			Meta:        info,
+			Network:     network,
+		}
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+	coordinator, leaving := peers[0], peers[1]
+
+	nhc := &NetworkHealthChecker{Lp: leaving}
+	nhc.StartPeerHealthCheck(context.Background())
+
+	_, err := leaving.LeaveNetwork(context.Background(), &pb.LeaveRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(coordinator.GetNetwork()) != 1 || coordinator.GetState().Type != pb.Lightblock_NETWORK {
+		t.Fatalf("coordinator did not record the leave, network is %v", coordinator.GetNetwork())
+	}
+	if len(leaving.GetNetwork()) != 1 || leaving.healthChecker != nil {
+		t.Fatalf("leaving peer is still part of the network")
+	}
+
+	_, err = leaving.Persist(context.Background(), &pb.PersistRequest{Payload: []byte("Hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coordinator.GetState().Type != pb.Lightblock_NETWORK {
+		t.Fatalf("peer which left the network still replicates its blocks")
+	}
+}
+
+func TestGetStatusReportsHeadAndMembership(t *testing.T) {
+	selfInfo := pb.PeerInfo{Address: "localhost:8305"}
+	unreachableInfo := pb.PeerInfo{Address: "localhost:8306"}
+	lp := &Lightpeer{
+		StoragePath:  t.TempDir(),
+		Tracer:       global.Tracer("test"),
+		Meta:         selfInfo,
+		Network:      []pb.PeerInfo{selfInfo, unreachableInfo},
+		RetryBackoff: time.Second,
+	}
+
+	for _, msg := range []string{"Hello", "again"} {
+		_, err := lp.Persist(context.Background(), &pb.PersistRequest{Payload: []byte(msg)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := lp.GetStatus(context.Background(), &pb.StatusRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.HeadID != lp.GetState().ID || status.Height != 2 {
+		t.Fatalf("wrong head reported: %s at height %d", status.HeadID, status.Height)
+	}
+	if status.Peer.Address != selfInfo.Address || status.Coordinator != selfInfo.Address || status.Version != Version {
+		t.Fatalf("wrong identity reported: %v", status)
+	}
+	if status.StorageBytes <= 0 {
+		t.Fatalf("storage usage was not reported")
+	}
+	if len(status.Members) != 2 || status.Members[1].OutboundQueueDepth != 2 || status.Members[1].LastSeen != nil {
+		t.Fatalf("wrong membership reported: %v", status.Members)
+	}
+	lp.setNetwork([]pb.PeerInfo{selfInfo})
+}
+
+func TestHeightOfBlocksWithoutHeight(t *testing.T) {
+	lp := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+	}
+
+	// blocks written before heights were recorded
+	for i, id := range []string{"legacy-1", "legacy-2"} {
+		block := &pb.Lightblock{ID: id, PrevID: lp.GetState().ID, Payload: []byte(fmt.Sprint(i)), Type: pb.Lightblock_CLIENT}
+		if _, err := lp.NotifyNewBlock(context.Background(), block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if height, err := lp.height(lp.GetState()); err != nil || height != 2 {
+		t.Fatalf("expected height 2, got %d: %v", height, err)
+	}
+
+	_, err := lp.Persist(context.Background(), &pb.PersistRequest{Payload: []byte("Hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height := lp.GetState().Height; height != 3 {
+		t.Fatalf("expected the new block at height 3, got %d", height)
+	}
+}
+
+func TestMembershipHistoryDecodesNetworkBlocks(t *testing.T) {
+	selfInfo := pb.PeerInfo{Address: "localhost:8307"}
+	otherInfo := pb.PeerInfo{Address: "localhost:8308"}
+	lp := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        selfInfo,
+		Network:     []pb.PeerInfo{selfInfo},
+	}
+
+	ctx := context.Background()
+	for _, changeType := range []pb.MembershipChange_ChangeType{pb.MembershipChange_JOIN, pb.MembershipChange_EVICT} {
+		_, err := lp.applyMembershipChange(ctx, &pb.MembershipChange{Type: changeType, Peers: []*pb.PeerInfo{&otherInfo}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := lp.GetMembershipHistory(ctx, &pb.MembershipHistoryRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Events) != 2 {
+		t.Fatalf("expected 2 membership changes, got %d", len(history.Events))
+	}
+
+	joined, evicted := history.Events[0], history.Events[1]
+	if len(joined.Peers) != 2 || len(joined.Joined) != 2 || len(joined.Left) != 0 || joined.Timestamp == nil {
+		t.Fatalf("wrong join event: %v", joined)
+	}
+	if len(evicted.Peers) != 1 || len(evicted.Joined) != 0 || len(evicted.Left) != 1 ||
+		evicted.Left[0].Address != otherInfo.Address || evicted.Height != 2 {
+		t.Fatalf("wrong eviction event: %v", evicted)
+	}
+}
+
+func TestWatchMembershipStreamsChanges(t *testing.T) {
+	selfInfo := pb.PeerInfo{Address: "localhost:8309"}
+	otherInfo := pb.PeerInfo{Address: "localhost:8310"}
+	lp := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        selfInfo,
+		Network:     []pb.PeerInfo{selfInfo},
+	}
+	stop, err := serveTestPeer(lp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = lp.applyMembershipChange(ctx, &pb.MembershipChange{Type: pb.MembershipChange_JOIN, Peers: []*pb.PeerInfo{&otherInfo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := lp.dial(selfInfo.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := pb.NewLightpeerClient(conn).WatchMembership(ctx, &pb.WatchMembershipRequest{IncludeHistory: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Joined) != 2 {
+		t.Fatalf("expected the recorded join first, got %v", event)
+	}
+
+	_, err = lp.applyMembershipChange(ctx, &pb.MembershipChange{Type: pb.MembershipChange_EVICT, Peers: []*pb.PeerInfo{&otherInfo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Left) != 1 || event.Left[0].Address != otherInfo.Address || event.BlockID != lp.GetState().ID {
+		t.Fatalf("expected the eviction to be streamed, got %v", event)
+	}
+}
+
+func serveTestPeer(lp *Lightpeer) (func(), error) {
+	lis, err := net.Listen("tcp", lp.Meta.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterLightpeerServer(grpcServer, lp)
+	go grpcServer.Serve(lis)
+	return grpcServer.Stop, nil
+}
+
+func initTestOtel() {
+	stdOutExp, err := stdout.NewExporter()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tp, err := sdktrace.NewProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithSyncer(stdOutExp))
+	if err != nil {
+		log.Fatalf("error creating trace provider: %v\n", err)
+	}
+
+	global.SetTraceProvider(tp)
+}
+
+func initPeerFromBlocks(name string, messages []string, verbose bool) (*Lightpeer, error) {
+	if verbose {
+		initTestOtel()
+	}
+	lp := &Lightpeer{
+		StoragePath: "./testdata",
+		Tracer:      global.Tracer("test"),
+		Network: []pb.PeerInfo{
+			pb.PeerInfo{Name: name},
+		},
+	}
+	ctxt := context.Background()
+
+	for _, msg := range messages {
+		persistReq := &pb.PersistRequest{
+			Payload: []byte(msg),
+		}
+		_, err := lp.Persist(ctxt, persistReq)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lp, nil
+}
+
+func TestNodeIDIsStable(t *testing.T) {
+	storagePath := t.TempDir()
+	id, err := NodeID(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := NodeID(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" || id != again {
+		t.Fatalf("expected a stable node ID, got %q and %q", id, again)
+	}
+
+	other, err := NodeID(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == id {
+		t.Fatalf("expected peers with different storage to have different IDs, got %q", id)
+	}
+}
+
+func TestRejoinWithNewAddressUpdatesPeer(t *testing.T) {
+	selfInfo := pb.PeerInfo{Address: "localhost:8311", ID: "self"}
+	otherInfo := pb.PeerInfo{Address: "localhost:8312", ID: "other"}
+	lp := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        selfInfo,
+		Network:     []pb.PeerInfo{selfInfo},
+	}
+
+	ctx := context.Background()
+	_, err := lp.applyMembershipChange(ctx, &pb.MembershipChange{Type: pb.MembershipChange_JOIN, Peers: []*pb.PeerInfo{&otherInfo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movedInfo := pb.PeerInfo{Address: "localhost:8313", ID: otherInfo.ID}
+	blockID, err := lp.applyMembershipChange(ctx, &pb.MembershipChange{Type: pb.MembershipChange_JOIN, Peers: []*pb.PeerInfo{&movedInfo}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockID == "" {
+		t.Fatal("expected the address change to create a NETWORK block")
+	}
+	if len(lp.GetNetwork()) != 2 || lp.GetNetwork()[1].Address != movedInfo.Address {
+		t.Fatalf("expected the peer to keep its place with the new address, got %v", lp.GetNetwork())
+	}
+
+	history, err := lp.GetMembershipHistory(ctx, &pb.MembershipHistoryRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := history.Events[len(history.Events)-1]
+	if len(updated.Joined) != 0 || len(updated.Left) != 0 || len(updated.Updated) != 1 ||
+		updated.Updated[0].Address != movedInfo.Address {
+		t.Fatalf("wrong update event: %v", updated)
+	}
+}
+
+func TestPeersJoinOnlyTheirNetwork(t *testing.T) {
+	peers := []*Lightpeer{}
+	for _, port := range []int{8314, 8315, 8316} {
+		info := pb.PeerInfo{Address: fmt.Sprintf("localhost:%d", port)}
+		lp := &Lightpeer{
+			StoragePath: t.TempDir(),
+			Tracer:      global.Tracer("test"),
+			Meta:        info,
+			Network:     []pb.PeerInfo{info},
+		}
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+	founder, joiner, stranger := peers[0], peers[1], peers[2]
+	stranger.NetworkID = "other"
+
+	ctx := context.Background()
+	networkID, err := founder.CreateGenesis(ctx, map[string]string{"owner": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis, err := DecodeGenesis(founder.GetState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genesis.NetworkID != networkID || genesis.Config["owner"] != "test" || len(genesis.Network) != 1 {
+		t.Fatalf("wrong genesis: %v", genesis)
+	}
+
+	_, err = joiner.JoinNetwork(ctx, &pb.JoinRequest{Address: founder.Meta.Address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joiner.networkID() != networkID {
+		t.Fatalf("expected the joiner to adopt network %q, got %q", networkID, joiner.networkID())
+	}
+
+	_, err = stranger.JoinNetwork(ctx, &pb.JoinRequest{Address: founder.Meta.Address})
+	if err == nil || !strings.Contains(err.Error(), codes.FailedPrecondition.String()) {
+		t.Fatalf("expected a peer from another network to be refused, got %v", err)
+	}
+	if len(founder.GetNetwork()) != 2 {
+		t.Fatalf("peer from another network was added to the network: %v", founder.GetNetwork())
+	}
+
+	foreignBlock := pb.Lightblock{ID: "foreign", PrevID: founder.GetState().ID, NetworkID: "other"}
+	_, err = founder.NotifyNewBlock(ctx, &foreignBlock)
+	if err == nil {
+		t.Fatal("expected a block from another network to be rejected")
+	}
+}
+
+func TestRequireGenesisRefusesWrites(t *testing.T) {
+	lp := &Lightpeer{
+		StoragePath:    t.TempDir(),
+		Tracer:         global.Tracer("test"),
+		RequireGenesis: true,
+	}
+
+	ctx := context.Background()
+	_, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected writes to be refused without a genesis, got %v", err)
+	}
+
+	_, err = lp.CreateGenesis(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = lp.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lp.GetState().NetworkID == "" {
+		t.Fatal("expected new blocks to carry the network ID")
+	}
+
+	_, err = lp.CreateGenesis(ctx, nil)
+	if err == nil {
+		t.Fatal("expected a second genesis to be refused")
+	}
+}
+
+func TestJoinTokensExpire(t *testing.T) {
+	policy := &TokenPolicy{Secret: []byte("secret")}
+	ctx := context.Background()
+	peerInfo := &pb.PeerInfo{Address: "localhost:8317"}
+
+	valid := NewJoinToken(policy.Secret, time.Now().Add(time.Minute))
+	if err := policy.Admit(ctx, &pb.ConnectRequest{Peer: peerInfo, Token: valid}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{
+		"missing":      "",
+		"expired":      NewJoinToken(policy.Secret, time.Now().Add(-time.Minute)),
+		"wrong secret": NewJoinToken([]byte("other"), time.Now().Add(time.Minute)),
+		"malformed":    "token",
+	} {
+		if err := policy.Admit(ctx, &pb.ConnectRequest{Peer: peerInfo, Token: token}); err == nil {
+			t.Fatalf("expected the %s token to be refused", name)
+		}
+	}
+}
+
+func TestJoinPolicyRefusesPeers(t *testing.T) {
+	peers := []*Lightpeer{}
+	for _, port := range []int{8317, 8318} {
+		info := pb.PeerInfo{Address: fmt.Sprintf("localhost:%d", port)}
+		lp := &Lightpeer{
+			StoragePath: t.TempDir(),
+			Tracer:      global.Tracer("test"),
+			Meta:        info,
+			Network:     []pb.PeerInfo{info},
+		}
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+	member, joiner := peers[0], peers[1]
+
+	secret := []byte("secret")
+	allowlist, err := NewAllowlistPolicy([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member.JoinPolicy = AllOf(&TokenPolicy{Secret: secret}, allowlist)
+
+	ctx := context.Background()
+	token := NewJoinToken(secret, time.Now().Add(time.Minute))
+	_, err = joiner.JoinNetwork(ctx, &pb.JoinRequest{Address: member.Meta.Address, Token: token})
+	if err == nil || !strings.Contains(err.Error(), codes.PermissionDenied.String()) {
+		t.Fatalf("expected a peer outside the allowlist to be refused, got %v", err)
+	}
+	if len(member.GetNetwork()) != 1 {
+		t.Fatalf("refused peer was added to the network: %v", member.GetNetwork())
+	}
+
+	allowlist, err = NewAllowlistPolicy([]string{"127.0.0.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	member.JoinPolicy = AllOf(&TokenPolicy{Secret: secret}, allowlist)
+	_, err = joiner.JoinNetwork(ctx, &pb.JoinRequest{Address: member.Meta.Address})
+	if err == nil || !strings.Contains(err.Error(), codes.PermissionDenied.String()) {
+		t.Fatalf("expected a peer without a token to be refused, got %v", err)
+	}
+
+	_, err = joiner.JoinNetwork(ctx, &pb.JoinRequest{Address: member.Meta.Address, Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(member.GetNetwork()) != 2 {
+		t.Fatalf("admitted peer was not added to the network: %v", member.GetNetwork())
+	}
+
+	// proposing the join to the coordinator directly does not bypass the policy
+	outsider := &pb.PeerInfo{Address: "localhost:8340"}
+	_, err = member.ProposeMembershipChange(ctx, &pb.MembershipChange{
+		Type:     pb.MembershipChange_JOIN,
+		Peers:    []*pb.PeerInfo{outsider},
+		Proposer: &joiner.Meta,
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a proposed join without a token to be refused, got %v", err)
+	}
+	if containsAddress(member.GetNetwork(), outsider.Address) {
+		t.Fatalf("refused peer was added to the network: %v", member.GetNetwork())
+	}
+}
+
+func TestMembershipChangesRequireMembers(t *testing.T) {
+	coordinator := pb.PeerInfo{Address: "localhost:8342", ID: "coordinator"}
+	member := pb.PeerInfo{Address: "localhost:8343", ID: "member"}
+	outsider := pb.PeerInfo{Address: "localhost:8340", ID: "outsider"}
+	lp := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        coordinator,
+		Network:     []pb.PeerInfo{coordinator, member},
+	}
+	ctx := context.Background()
+
+	_, err := lp.ProposeMembershipChange(ctx, &pb.MembershipChange{
+		Type:     pb.MembershipChange_EVICT,
+		Peers:    []*pb.PeerInfo{&member},
+		Proposer: &outsider,
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected an eviction proposed by an outsider to be refused, got %v", err)
+	}
+	_, err = lp.ProposeMembershipChange(ctx, &pb.MembershipChange{
+		Type:     pb.MembershipChange_LEAVE,
+		Peers:    []*pb.PeerInfo{&coordinator},
+		Proposer: &member,
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a member leaving on behalf of another to be refused, got %v", err)
+	}
+	if len(lp.GetNetwork()) != 2 {
+		t.Fatalf("refused changes modified the network: %v", lp.GetNetwork())
+	}
+
+	rawNetwork, err := json.Marshal([]pb.PeerInfo{outsider})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := &pb.Lightblock{ID: "network", Payload: rawNetwork, Type: pb.Lightblock_NETWORK, Proposer: &outsider}
+	if _, err := lp.NotifyNewBlock(ctx, block); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected a network block from an outsider to be refused, got %v", err)
+	}
+	if lp.GetState().ID != "" || len(lp.GetNetwork()) != 2 {
+		t.Fatalf("refused network block was applied")
+	}
+
+	block.Proposer = &member
+	if _, err := lp.NotifyNewBlock(ctx, block); err != nil {
+		t.Fatal(err)
+	}
+	if lp.GetState().ID != block.ID {
+		t.Fatalf("expected the network block of a member to be applied")
+	}
+}
+
+// gatedPolicy refuses every peer until it is opened, and then defers to the policy.
+type gatedPolicy struct {
+	open   int32
+	policy JoinPolicy
+}
+
+func (gp *gatedPolicy) Admit(ctx context.Context, req *pb.ConnectRequest) error {
+	if atomic.LoadInt32(&gp.open) == 0 {
+		return fmt.Errorf("not admitting peers yet")
+	}
+	return gp.policy.Admit(ctx, req)
+}
+
+func TestEvictedPeerRejoinsTheNetwork(t *testing.T) {
+	network := []pb.PeerInfo{}
+	for _, port := range []int{8319, 8320, 8321} {
+		network = append(network, pb.PeerInfo{Address: fmt.Sprintf("localhost:%d", port)})
+	}
+
+	secret := []byte("secret")
+	policy := &gatedPolicy{policy: &TokenPolicy{Secret: secret}}
+	peers := []*Lightpeer{}
+	for _, info := range network {
+		lp := &Lightpeer{
+			StoragePath:   t.TempDir(),
+			Tracer:        global.Tracer("test"),
+			Meta:          info,
+			Network:       network,
+			ProbeInterval: 20 * time.Millisecond,
+			JoinPolicy:    policy,
+			JoinToken:     NewJoinToken(secret, time.Now().Add(time.Minute)),
+		}
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+	coordinator, evicted := peers[0], peers[2]
+
+	ctx := context.Background()
+	_, err := coordinator.applyMembershipChange(ctx, &pb.MembershipChange{
+		Type:  pb.MembershipChange_EVICT,
+		Peers: []*pb.PeerInfo{&evicted.Meta},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nhc := &NetworkHealthChecker{Lp: evicted}
+	nhc.StartPeerHealthCheck(ctx)
+	defer nhc.StopPeerHealthCheck()
+
+	for start := time.Now(); !evicted.members().excluded(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("evicted peer did not notice it was removed from the network")
+		}
+	}
+	_, err = evicted.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected the evicted peer to refuse writes, got %v", err)
+	}
+
+	atomic.StoreInt32(&policy.open, 1)
+	for start := time.Now(); evicted.members().excluded() || len(coordinator.GetNetwork()) != 3; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("evicted peer did not rejoin the network, network is %v", coordinator.GetNetwork())
+		}
+	}
+
+	resp, err := evicted.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coordinator.GetState().ID != resp.BlockID {
+		t.Fatalf("block of the rejoined peer was not replicated")
+	}
+}
+
+type fakeResolver struct {
+	srv   map[string][]*net.SRV
+	hosts map[string][]string
+}
+
+func (fr *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if records, ok := fr.srv[name]; ok {
+		return name, records, nil
+	}
+	return "", nil, fmt.Errorf("no SRV records for %s", name)
+}
+
+func (fr *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if hosts, ok := fr.hosts[host]; ok {
+		return hosts, nil
+	}
+	return nil, fmt.Errorf("no such host %s", host)
+}
+
+func TestDNSDiscoverer(t *testing.T) {
+	resolver := &fakeResolver{
+		srv: map[string][]*net.SRV{
+			"_grpc._tcp.lightchain": {{Target: "lightchain-0.lightchain.", Port: 9081}},
+		},
+		hosts: map[string][]string{
+			"lightchain": {"10.0.0.1", "fd00::1"},
+		},
+	}
+
+	ctx := context.Background()
+	addresses, err := (&DNSDiscoverer{Name: "_grpc._tcp.lightchain", Resolver: resolver}).Discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 1 || addresses[0] != "lightchain-0.lightchain:9081" {
+		t.Fatalf("wrong SRV addresses: %v", addresses)
+	}
+
+	addresses, err = (&DNSDiscoverer{Name: "lightchain", Port: 9081, Resolver: resolver}).Discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 2 || addresses[0] != "10.0.0.1:9081" || addresses[1] != "[fd00::1]:9081" {
+		t.Fatalf("wrong host addresses: %v", addresses)
+	}
+
+	_, err = (&DNSDiscoverer{Name: "unknown", Resolver: resolver}).Discover(ctx)
+	if err == nil {
+		t.Fatal("expected unknown names to fail")
+	}
+}
+
+func TestLonePeersJoinThroughDiscovery(t *testing.T) {
+	seeds := StaticSeeds{"localhost:8322", "localhost:8323"}
+	peers := []*Lightpeer{}
+	for _, address := range seeds {
+		info := pb.PeerInfo{Address: address}
+		lp := &Lightpeer{
+			StoragePath: t.TempDir(),
+			Tracer:      global.Tracer("test"),
+			Meta:        info,
+			Network:     []pb.PeerInfo{info},
+		}
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, lp := range peers {
+		go lp.AutoJoin(ctx, seeds, 20*time.Millisecond)
+	}
+
+	for start := time.Now(); len(peers[0].GetNetwork()) != 2 || len(peers[1].GetNetwork()) != 2; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("peers did not join, networks are %v and %v", peers[0].GetNetwork(), peers[1].GetNetwork())
+		}
+	}
+	if peers[0].GetState().ID != peers[1].GetState().ID {
+		t.Fatal("peers joined different networks")
+	}
+}
+
+func TestAdvertiseAddress(t *testing.T) {
+	for _, tc := range []struct {
+		advertise, iface, bindHost string
+		expected                   string
+	}{
+		{"10.0.0.1", "", "", "10.0.0.1:9081"},
+		{"10.0.0.1:7000", "", "", "10.0.0.1:7000"},
+		{"fd00::1", "", "", "[fd00::1]:9081"},
+		{"[fd00::1]", "", "", "[fd00::1]:9081"},
+		{"[fd00::1]:7000", "", "", "[fd00::1]:7000"},
+		{"lightchain-0.lightchain", "", "", "lightchain-0.lightchain:9081"},
+		{"", "lo", "", "127.0.0.1:9081"},
+		{"", "", "::1", "[::1]:9081"},
+		{"10.0.0.1", "", "::1", "10.0.0.1:9081"},
+	} {
+		address, err := AdvertiseAddress(tc.advertise, tc.iface, tc.bindHost, 9081)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address != tc.expected {
+			t.Fatalf("expected %s for %v, got %s", tc.expected, tc, address)
+		}
+	}
+
+	if _, err := AdvertiseAddress("", "no-such-interface", "", 9081); err == nil {
+		t.Fatal("expected unknown interfaces to fail")
+	}
+	if address, err := AdvertiseAddress("", "", "0.0.0.0", 9081); err == nil && strings.HasPrefix(address, "0.0.0.0") {
+		t.Fatalf("unspecified bind address was advertised: %s", address)
+	}
+}
+
+func TestMinorityTurnsReadOnlyUntilThePartitionHeals(t *testing.T) {
+	network := []pb.PeerInfo{}
+	for _, port := range []int{8324, 8325, 8326} {
+		network = append(network, pb.PeerInfo{Address: fmt.Sprintf("localhost:%d", port)})
+	}
+	minorityInfo := network[0]
+
+	// the partition separates the first peer from the others
+	var partitioned int32
+	partitionDialer := func(self pb.PeerInfo) grpc.DialOption {
+		return grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			isolated := self.Address == minorityInfo.Address || address == minorityInfo.Address
+			if atomic.LoadInt32(&partitioned) == 1 && isolated {
+				return nil, fmt.Errorf("%s is partitioned from %s", self.Address, address)
+			}
+			return (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		})
+	}
+
+	peers := []*Lightpeer{}
+	for _, info := range network {
+		lp := &Lightpeer{
+			StoragePath:      t.TempDir(),
+			Tracer:           global.Tracer("test"),
+			Meta:             info,
+			Network:          network,
+			DialOptions:      []grpc.DialOption{partitionDialer(info)},
+			ProbeInterval:    20 * time.Millisecond,
+			ProbeTimeout:     50 * time.Millisecond,
+			SuspicionTimeout: 200 * time.Millisecond,
+			// members catch up without being admitted again
+			JoinPolicy: &TokenPolicy{Secret: []byte("secret")},
+		}
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+	minority, majority := peers[0], peers[1]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := majority.updateNetwork(ctx, network); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&partitioned, 1)
+	nhc := &NetworkHealthChecker{Lp: minority}
+	nhc.StartPeerHealthCheck(ctx)
+
+	writesStatus := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := minority.Check(ctx, &healthpb.HealthCheckRequest{Service: WritesService})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+	for start := time.Now(); writesStatus() != healthpb.HealthCheckResponse_NOT_SERVING; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("peer reaching a minority of the network did not turn read-only")
+		}
+	}
+	_, err := minority.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected the minority to refuse writes, got %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if len(minority.GetNetwork()) != 3 {
+		t.Fatalf("minority evicted the majority, network is %v", minority.GetNetwork())
+	}
+
+	resp, err := majority.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")})
+	if err != nil {
+		t.Fatalf("majority refused writes during the partition: %v", err)
+	}
+
+	atomic.StoreInt32(&partitioned, 0)
+	for start := time.Now(); writesStatus() != healthpb.HealthCheckResponse_SERVING; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("peer did not accept writes after the partition healed")
+		}
+	}
+	if _, err := minority.readBlock(resp.BlockID); err != nil {
+		t.Fatalf("peer did not catch up with the majority before accepting writes: %v", err)
+	}
+	if _, err := minority.Persist(ctx, &pb.PersistRequest{Payload: []byte("Hello")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMerkleRootsComparePrefixes(t *testing.T) {
+	mt := &merkleTree{nodes: map[merkleNode][]byte{}}
+	other := &merkleTree{nodes: map[merkleNode][]byte{}}
+	for i := 0; i < 13; i++ {
+		mt.ids = append(mt.ids, fmt.Sprintf("block-%d", i))
+		other.ids = append(other.ids, fmt.Sprintf("block-%d", i))
+	}
+	other.ids[9] = "fork"
+
+	for height := uint64(1); height <= 13; height++ {
+		root, _ := mt.root(1, height)
+		otherRoot, _ := other.root(1, height)
+		if equal := string(root) == string(otherRoot); equal != (height < 10) {
+			t.Fatalf("expected the roots up to height %d to be equal: %v", height, height < 10)
+		}
+	}
+
+	// dropping the fork drops the cached nodes covering it
+	other.truncate(9)
+	other.ids = append(other.ids, mt.ids[9:]...)
+	root, height := mt.root(1, 0)
+	otherRoot, _ := other.root(1, 0)
+	if string(root) != string(otherRoot) || height != 13 {
+		t.Fatalf("roots differ after replacing the fork")
+	}
+}
+
+func TestAntiEntropyRepairsMissingAndForkedBlocks(t *testing.T) {
+	aheadInfo := pb.PeerInfo{Address: "localhost:8327"}
+	behindInfo := pb.PeerInfo{Address: "localhost:8328"}
+	ahead := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        aheadInfo,
+		Network:     []pb.PeerInfo{aheadInfo, behindInfo},
+	}
+	behind := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        behindInfo,
+		Network:     []pb.PeerInfo{behindInfo},
+	}
+	for _, lp := range []*Lightpeer{ahead, behind} {
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+	}
+
+	ctx := context.Background()
+	persist := func(lp *Lightpeer, payload string) {
+		if _, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte(payload)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		persist(ahead, fmt.Sprintf("shared %d", i))
+	}
+	// the peers lose touch, such that one misses blocks and the other forks
+	ahead.setNetwork([]pb.PeerInfo{aheadInfo})
+	for i := 0; i < 4; i++ {
+		persist(ahead, fmt.Sprintf("missed %d", i))
+	}
+	persist(behind, "fork")
+
+	repaired, err := behind.syncChain(ctx, aheadInfo.Address, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repaired != 4 || behind.GetState().ID != ahead.GetState().ID {
+		t.Fatalf("expected the fork to be replaced by the 4 missed blocks, repaired %d", repaired)
+	}
+
+	aheadRoot, err := ahead.GetMerkleRoot(ctx, &pb.MerkleRootRequest{From: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	behindRoot, err := behind.GetMerkleRoot(ctx, &pb.MerkleRootRequest{From: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(aheadRoot.Root) != string(behindRoot.Root) || behindRoot.Height != 9 {
+		t.Fatalf("chains did not converge")
+	}
+
+	if repaired, err := ahead.syncChain(ctx, behindInfo.Address, false); err != nil || repaired != 0 {
+		t.Fatalf("expected nothing to repair on converged chains, repaired %d: %v", repaired, err)
+	}
+}
+
+func TestAdoptedBlocksAreVerified(t *testing.T) {
+	info := pb.PeerInfo{Address: "localhost:8347"}
+	lp := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        info,
+		Network:     []pb.PeerInfo{info},
+	}
+	ctx := context.Background()
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		resp, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte(fmt.Sprintf("block %d", i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.BlockID)
+	}
+
+	// the replacement of the last two blocks must continue the chain at height 2
+	skipping := []pb.Lightblock{{ID: "replacement", PrevID: ids[0], Height: 3, Type: pb.Lightblock_CLIENT}}
+	if _, err := lp.adoptBlocks(ids[2], ids[0], 1, skipping); err == nil {
+		t.Fatalf("expected a block skipping a height to be refused")
+	}
+	if lp.GetState().ID != ids[2] {
+		t.Fatalf("refused blocks replaced the chain")
+	}
+
+	replacement := []pb.Lightblock{{ID: "replacement", PrevID: ids[0], Height: 2, Type: pb.Lightblock_CLIENT}}
+	discarded, err := lp.adoptBlocks(ids[2], ids[0], 1, replacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lp.GetState().ID != "replacement" || len(discarded) != 2 || discarded[0] != ids[2] || discarded[1] != ids[1] {
+		t.Fatalf("expected the fork %v to be discarded, got %v", ids[1:], discarded)
+	}
+
+	// the index file is rewritten without the entries of the discarded blocks
+	if block, err := lp.GetBlockByHeight(ctx, &pb.BlockByHeightRequest{Height: 2}); err != nil || block.ID != "replacement" {
+		t.Fatalf("expected the replacement block at height 2, got %v: %v", block, err)
+	}
+	rawIndex, err := ioutil.ReadFile(path.Join(lp.StoragePath, indexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rawIndex) != fmt.Sprintf("1 %s\n2 replacement\n", ids[0]) {
+		t.Fatalf("expected the index to hold the replaced chain, got %q", rawIndex)
+	}
+}
+
+func TestDivergedPeerResyncsFromTheMajority(t *testing.T) {
+	network := []pb.PeerInfo{}
+	for _, port := range []int{8329, 8330, 8331} {
+		network = append(network, pb.PeerInfo{Address: fmt.Sprintf("localhost:%d", port)})
+	}
+	peers := []*Lightpeer{}
+	for _, info := range network {
+		lp := &Lightpeer{
+			StoragePath: t.TempDir(),
+			Tracer:      global.Tracer("test"),
+			Meta:        info,
+			Network:     network,
+		}
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+	forked, majority := peers[0], peers[1]
+
+	ctx := context.Background()
+	persist := func(lp *Lightpeer, payload string, recipients []pb.PeerInfo) {
+		lp.setNetwork(recipients)
+		defer func() { lp.setNetwork(network) }()
+		if _, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte(payload)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	persist(forked, "shared", network)
+	persist(forked, "fork", network[:1])
+	persist(majority, "majority", network[1:])
+	// the members of the majority agree on their chain, while one of them lags behind
+	persist(majority, "ahead", network[1:2])
+
+	consistencyStatus := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := forked.Check(ctx, &healthpb.HealthCheckRequest{Service: ConsistencyService})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+	if status := consistencyStatus(); status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected a consistent state before the first check, got %v", status)
+	}
+	if diverged := forked.checkConsistency(ctx); len(diverged) != 2 {
+		t.Fatalf("expected the fork to diverge from both members, got %v", diverged)
+	}
+	if diverged := majority.checkConsistency(ctx); len(diverged) != 1 || diverged[0] != forked.Meta.Address {
+		t.Fatalf("expected the majority to diverge from the fork only, got %v", diverged)
+	}
+	if status := consistencyStatus(); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected the diverged peer to report it, got %v", status)
+	}
+
+	forked.AutoResync = true
+	forked.checkConsistency(ctx)
+	if forked.GetState().ID != majority.GetState().ID {
+		t.Fatalf("diverged peer did not resync from the majority")
+	}
+	if diverged := forked.checkConsistency(ctx); len(diverged) != 0 {
+		t.Fatalf("expected the resynced peer to be consistent, got %v", diverged)
+	}
+	if status := consistencyStatus(); status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected the resynced peer to be consistent, got %v", status)
+	}
+}
+
+func TestDamagedBlocksAreRepairedFromMembers(t *testing.T) {
+	network := []pb.PeerInfo{{Address: "localhost:8332"}, {Address: "localhost:8333"}}
+	peers := []*Lightpeer{}
+	for _, info := range network {
+		lp := &Lightpeer{
+			StoragePath: t.TempDir(),
+			Tracer:      global.Tracer("test"),
+			Meta:        info,
+			Network:     network,
+		}
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+		peers = append(peers, lp)
+	}
+	healthy, damaged := peers[0], peers[1]
+
+	ctx := context.Background()
+	if _, err := healthy.updateNetwork(ctx, network); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for i := 0; i < 4; i++ {
+		resp, err := healthy.Persist(ctx, &pb.PersistRequest{Payload: []byte(fmt.Sprintf("block %d", i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.BlockID)
+	}
+	if damaged.GetState().ID != ids[3] {
+		t.Fatalf("expected the block to be replicated")
+	}
+
+	if err := os.Remove(path.Join(damaged.StoragePath, ids[1])); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(damaged.StoragePath, ids[0]), []byte(`{"ID":`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	read := 0
+	for resp := range damaged.readBlocks() {
+		if resp.err != nil {
+			t.Fatalf("expected damaged blocks to be repaired: %v", resp.err)
+		}
+		read++
+	}
+	if read != 5 {
+		t.Fatalf("expected 5 blocks, got %d", read)
+	}
+	for _, id := range ids[:2] {
+		if _, err := damaged.readBlock(id); err != nil {
+			t.Fatalf("expected block %s to be rewritten: %v", id, err)
+		}
+	}
+
+	// a restarted peer restores its chain and its network, repairing the blocks lost meanwhile
+	if err := os.Remove(path.Join(damaged.StoragePath, ids[2])); err != nil {
+		t.Fatal(err)
+	}
+	restarted := &Lightpeer{
+		StoragePath: damaged.StoragePath,
+		Tracer:      global.Tracer("test"),
+		Meta:        damaged.Meta,
+		Network:     []pb.PeerInfo{damaged.Meta},
+	}
+	if err := restarted.RestoreChain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if restarted.GetState().ID != ids[3] || len(restarted.GetNetwork()) != 2 {
+		t.Fatalf("expected the chain to be restored, got head %s and network %v", restarted.GetState().ID, restarted.GetNetwork())
+	}
+	if _, err := restarted.readBlock(ids[2]); err != nil {
+		t.Fatalf("expected block %s to be rewritten: %v", ids[2], err)
+	}
+}
+
+func TestBlockIndexFindsBlocksByHeight(t *testing.T) {
+	selfInfo := pb.PeerInfo{Address: "localhost:8334"}
+	lp := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        selfInfo,
+		Network:     []pb.PeerInfo{selfInfo},
+	}
+
+	ctx := context.Background()
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		resp, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte(fmt.Sprintf("block %d", i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.BlockID)
+	}
+
+	block, err := lp.GetBlockByHeight(ctx, &pb.BlockByHeightRequest{Height: 2})
+	if err != nil || block.ID != ids[1] {
+		t.Fatalf("expected block %s at height 2, got %v: %v", ids[1], block, err)
+	}
+	if _, err := lp.GetBlockByHeight(ctx, &pb.BlockByHeightRequest{Height: 4}); status.Code(err) != codes.NotFound {
+		t.Fatalf("expected no block above the head, got %v", err)
+	}
+
+	// the index is kept on disk, also by lookups of a restarted peer which did not restore its chain
+	restarted := &Lightpeer{StoragePath: lp.StoragePath, Tracer: global.Tracer("test"), Meta: selfInfo}
+	if _, err := restarted.GetBlockByHeight(ctx, &pb.BlockByHeightRequest{Height: 1}); err != nil {
+		t.Fatal(err)
+	}
+	idx := loadIndex(path.Join(lp.StoragePath, indexFile))
+	if height, ok := idx.heightOf(ids[2]); !ok || height != 3 {
+		t.Fatalf("expected the index to be persisted, got height %d", height)
+	}
+
+	// entries replace the entries above them
+	idx.truncate(2)
+	if err := idx.save(2); err != nil {
+		t.Fatal(err)
+	}
+	idx = loadIndex(path.Join(lp.StoragePath, indexFile))
+	if idx.height() != 2 || idx.blockID(2) != ids[1] {
+		t.Fatalf("expected the entry at height 2 to drop the entries above it, got height %d", idx.height())
+	}
+	// the replaced entries are compacted away
+	rawIndex, err := ioutil.ReadFile(path.Join(lp.StoragePath, indexFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(rawIndex), "\n"); lines != 2 {
+		t.Fatalf("expected the index file to hold the 2 entries of the chain, got %d lines", lines)
+	}
+}
+
+func TestCheckRepoFindsForksAndOrphans(t *testing.T) {
+	selfInfo := pb.PeerInfo{Address: "localhost:8335"}
+	lp := &Lightpeer{
+		StoragePath: t.TempDir(),
+		Tracer:      global.Tracer("test"),
+		Meta:        selfInfo,
+		Network:     []pb.PeerInfo{selfInfo},
+	}
+
+	ctx := context.Background()
+	if _, err := lp.updateNetwork(ctx, lp.GetNetwork()); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		resp, err := lp.Persist(ctx, &pb.PersistRequest{Payload: []byte(fmt.Sprintf("block %d", i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.BlockID)
+	}
+	for _, block := range []pb.Lightblock{{ID: "fork", PrevID: ids[1]}, {ID: "orphan", PrevID: "missing"}} {
+		if err := lp.writeBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(lp.StoragePath, "corrupt"), []byte("{"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := CheckRepo(lp.StoragePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Head != ids[2] || report.Height != 4 || report.Blocks != 6 {
+		t.Fatalf("expected the saved chain of height 4, got head %s at height %d", report.Head, report.Height)
+	}
+	if len(report.Forks) != 1 || report.Forks[0].Parent != ids[1] || len(report.Orphans) != 1 || report.Orphans[0] != "orphan" {
+		t.Fatalf("expected a fork and an orphan, got %v and %v", report.Forks, report.Orphans)
+	}
+	if len(report.Corrupt) != 1 || len(report.NetworkHistory) != 1 || report.Healthy() {
+		t.Fatalf("expected the corrupt file and the network history to be reported")
+	}
+	if _, err := RemoveUnreachable(lp.StoragePath, report); err == nil {
+		t.Fatalf("expected unhealthy repos to be left untouched")
+	}
+
+	// without a saved head, the longest chain is checked
+	for _, file := range []string{"corrupt", headFile} {
+		if err := os.Remove(path.Join(lp.StoragePath, file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err = CheckRepo(lp.StoragePath, "")
+	if err != nil || report.Head != ids[2] || !report.Healthy() {
+		t.Fatalf("expected the longest chain to be healthy, got head %s: %v", report.Head, err)
+	}
+	removed, err := RemoveUnreachable(lp.StoragePath, report)
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("expected the fork and the orphan to be removed, removed %v: %v", removed, err)
+	}
+}
+
+func TestChainArchivesRoundTrip(t *testing.T) {
+	newPeer := func(address string) *Lightpeer {
+		info := pb.PeerInfo{Address: address}
+		return &Lightpeer{
+			StoragePath: t.TempDir(),
+			Tracer:      global.Tracer("test"),
+			Meta:        info,
+			Network:     []pb.PeerInfo{info},
+		}
+	}
+	source := newPeer("localhost:8336")
+	ctx := context.Background()
+	if _, err := source.updateNetwork(ctx, source.GetNetwork()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := source.Persist(ctx, &pb.PersistRequest{Payload: []byte(fmt.Sprintf("block %d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	full := &bytes.Buffer{}
+	if _, err := source.ExportChain(full, 0, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	restored := newPeer("localhost:8337")
+	if _, imported, err := restored.ImportChain(bytes.NewReader(full.Bytes())); err != nil || imported != 4 {
+		t.Fatalf("expected 4 imported blocks, got %d: %v", imported, err)
+	}
+	if restored.GetState().ID != source.GetState().ID || restored.GetNetwork()[0].Address != "localhost:8336" {
+		t.Fatalf("expected the chain and its network to be imported")
+	}
+
+	// archives of a height range extend the chain they link to
+	extended := newPeer("localhost:8338")
+	for _, heights := range [][2]uint64{{1, 2}, {2, 4}} {
+		archive := &bytes.Buffer{}
+		if _, err := source.ExportChain(archive, heights[0], heights[1], false); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := extended.ImportChain(archive); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if extended.GetState().ID != source.GetState().ID {
+		t.Fatalf("expected the ranges to rebuild the chain")
+	}
+
+	tail := &bytes.Buffer{}
+	if _, err := source.ExportChain(tail, 3, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := newPeer("localhost:8339").ImportChain(bytes.NewReader(tail.Bytes())); err == nil {
+		t.Fatalf("expected archives not linking to the chain to be refused")
+	}
+	corrupt := bytes.Replace(tail.Bytes(), []byte(`"Created":"2`), []byte(`"Created":"3`), 1)
+	if _, _, err := extended.ImportChain(bytes.NewReader(corrupt)); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("expected the checksum to catch the corruption, got %v", err)
+	}
+
+	// through the RPC, only admitted clients seed peers without a chain
+	secret := []byte("secret")
+	seeded := newPeer("localhost:8341")
+	seeded.JoinPolicy = &TokenPolicy{Secret: secret}
+	restored.JoinPolicy = seeded.JoinPolicy
+	for _, lp := range []*Lightpeer{seeded, restored} {
+		stop, err := serveTestPeer(lp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stop()
+	}
+	importArchive := func(lp *Lightpeer, token string) (*pb.ImportResponse, error) {
+		conn, err := source.dial(lp.Meta.Address)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		stream, err := pb.NewLightpeerClient(conn).Import(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := stream.Send(&pb.ArchiveChunk{Data: full.Bytes(), Token: token}); err != nil {
+			return nil, err
+		}
+		return stream.CloseAndRecv()
+	}
+
+	token := NewJoinToken(secret, time.Now().Add(time.Minute))
+	if _, err := importArchive(seeded, ""); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected an import without a token to be refused, got %v", err)
+	}
+	if _, err := importArchive(restored, token); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected a peer holding a chain to refuse imports, got %v", err)
+	}
+	resp, err := importArchive(seeded, token)
+	if err != nil || resp.Imported != 4 || resp.Head != source.GetState().ID {
+		t.Fatalf("expected the archive to seed the peer, got %v: %v", resp, err)
+	}
 }
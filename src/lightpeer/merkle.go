@@ -0,0 +1,151 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+)
+
+// The Merkle tree is built over the IDs of the blocks of the chain, ordered by height. Its nodes cover
+// aligned ranges of heights whose size is a power of two, and the root of any range of heights combines the
+// largest aligned nodes covering it, from left to right. Complete nodes never change while the chain only
+// grows, so they are cached, and only the nodes above a fork are dropped when the chain is replaced. The IDs
+// are taken from the block index.
+
+type merkleTree struct {
+	mu sync.Mutex
+	// ids holds the block IDs by height, the block at height 1 first
+	ids   []string
+	nodes map[merkleNode][]byte
+}
+
+// merkleNode covers the heights from index*2^level+1 to (index+1)*2^level.
+type merkleNode struct {
+	level uint
+	index uint64
+}
+
+func (lp *Lightpeer) merkle() *merkleTree {
+	lp.merkleOnce.Do(func() {
+		lp.merkleTree = &merkleTree{nodes: map[merkleNode][]byte{}}
+	})
+	return lp.merkleTree
+}
+
+// syncMerkleTree updates the Merkle tree to the current head of the chain, as recorded by the block index.
+func (lp *Lightpeer) syncMerkleTree() (*merkleTree, error) {
+	idx, err := lp.syncIndex()
+	if err != nil {
+		return nil, fmt.Errorf("could not index the chain: %v", err)
+	}
+	mt := lp.merkle()
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	height, missing := idx.since(mt.ids)
+	mt.truncate(height)
+	mt.ids = append(mt.ids, missing...)
+	return mt, nil
+}
+
+// truncate drops the blocks above the height, together with the nodes covering them. Must be called holding the lock.
+func (mt *merkleTree) truncate(height uint64) {
+	if height >= uint64(len(mt.ids)) {
+		return
+	}
+	mt.ids = mt.ids[:height]
+	for node := range mt.nodes {
+		if (node.index+1)<<node.level > height {
+			delete(mt.nodes, node)
+		}
+	}
+}
+
+func (mt *merkleTree) height() uint64 {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	return uint64(len(mt.ids))
+}
+
+// blockID returns the ID of the block at the height, or an empty ID for heights outside of the chain.
+func (mt *merkleTree) blockID(height uint64) string {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	if height == 0 || height > uint64(len(mt.ids)) {
+		return ""
+	}
+	return mt.ids[height-1]
+}
+
+// root returns the root of the heights between from and to, both included, and the height of the chain.
+// The range is capped at the height of the chain, and empty ranges have no root.
+func (mt *merkleTree) root(from, to uint64) ([]byte, uint64) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	from, to = mt.clamp(from, to)
+
+	var root []byte
+	for start := from - 1; start < to; {
+		level := uint(0)
+		for start%(2<<level) == 0 && start+(2<<level) <= to {
+			level++
+		}
+		hash := mt.node(level, start>>level)
+		if root == nil {
+			root = hash
+		} else {
+			root = hashNodes(root, hash)
+		}
+		start += 1 << level
+	}
+	return root, uint64(len(mt.ids))
+}
+
+// clamp restricts the heights to the chain. Must be called holding the lock.
+func (mt *merkleTree) clamp(from, to uint64) (uint64, uint64) {
+	if from == 0 {
+		from = 1
+	}
+	if to == 0 || to > uint64(len(mt.ids)) {
+		to = uint64(len(mt.ids))
+	}
+	return from, to
+}
+
+// node returns the hash of the node, computing the missing hashes below it. Must be called holding the lock.
+func (mt *merkleTree) node(level uint, index uint64) []byte {
+	if level == 0 {
+		leaf := sha256.Sum256(append([]byte{0}, mt.ids[index]...))
+		return leaf[:]
+	}
+
+	key := merkleNode{level, index}
+	if hash, ok := mt.nodes[key]; ok {
+		return hash
+	}
+	hash := hashNodes(mt.node(level-1, 2*index), mt.node(level-1, 2*index+1))
+	mt.nodes[key] = hash
+	return hash
+}
+
+func hashNodes(left, right []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{1})
+	hash.Write(left)
+	hash.Write(right)
+	return hash.Sum(nil)
+}
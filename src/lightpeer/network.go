@@ -19,5 +19,5 @@ import (
 )
 
 type LightNetwork struct {
-	Peers []pb.PeerInfo `json:peers`
+	Peers []pb.PeerInfo `json:"peers"`
 }
@@ -0,0 +1,303 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/metric"
+	"go.opentelemetry.io/otel/label"
+)
+
+const (
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultMaxRetryBackoff = 10 * time.Second
+
+	outboxFilePrefix = ".outbox-"
+)
+
+// The outbox is a durable queue of the blocks which could not be delivered to a peer (hinted handoff).
+// Blocks are retried in order with exponential backoff, until the peer acknowledges them or it is removed
+// from the network. A peer refusing a block would refuse the blocks linking to it too, so the whole queue is
+// dropped and the peer catches up by pulling the chain. Only the block IDs are queued, the blocks themselves are read from the block storage.
+type outbox struct {
+	lp      *Lightpeer
+	address string
+
+	mu       sync.Mutex
+	blockIDs []string
+	running  bool
+	// dropped outboxes are no longer used, blocks for the peer go to a new outbox
+	dropped bool
+}
+
+// outbox returns the outbound queue for the peer, loading the blocks queued before a restart.
+func (lp *Lightpeer) outbox(address string) *outbox {
+	lp.outboxesLock.Lock()
+	defer lp.outboxesLock.Unlock()
+
+	if ob, ok := lp.outboxes[address]; ok {
+		return ob
+	}
+
+	if lp.outboxes == nil {
+		lp.outboxes = map[string]*outbox{}
+		lp.registerOutboxMetrics()
+	}
+
+	ob := &outbox{lp: lp, address: address}
+	ob.load()
+	lp.outboxes[address] = ob
+	if len(ob.blockIDs) > 0 {
+		ob.running = true
+		go ob.deliver()
+	}
+	return ob
+}
+
+// enqueue queues the block for the peer, in the current outbox of the peer.
+func (lp *Lightpeer) enqueue(address, blockID string) {
+	for !lp.outbox(address).enqueue(blockID) {
+	}
+}
+
+// resumeOutboxes starts delivering the queues saved before a restart. The queues of peers which are no
+// longer part of the network are removed, as they would be dropped anyway.
+func (lp *Lightpeer) resumeOutboxes() {
+	files, err := ioutil.ReadDir(lp.StoragePath)
+	if err != nil {
+		log.Printf("could not look for outbound queues: %v", err)
+		return
+	}
+
+	members := map[string]string{}
+	for _, peer := range lp.GetNetwork() {
+		if peer.Address != lp.Meta.Address {
+			members[outboxFileName(peer.Address)] = peer.Address
+		}
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() || !strings.HasPrefix(f.Name(), outboxFilePrefix) {
+			continue
+		}
+		if address, ok := members[f.Name()]; ok {
+			lp.outbox(address)
+		} else {
+			os.Remove(path.Join(lp.StoragePath, f.Name()))
+		}
+	}
+}
+
+// OutboundQueueDepth returns the number of blocks waiting to be delivered to each peer.
+func (lp *Lightpeer) OutboundQueueDepth() map[string]int {
+	lp.outboxesLock.Lock()
+	defer lp.outboxesLock.Unlock()
+
+	depths := map[string]int{}
+	for address, ob := range lp.outboxes {
+		depths[address] = ob.depth()
+	}
+	return depths
+}
+
+// drainOutboxes waits until all the queued blocks were delivered.
+func (lp *Lightpeer) drainOutboxes(ctx context.Context) error {
+	for {
+		queued := 0
+		for _, depth := range lp.OutboundQueueDepth() {
+			queued += depth
+		}
+		if queued == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d blocks were not delivered: %v", queued, ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (lp *Lightpeer) registerOutboxMetrics() {
+	meter := global.Meter(ServiceName)
+	_, err := meter.NewInt64ValueObserver("lightchain.outbound.queue.depth",
+		func(_ context.Context, result metric.Int64ObserverResult) {
+			for address, depth := range lp.OutboundQueueDepth() {
+				result.Observe(int64(depth),
+					label.String("peer", lp.Meta.Address),
+					label.String("target", address))
+			}
+		},
+		metric.WithDescription("number of blocks waiting to be delivered to a peer"))
+	if err != nil {
+		log.Printf("could not register the outbound queue metrics: %v", err)
+	}
+}
+
+func (ob *outbox) depth() int {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return len(ob.blockIDs)
+}
+
+// enqueue adds the block at the end of the queue, and starts delivering the queue if needed. It returns
+// false if the outbox was dropped in the meantime.
+func (ob *outbox) enqueue(blockID string) bool {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if ob.dropped {
+		return false
+	}
+	ob.blockIDs = append(ob.blockIDs, blockID)
+	ob.save()
+	if !ob.running {
+		ob.running = true
+		go ob.deliver()
+	}
+	return true
+}
+
+func (ob *outbox) head() (string, bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	if len(ob.blockIDs) == 0 {
+		ob.running = false
+		return "", false
+	}
+	return ob.blockIDs[0], true
+}
+
+func (ob *outbox) pop() {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	if len(ob.blockIDs) > 0 {
+		ob.blockIDs = ob.blockIDs[1:]
+	}
+	ob.save()
+}
+
+// drop discards the queue of a peer which is no longer part of the network, or which refused a block.
+func (ob *outbox) drop() {
+	ob.lp.outboxesLock.Lock()
+	if ob.lp.outboxes[ob.address] == ob {
+		delete(ob.lp.outboxes, ob.address)
+	}
+	ob.lp.outboxesLock.Unlock()
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.blockIDs = nil
+	ob.running = false
+	ob.dropped = true
+	os.Remove(ob.filePath())
+}
+
+func (ob *outbox) deliver() {
+	lp := ob.lp
+	backoff := lp.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	maxBackoff := lp.MaxRetryBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxRetryBackoff
+	}
+
+	delay := backoff
+	for {
+		if !containsAddress(lp.GetNetwork(), ob.address) {
+			ob.drop()
+			return
+		}
+
+		blockID, ok := ob.head()
+		if !ok {
+			return
+		}
+
+		ctx, span := lp.Tracer.Start(context.Background(), fmt.Sprintf("@%s - retry block %s for %s", lp.Meta.Address, blockID, ob.address))
+		block, err := lp.readBlock(blockID)
+		if err == nil {
+			err = lp.replicate(ctx, ob.address, block)
+		}
+
+		switch {
+		case err == nil:
+			ob.pop()
+			delay = backoff
+		case isRejection(err):
+			// the peer refuses the block, so retrying will not help, and the later blocks link to it. The
+			// queue is dropped, and the peer catches up by pulling the chain.
+			span.RecordError(ctx, err)
+			ob.drop()
+			span.End()
+			return
+		default:
+			span.AddEvent(ctx, fmt.Sprintf("could not deliver block, retrying in %v: %v", delay, err))
+			time.Sleep(delay)
+			delay *= 2
+			if delay > maxBackoff {
+				delay = maxBackoff
+			}
+		}
+		span.End()
+	}
+}
+
+func outboxFileName(address string) string {
+	return outboxFilePrefix + strings.NewReplacer(":", "_", "/", "_").Replace(address)
+}
+
+func (ob *outbox) filePath() string {
+	return path.Join(ob.lp.StoragePath, outboxFileName(ob.address))
+}
+
+func (ob *outbox) load() {
+	rawQueue, err := ioutil.ReadFile(ob.filePath())
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(rawQueue, &ob.blockIDs); err != nil {
+		log.Printf("could not load the outbound queue for %s: %v", ob.address, err)
+	}
+}
+
+// save persists the queue, such that undelivered blocks survive a restart. Must be called holding the lock.
+func (ob *outbox) save() {
+	if len(ob.blockIDs) == 0 {
+		os.Remove(ob.filePath())
+		return
+	}
+
+	rawQueue, err := json.Marshal(ob.blockIDs)
+	if err == nil {
+		err = ioutil.WriteFile(ob.filePath(), rawQueue, 0666)
+	}
+	if err != nil {
+		log.Printf("could not save the outbound queue for %s: %v", ob.address, err)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.opentelemetry.io/otel/api/trace"
+)
+
+// WritesService is the health service telling whether the peer accepts writes.
+const WritesService = "lightchain.writes"
+
+// A network partition splits the peers into groups which cannot reach each other. If every group evicted
+// the others and kept writing, the groups would grow chains which can never be merged. The last agreed
+// membership is the network recorded by the latest NETWORK block, and only a group reaching a strict
+// majority of it may write or change the membership. Peers in a minority turn read-only, and since they
+// cannot evict the other side either, the agreed membership stays the same on their side. Once the
+// partition heals, read-only peers fetch the chain of the majority, or rejoin it if they were evicted
+// meanwhile, before accepting writes again.
+
+// reachablePeers counts the members of the network which are not suspected by the failure detector,
+// including this peer.
+func (lp *Lightpeer) reachablePeers(network []pb.PeerInfo) int {
+	reachable := 1
+	for _, peer := range network {
+		if peer.Address != lp.Meta.Address && !lp.members().suspected(peer.Address) {
+			reachable++
+		}
+	}
+	return reachable
+}
+
+// checkQuorum fails if this peer does not reach a majority of the network.
+func (lp *Lightpeer) checkQuorum() error {
+	network := lp.GetNetwork()
+	if reachable := lp.reachablePeers(network); 2*reachable <= len(network) {
+		return status.Errorf(codes.FailedPrecondition, "%s reaches %d of %d peers, which is not a majority",
+			lp.Meta.Address, reachable, len(network))
+	}
+	return nil
+}
+
+// checkEvictionQuorum fails unless a majority of the network answered probes since the failed peers were
+// suspected. Peers in the minority of a partition suspect the other side one probe after the other, so they
+// must not evict the first suspected peers before noticing that the others are unreachable too.
+func (lp *Lightpeer) checkEvictionQuorum(failed []string) error {
+	network := lp.GetNetwork()
+	since := lp.members().suspectedSince(failed)
+	reachable := 1
+	for _, peer := range network {
+		if peer.Address != lp.Meta.Address && !contains(failed, peer.Address) && lp.members().lastSeen(peer.Address).After(since) {
+			reachable++
+		}
+	}
+	if 2*reachable <= len(network) {
+		return fmt.Errorf("%d of %d peers answered since %v were suspected, which is not a majority",
+			reachable, len(network), failed)
+	}
+	return nil
+}
+
+// updateWriteMode turns the peer read-only when it loses the majority, and accepts writes again once it
+// reaches the majority and caught up with its chain.
+func (lp *Lightpeer) updateWriteMode(ctx context.Context) {
+	span := trace.SpanFromContext(ctx)
+	if err := lp.checkQuorum(); err != nil {
+		if lp.members().setReadOnly(true) {
+			span.AddEvent(ctx, fmt.Sprintf("refusing writes: %v", err))
+		}
+		return
+	}
+	if !lp.members().readOnly() {
+		return
+	}
+
+	if err := lp.catchUp(ctx); err != nil {
+		span.AddEvent(ctx, fmt.Sprintf("could not catch up with the network: %v", err))
+		return
+	}
+	lp.members().setReadOnly(false)
+	span.AddEvent(ctx, "caught up with the network, accepting writes")
+}
+
+// catchUp pulls the blocks of a reachable member through anti-entropy. The peer is still a member, so it
+// is not admitted again. It did not write while it was read-only, so its chain is a prefix of the chain of
+// the majority, and any fork is replaced by the chain of the member.
+func (lp *Lightpeer) catchUp(ctx context.Context) error {
+	err := fmt.Errorf("no reachable peers")
+	for _, peer := range lp.GetNetwork() {
+		if peer.Address == lp.Meta.Address || lp.members().suspected(peer.Address) {
+			continue
+		}
+		if _, err = lp.syncChain(ctx, peer.Address, true); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// suspectedSince returns the earliest time at which one of the peers was suspected.
+func (m *membership) suspectedSince(addresses []string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	since := time.Now()
+	for _, address := range addresses {
+		if ms, ok := m.members[address]; ok && ms.status == pb.MemberState_SUSPECT && ms.suspectedAt.Before(since) {
+			since = ms.suspectedAt
+		}
+	}
+	return since
+}
+
+// setReadOnly records whether the peer refuses writes, returning whether this changed.
+func (m *membership) setReadOnly(readOnly bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := m.isReadOnly != readOnly
+	m.isReadOnly = readOnly
+	return changed
+}
+
+func (m *membership) readOnly() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.isReadOnly
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"fmt"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"go.opentelemetry.io/otel/api/trace"
+)
+
+// Evicted peers are not notified about the NETWORK block removing them, so they keep their stale view of
+// the network. Probe responses carry the membership of the peer answering, together with the height of
+// its latest NETWORK block. A peer learns that it was removed when a peer with a newer view of the network
+// does not list it. Until it rejoins, the peer refuses writes, which the network would reject anyway, and
+// its failure detector tries to rejoin through the members it knows instead of probing them.
+
+// detectExclusion checks whether the peer which answered a probe still counts this peer as a member.
+func (lp *Lightpeer) detectExclusion(ctx context.Context, address string, resp *pb.ProbeResponse) {
+	if len(resp.Members) == 0 || resp.NetworkHeight <= lp.getNetworkHeight() {
+		// peers which did not see the latest membership changes cannot tell
+		return
+	}
+	for _, member := range resp.Members {
+		if member.Address == lp.Meta.Address {
+			return
+		}
+	}
+
+	if lp.members().exclude(address) {
+		trace.SpanFromContext(ctx).AddEvent(ctx, fmt.Sprintf("removed from the network, according to %s", address))
+	}
+}
+
+// rejoin joins the network again through the peers which excluded this peer, or any other known member.
+func (lp *Lightpeer) rejoin(ctx context.Context) {
+	span := trace.SpanFromContext(ctx)
+	for _, address := range lp.members().rejoinCandidates(lp.GetNetwork(), lp.Meta.Address) {
+		_, err := lp.JoinNetwork(ctx, &pb.JoinRequest{Address: address, Token: lp.JoinToken})
+		if err != nil {
+			span.AddEvent(ctx, fmt.Sprintf("could not rejoin the network through %s: %v", address, err))
+			continue
+		}
+
+		lp.members().rejoined()
+		span.AddEvent(ctx, fmt.Sprintf("rejoined the network through %s", address))
+		return
+	}
+}
+
+func (lp *Lightpeer) getNetworkHeight() uint64 {
+	lp.stateLock.Lock()
+	defer lp.stateLock.Unlock()
+	return lp.networkHeight
+}
+
+// exclude records that the peer at the address no longer lists this peer, returning whether this peer
+// considered itself a member before.
+func (m *membership) exclude(address string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	wasMember := len(m.excludedBy) == 0
+	if !contains(m.excludedBy, address) {
+		m.excludedBy = append(m.excludedBy, address)
+	}
+	return wasMember
+}
+
+func (m *membership) excluded() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.excludedBy) > 0
+}
+
+// rejoined clears the exclusion. Rejoining fetches the chain of the network, so the peer also caught up.
+func (m *membership) rejoined() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.excludedBy = nil
+	m.isReadOnly = false
+}
+
+// rejoinCandidates returns the peers which excluded this peer, followed by the other known members.
+func (m *membership) rejoinCandidates(network []pb.PeerInfo, selfAddress string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	candidates := append([]string{}, m.excludedBy...)
+	for _, peer := range network {
+		if peer.Address != selfAddress && !contains(candidates, peer.Address) {
+			candidates = append(candidates, peer.Address)
+		}
+	}
+	return candidates
+}
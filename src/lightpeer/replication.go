@@ -0,0 +1,323 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	grpctrace "go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc"
+	"go.opentelemetry.io/otel/api/global"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultReplicationWindow is the number of blocks in flight on a replication stream when
+// Lightpeer.ReplicationWindow is not set.
+const DefaultReplicationWindow = 32
+
+// Blocks are replicated over one long lived Replicate stream per peer. Every block sent on the stream
+// gets a sequence number, and the receiving peer processes the blocks in order, acknowledging each of them.
+// The number of unacknowledged blocks is bounded by the replication window, which applies backpressure
+// to the writers when a peer falls behind.
+type replicationStream struct {
+	address string
+	conn    *grpc.ClientConn
+	stream  pb.Lightpeer_ReplicateClient
+	cancel  context.CancelFunc
+
+	sendLock sync.Mutex
+	nextSeq  uint64
+
+	mu      sync.Mutex
+	pending map[uint64]chan error
+	err     error
+
+	window chan struct{}
+	closed chan struct{}
+}
+
+// rejectedBlockError is returned when the peer received the block, but refused it.
+type rejectedBlockError struct {
+	address string
+	reason  string
+}
+
+func (e *rejectedBlockError) Error() string {
+	return fmt.Sprintf("%s rejected the block: %s", e.address, e.reason)
+}
+
+// missingParentError is returned when the peer holds the block back, because it did not receive its parent.
+type missingParentError struct {
+	address string
+	blockID string
+}
+
+func (e *missingParentError) Error() string {
+	return fmt.Sprintf("%s is missing block %s", e.address, e.blockID)
+}
+
+// isRejection tells whether the peer refused the block, as opposed to the peer not being reachable.
+func isRejection(err error) bool {
+	if _, ok := err.(*rejectedBlockError); ok {
+		return true
+	}
+	// errors which are not coming from the peer are connection failures
+	errStatus, ok := status.FromError(err)
+	return err != nil && ok && errStatus.Code() == codes.Unknown
+}
+
+// dial opens a traced client connection to another peer.
+func (lp *Lightpeer) dial(address string) (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(grpctrace.UnaryClientInterceptor(
+			global.Tracer(fmt.Sprintf("client@%s", address)))),
+		grpc.WithStreamInterceptor(grpctrace.StreamClientInterceptor(
+			global.Tracer(fmt.Sprintf("stream-client@%s", address))))}
+	return grpc.Dial(address, append(opts, lp.DialOptions...)...)
+}
+
+// replicate sends the block to the peer and waits for its acknowledgement.
+func (lp *Lightpeer) replicate(ctx context.Context, address string, block pb.Lightblock) error {
+	rs, err := lp.replicationStream(address)
+	if err != nil {
+		return err
+	}
+
+	err = rs.send(ctx, block)
+	if status.Code(err) == codes.Unimplemented {
+		// the peer does not support replication streams, fall back to notifying it about every block.
+		return lp.notifyNewBlock(ctx, address, block)
+	}
+	return err
+}
+
+func (lp *Lightpeer) notifyNewBlock(ctx context.Context, address string, block pb.Lightblock) error {
+	conn, err := lp.dial(address)
+	if err != nil {
+		return fmt.Errorf("did not connect: %s", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewLightpeerClient(conn)
+	newBlock := &pb.Lightblock{}
+	*newBlock = block
+	_, err = client.NotifyNewBlock(ctx, newBlock)
+	return err
+}
+
+func (lp *Lightpeer) replicationStream(address string) (*replicationStream, error) {
+	lp.replicasLock.Lock()
+	defer lp.replicasLock.Unlock()
+
+	if rs, ok := lp.replicas[address]; ok {
+		return rs, nil
+	}
+
+	conn, err := lp.dial(address)
+	if err != nil {
+		return nil, fmt.Errorf("did not connect: %s", err)
+	}
+
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stream, err := pb.NewLightpeerClient(conn).Replicate(streamCtx)
+	if err != nil {
+		cancel()
+		conn.Close()
+		return nil, err
+	}
+
+	window := lp.ReplicationWindow
+	if window <= 0 {
+		window = DefaultReplicationWindow
+	}
+
+	rs := &replicationStream{
+		address: address,
+		conn:    conn,
+		stream:  stream,
+		cancel:  cancel,
+		pending: map[uint64]chan error{},
+		window:  make(chan struct{}, window),
+		closed:  make(chan struct{}),
+	}
+	if lp.replicas == nil {
+		lp.replicas = map[string]*replicationStream{}
+	}
+	lp.replicas[address] = rs
+
+	go func() {
+		err := rs.receiveAcks()
+		lp.replicasLock.Lock()
+		if lp.replicas[address] == rs {
+			delete(lp.replicas, address)
+		}
+		lp.replicasLock.Unlock()
+		rs.close(err)
+	}()
+
+	return rs, nil
+}
+
+// closeReplicationStreams closes the streams to peers which are no longer part of the network.
+func (lp *Lightpeer) closeReplicationStreams(network []pb.PeerInfo) {
+	lp.replicasLock.Lock()
+	defer lp.replicasLock.Unlock()
+
+	for address, rs := range lp.replicas {
+		if containsAddress(network, address) {
+			continue
+		}
+		delete(lp.replicas, address)
+		rs.close(fmt.Errorf("peer %s left the network", address))
+	}
+}
+
+func containsAddress(network []pb.PeerInfo, address string) bool {
+	for _, peer := range network {
+		if peer.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *replicationStream) send(ctx context.Context, block pb.Lightblock) error {
+	select {
+	case rs.window <- struct{}{}:
+	case <-rs.closed:
+		return rs.closeError()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ack := make(chan error, 1)
+
+	rs.sendLock.Lock()
+	rs.nextSeq++
+	seq := rs.nextSeq
+
+	rs.mu.Lock()
+	if rs.err != nil {
+		rs.mu.Unlock()
+		rs.sendLock.Unlock()
+		return rs.err
+	}
+	rs.pending[seq] = ack
+	rs.mu.Unlock()
+
+	newBlock := &pb.Lightblock{}
+	*newBlock = block
+	// a failed send breaks the stream, and the pending acknowledgement is failed with
+	// the stream error once the receiving side notices it.
+	_ = rs.stream.Send(&pb.ReplicationMessage{Sequence: seq, Block: newBlock})
+	rs.sendLock.Unlock()
+
+	select {
+	case err := <-ack:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (rs *replicationStream) receiveAcks() error {
+	for {
+		ack, err := rs.stream.Recv()
+		if err == io.EOF {
+			return fmt.Errorf("replication stream to %s closed", rs.address)
+		}
+		if err != nil {
+			return err
+		}
+
+		rs.mu.Lock()
+		result, ok := rs.pending[ack.Sequence]
+		delete(rs.pending, ack.Sequence)
+		rs.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		<-rs.window
+		if ack.Error != "" {
+			result <- &rejectedBlockError{rs.address, ack.Error}
+			continue
+		}
+		if ack.MissingBlockID != "" {
+			result <- &missingParentError{rs.address, ack.MissingBlockID}
+			continue
+		}
+		result <- nil
+	}
+}
+
+func (rs *replicationStream) closeError() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.err
+}
+
+// close fails all the blocks waiting for an acknowledgement and releases the connection.
+func (rs *replicationStream) close(err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.err != nil {
+		return
+	}
+	if err == nil {
+		err = fmt.Errorf("replication stream to %s closed", rs.address)
+	}
+
+	rs.err = err
+	for seq, result := range rs.pending {
+		result <- err
+		delete(rs.pending, seq)
+	}
+	close(rs.closed)
+	rs.cancel()
+	rs.conn.Close()
+}
+
+// Replicate receives blocks from another peer, in the order they were sent, and acknowledges them.
+func (lp *Lightpeer) Replicate(stream pb.Lightpeer_ReplicateServer) error {
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		ack := &pb.ReplicationAck{Sequence: msg.Sequence}
+		if msg.Block == nil {
+			ack.Error = "replication message has no block"
+		} else if missing, err := lp.receiveBlock(stream.Context(), *msg.Block); err != nil {
+			ack.Error = err.Error()
+		} else {
+			ack.MissingBlockID = missing
+		}
+
+		err = stream.Send(ack)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+	"google.golang.org/grpc"
+)
+
+// ShutdownTimeout bounds leaving the network and stopping the server on termination.
+const ShutdownTimeout = 10 * time.Second
+
+// LeaveOnTerminate leaves the network before stopping the server when the process is terminated,
+// such that restarting the peer does not trigger an eviction. Background tasks such as discovery are
+// stopped first, so the peer does not join the network again.
+func LeaveOnTerminate(grpcServer *grpc.Server, lp pb.LightpeerServer, stopBackground context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		stopBackground()
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+
+		log.Println("Leaving the network")
+		if _, err := lp.LeaveNetwork(ctx, &pb.LeaveRequest{}); err != nil {
+			log.Printf("failed to leave the network: %v", err)
+		}
+
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
+	}()
+}
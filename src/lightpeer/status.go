@@ -0,0 +1,132 @@
+// Copyright 2020 Stefan Prisca
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightpeer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	pb "github.com/stefanprisca/lightchain/src/api/lightpeer"
+)
+
+// Version of the lightpeer, reported by GetStatus. Release builds set it with
+// -ldflags "-X github.com/stefanprisca/lightchain/src/lightpeer.Version=<version>".
+var Version = "v0.1.0"
+
+// GetStatus returns the head of the chain, the storage usage and the view of the network of the peer.
+func (lp *Lightpeer) GetStatus(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+
+	statusCtx, span := lp.Tracer.Start(ctx, fmt.Sprintf("@%s - status", lp.Meta.Address))
+	defer span.End()
+
+	head := lp.GetState()
+	height, err := lp.height(head)
+	if err != nil {
+		err = fmt.Errorf("could not compute the chain height: %v", err)
+		span.RecordError(statusCtx, err)
+		return nil, err
+	}
+
+	storageBytes, err := lp.storageUsage()
+	if err != nil {
+		err = fmt.Errorf("could not compute the storage usage: %v", err)
+		span.RecordError(statusCtx, err)
+		return nil, err
+	}
+
+	peer := &pb.PeerInfo{}
+	*peer = lp.Meta
+	return &pb.StatusResponse{
+		Peer:         peer,
+		Version:      Version,
+		HeadID:       head.ID,
+		Height:       height,
+		StorageBytes: storageBytes,
+		Coordinator:  lp.coordinator().Address,
+		Members:      lp.memberStatuses(),
+		NetworkID:    lp.networkID(),
+	}, nil
+}
+
+// height returns the height of the block. Blocks created before heights were recorded have a zero height,
+// and their height is taken from the block index, or computed by walking the chain.
+func (lp *Lightpeer) height(block pb.Lightblock) (uint64, error) {
+	if block.ID == "" || block.Height > 0 {
+		return block.Height, nil
+	}
+	if height, ok := lp.index().heightOf(block.ID); ok {
+		return height, nil
+	}
+
+	height := uint64(0)
+	for blockID := block.ID; blockID != ""; height++ {
+		b, err := lp.loadBlock(blockID, 0)
+		if err != nil {
+			return 0, err
+		}
+		if b.Height > 0 {
+			return height + b.Height, nil
+		}
+		blockID = b.PrevID
+	}
+	return height, nil
+}
+
+func (lp *Lightpeer) storageUsage() (int64, error) {
+	files, err := ioutil.ReadDir(lp.StoragePath)
+	if err != nil {
+		return 0, err
+	}
+
+	size := int64(0)
+	for _, f := range files {
+		if f.Mode().IsRegular() {
+			size += f.Size()
+		}
+	}
+	return size, nil
+}
+
+func (lp *Lightpeer) memberStatuses() []*pb.PeerStatus {
+	queueDepths := lp.OutboundQueueDepth()
+	// the network is read once, such that the states line up with the members
+	network := lp.GetNetwork()
+	states := lp.members().snapshot(network, lp.Meta.Address)
+
+	statuses := []*pb.PeerStatus{}
+	for i, peer := range network {
+		peerInfo := &pb.PeerInfo{}
+		*peerInfo = peer
+		status := &pb.PeerStatus{
+			Peer:               peerInfo,
+			Status:             states[i].Status,
+			Incarnation:        states[i].Incarnation,
+			OutboundQueueDepth: int32(queueDepths[peer.Address]),
+		}
+
+		lastSeen := lp.members().lastSeen(peer.Address)
+		if peer.Address == lp.Meta.Address {
+			lastSeen = time.Now()
+		}
+		if !lastSeen.IsZero() {
+			status.LastSeen, _ = ptypes.TimestampProto(lastSeen)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
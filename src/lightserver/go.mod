@@ -2,7 +2,11 @@ module github.com/stefanprisca/lightchain/src/lightserver
 
 go 1.15
 
-replace go.opentelemetry.io/otel => go.opentelemetry.io/otel v0.11.0
+replace (
+	github.com/stefanprisca/lightchain => ../../
+	github.com/stefanprisca/lightchain/src/lightpeer => ../lightpeer
+	go.opentelemetry.io/otel => go.opentelemetry.io/otel v0.11.0
+)
 
 require (
 	github.com/google/uuid v1.1.2
@@ -15,10 +15,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/api/global"
 
 	lpack "github.com/stefanprisca/lightchain/src/lightpeer"
 )
@@ -29,8 +36,61 @@ func main() {
 	var otlpBackend = flag.String("otlp", lpack.OTLPAddress, "backend address for otlp traces and metrics")
 	var host = flag.String("host", "", "the host to listen to")
 	var port = flag.Int("port", 9081, "the port")
+	var advertise = flag.String("advertise", "", "address advertised to the other peers, as host or host:port, defaults to the address of -interface")
+	var iface = flag.String("interface", "", "network interface whose address is advertised, defaults to the -host address or the first interface which is up")
+	var batchWindow = flag.Duration("batchWindow", 0, "group commit window for packing persist requests into one block, 0 disables batching")
+	var batchSize = flag.Int("batchSize", lpack.DefaultMaxBatchSize, "maximum number of persist requests packed into one block")
+	var gossipFanout = flag.Int("gossipFanout", 0, "number of random peers receiving each new block, 0 broadcasts new blocks to all peers")
+	var probeInterval = flag.Duration("probeInterval", lpack.DefaultProbeInterval, "interval between two failure detector probes")
+	var probeTimeout = flag.Duration("probeTimeout", lpack.DefaultProbeTimeout, "time to wait for a peer to answer a probe")
+	var indirectProbes = flag.Int("indirectProbes", lpack.DefaultIndirectProbes, "number of peers asked to probe a peer which did not answer")
+	var suspicionTimeout = flag.Duration("suspicionTimeout", lpack.DefaultSuspicionTimeout, "time a peer is suspected before being removed from the network")
+	var networkID = flag.String("networkID", "", "the network of the peer, blocks and peers from other networks are rejected")
+	var genesis = flag.Bool("genesis", false, "start a new network by creating its genesis block")
+	var genesisConfig = flag.String("genesisConfig", "", "comma separated key=value pairs recorded in the genesis block")
+	var requireGenesis = flag.Bool("requireGenesis", false, "refuse writes until the chain starts with a genesis block")
+	var joinSecret = flag.String("joinSecret", "", "shared secret for signing join tokens, peers joining without a valid token are refused")
+	var joinAllowlist = flag.String("joinAllowlist", "", "comma separated IPs or CIDRs peers may join from, empty allows all addresses")
+	var joinToken = flag.String("joinToken", "", "join token presented when the peer rejoins the network after being removed from it")
+	var seeds = flag.String("seeds", "", "comma separated addresses of peers to join at startup")
+	var discoveryDNS = flag.String("discoveryDNS", "", "DNS name resolving to the peers to join at startup, through SRV or A records")
+	var discoveryInterval = flag.Duration("discoveryInterval", lpack.DefaultDiscoveryInterval, "interval between looking for peers to join while the peer is alone")
+	var antiEntropyInterval = flag.Duration("antiEntropyInterval", lpack.DefaultAntiEntropyInterval, "interval between comparing the chain with a random peer and repairing the differences, 0 disables anti-entropy")
+	var consistencyInterval = flag.Duration("consistencyInterval", lpack.DefaultConsistencyInterval, "interval between comparing the chain with the chain of every peer, 0 disables the consistency checks")
+	var autoResync = flag.Bool("autoResync", false, "replace the chain with the chain of the majority when it diverged")
+	var restore = flag.Bool("restore", true, "restore the chain stored in -repo at startup, repairing damaged blocks from the stored network")
+	var newJoinToken = flag.Duration("newJoinToken", 0, "print a join token valid for the given duration and exit, requires -joinSecret")
+	var exportFile = flag.String("export", "", "write an archive of the chain stored in -repo to the file and exit")
+	var exportFrom = flag.Uint64("exportFrom", 1, "height of the first block written by -export")
+	var exportTo = flag.Uint64("exportTo", 0, "height of the last block written by -export, 0 exports up to the head")
+	var exportGzip = flag.Bool("exportGzip", false, "gzip the archive written by -export")
+	var importFile = flag.String("import", "", "add the blocks of the archive to the chain stored in -repo and exit, the peer uses them once restarted")
 	flag.Parse()
 
+	if *newJoinToken > 0 {
+		if *joinSecret == "" {
+			log.Fatalf("-newJoinToken requires -joinSecret")
+		}
+		fmt.Println(lpack.NewJoinToken([]byte(*joinSecret), time.Now().Add(*newJoinToken)))
+		return
+	}
+	if *exportFile != "" {
+		if err := exportArchive(*blockRepo, *exportFile, *exportFrom, *exportTo, *exportGzip); err != nil {
+			log.Fatalf("failed to export the chain: %v", err)
+		}
+		return
+	}
+	if *importFile != "" {
+		if err := importArchive(*blockRepo, *importFile); err != nil {
+			log.Fatalf("failed to import the chain: %v", err)
+		}
+		return
+	}
+	joinPolicy, err := newJoinPolicy(*joinSecret, *joinAllowlist)
+	if err != nil {
+		log.Fatalf("invalid join policy: %v", err)
+	}
+
 	log.Printf("Starting the lightpeer with options: v: %v ; repo: %s ; otlp: %s\n",
 		*verbose, *blockRepo, *otlpBackend)
 
@@ -38,32 +98,172 @@ func main() {
 		otelFinalizer := lpack.InitOtel(*otlpBackend, lpack.ServiceName)
 		defer otelFinalizer()
 	}
-	listenerAddress := fmt.Sprintf("%s:%d", *host, *port)
+	listenerAddress := net.JoinHostPort(*host, strconv.Itoa(*port))
 	lis, err := net.Listen("tcp", listenerAddress)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	localIp, err := getLocalIP()
+	peerAddress, err := lpack.AdvertiseAddress(*advertise, *iface, *host, *port)
 	if err != nil {
-		log.Fatalf("failed to get ip: %v", err)
+		log.Fatalf("failed to get the advertise address: %v", err)
+	}
+	peerHost, rawPeerPort, _ := net.SplitHostPort(peerAddress)
+	peerPort, err := strconv.Atoi(rawPeerPort)
+	if err != nil {
+		log.Fatalf("invalid advertise port: %v", err)
 	}
 
-	grpcServer, _, nhc := NewLPGrpcServer(localIp, *port, *blockRepo)
+	grpcServer, lp, nhc, err := NewLPGrpcServer(peerHost, peerPort, *blockRepo,
+		WithGroupCommit(*batchWindow, *batchSize),
+		WithGossip(*gossipFanout),
+		WithFailureDetection(*probeInterval, *probeTimeout, *suspicionTimeout, *indirectProbes),
+		WithNetwork(*networkID, *requireGenesis),
+		WithJoinPolicy(joinPolicy),
+		WithJoinToken(*joinToken),
+		WithAutoResync(*autoResync))
+	if err != nil {
+		log.Fatalf("failed to create the lightpeer: %v", err)
+	}
 	defer nhc.StopPeerHealthCheck()
+	defer lp.StopGossip()
+	defer lp.StopGroupCommit()
+
+	if *restore {
+		if err := lp.RestoreChain(context.Background()); err != nil {
+			log.Printf("warning: could not restore the chain, starting with an empty one: %v", err)
+		}
+	}
+	if *genesis && lp.GetState().ID == "" {
+		config, err := parseConfig(*genesisConfig)
+		if err != nil {
+			log.Fatalf("invalid genesis config: %v", err)
+		}
+		createdID, err := lp.CreateGenesis(context.Background(), config)
+		if err != nil {
+			log.Fatalf("failed to create the genesis block: %v", err)
+		}
+		log.Println("Created network ", createdID)
+	}
+
+	// the background loops start from the restored chain, or from the new network
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if discoverer := newDiscoverer(*seeds, *discoveryDNS, *port); discoverer != nil {
+		go lp.AutoJoin(backgroundCtx, discoverer, *discoveryInterval)
+	}
+	if *antiEntropyInterval > 0 {
+		go lp.AntiEntropy(backgroundCtx, *antiEntropyInterval)
+	}
+	if *consistencyInterval > 0 {
+		go lp.MonitorConsistency(backgroundCtx, *consistencyInterval)
+	}
+
+	lpack.LeaveOnTerminate(grpcServer, lp, stopBackground)
 	log.Println("Start serving gRPC connections @ ", listenerAddress)
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
 
-func getLocalIP() (string, error) {
-	conn, err := net.Dial("udp", "8.8.8.8:80")
+// newDiscoverer combines the seed list and the DNS discovery which are enabled.
+func newDiscoverer(seeds, discoveryDNS string, port int) lpack.Discoverer {
+	discoverers := lpack.Discoverers{}
+	if seeds != "" {
+		discoverers = append(discoverers, lpack.StaticSeeds(strings.Split(seeds, ",")))
+	}
+	if discoveryDNS != "" {
+		discoverers = append(discoverers, &lpack.DNSDiscoverer{Name: discoveryDNS, Port: port})
+	}
+	if len(discoverers) == 0 {
+		return nil
+	}
+	return discoverers
+}
+
+// newJoinPolicy combines the join token and allowlist checks which are enabled.
+func newJoinPolicy(joinSecret, joinAllowlist string) (lpack.JoinPolicy, error) {
+	policies := []lpack.JoinPolicy{}
+	if joinSecret != "" {
+		policies = append(policies, &lpack.TokenPolicy{Secret: []byte(joinSecret)})
+	}
+	if joinAllowlist != "" {
+		allowlist, err := lpack.NewAllowlistPolicy(strings.Split(joinAllowlist, ","))
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, allowlist)
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	return lpack.AllOf(policies...), nil
+}
+
+// parseConfig reads comma separated key=value pairs.
+func parseConfig(rawConfig string) (map[string]string, error) {
+	config := map[string]string{}
+	if rawConfig == "" {
+		return config, nil
+	}
+	for _, pair := range strings.Split(rawConfig, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		config[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return config, nil
+}
+
+// storedPeer loads the chain stored in the repo, for working on it while the peer is stopped.
+func storedPeer(blockRepo string) (*lpack.Lightpeer, error) {
+	lp := &lpack.Lightpeer{
+		StoragePath: blockRepo,
+		Tracer:      global.Tracer(lpack.ServiceName),
+	}
+	if err := lp.RestoreChain(context.Background()); err != nil {
+		return nil, err
+	}
+	return lp, nil
+}
+
+func exportArchive(blockRepo, exportFile string, from, to uint64, compress bool) error {
+	lp, err := storedPeer(blockRepo)
 	if err != nil {
-		return "", err
+		return err
 	}
+	out, err := os.Create(exportFile)
+	if err != nil {
+		return err
+	}
+	manifest, err := lp.ExportChain(out, from, to, compress)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(exportFile)
+		return err
+	}
+	log.Printf("Exported %d blocks, from height %d to %d, into %s", manifest.Blocks, manifest.From, manifest.To, exportFile)
+	return nil
+}
+
+func importArchive(blockRepo, importFile string) error {
+	lp, err := storedPeer(blockRepo)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(importFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
 
-	defer conn.Close()
-	localAddr := conn.LocalAddr().(*net.UDPAddr).IP
-	return fmt.Sprintf("%v", localAddr), nil
+	manifest, imported, err := lp.ImportChain(in)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %d of the %d blocks of %s, the chain ends with %s", imported, manifest.Blocks, importFile, lp.GetState().ID)
+	return nil
 }
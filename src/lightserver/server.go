@@ -15,7 +15,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"strconv"
+	"time"
 
 	"google.golang.org/grpc"
 
@@ -26,14 +30,80 @@ import (
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-func NewLPGrpcServer(host string, port int, blockRepo string) (*grpc.Server, *lpack.Lightpeer, lpack.NetworkHealthChecker) {
-	peerAddress := fmt.Sprintf("%s:%d", host, port)
+// PeerOption configures the lightpeer before it starts serving requests.
+type PeerOption func(*lpack.Lightpeer)
+
+// WithGroupCommit packs the persist requests arriving within the batch window into a single block.
+func WithGroupCommit(batchWindow time.Duration, maxBatchSize int) PeerOption {
+	return func(lp *lpack.Lightpeer) {
+		lp.BatchWindow = batchWindow
+		lp.MaxBatchSize = maxBatchSize
+	}
+}
+
+// WithGossip disseminates new blocks through gossip, sending them to fanout random peers.
+func WithGossip(fanout int) PeerOption {
+	return func(lp *lpack.Lightpeer) {
+		lp.GossipFanout = fanout
+	}
+}
+
+// WithFailureDetection configures how often peers are probed, and how long failing peers are suspected before
+// being removed from the network.
+func WithFailureDetection(probeInterval, probeTimeout, suspicionTimeout time.Duration, indirectProbes int) PeerOption {
+	return func(lp *lpack.Lightpeer) {
+		lp.ProbeInterval = probeInterval
+		lp.ProbeTimeout = probeTimeout
+		lp.SuspicionTimeout = suspicionTimeout
+		lp.IndirectProbes = indirectProbes
+	}
+}
+
+// WithNetwork restricts the peer to the given network. Peers requiring a genesis refuse writes until
+// the chain starts with a genesis block.
+func WithNetwork(networkID string, requireGenesis bool) PeerOption {
+	return func(lp *lpack.Lightpeer) {
+		lp.NetworkID = networkID
+		lp.RequireGenesis = requireGenesis
+	}
+}
+
+// WithJoinPolicy admits only the peers accepted by the policy into the network.
+func WithJoinPolicy(policy lpack.JoinPolicy) PeerOption {
+	return func(lp *lpack.Lightpeer) {
+		lp.JoinPolicy = policy
+	}
+}
+
+// WithJoinToken sets the token presented by the peer when it rejoins the network after being removed.
+func WithJoinToken(token string) PeerOption {
+	return func(lp *lpack.Lightpeer) {
+		lp.JoinToken = token
+	}
+}
+
+// WithAutoResync replaces the chain of the peer with the chain of the majority when it diverged.
+func WithAutoResync(autoResync bool) PeerOption {
+	return func(lp *lpack.Lightpeer) {
+		lp.AutoResync = autoResync
+	}
+}
+
+// NewLPGrpcServer creates the lightpeer storing its blocks in blockRepo, and the gRPC server serving it.
+// It fails if the identity of the peer cannot be loaded from blockRepo.
+func NewLPGrpcServer(host string, port int, blockRepo string, opts ...PeerOption) (*grpc.Server, *lpack.Lightpeer, *lpack.NetworkHealthChecker, error) {
+	nodeID, err := lpack.NodeID(blockRepo)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load the node ID: %v", err)
+	}
+
+	peerAddress := net.JoinHostPort(host, strconv.Itoa(port))
 	tr := global.Tracer(fmt.Sprintf("%s-server@%s", lpack.ServiceName, peerAddress))
 	grpcServer := grpc.NewServer(
 		grpc.UnaryInterceptor(grpctrace.UnaryServerInterceptor(tr)),
 		grpc.StreamInterceptor(grpctrace.StreamServerInterceptor(tr)))
 
-	meta := pb.PeerInfo{Address: peerAddress}
+	meta := pb.PeerInfo{Address: peerAddress, ID: nodeID}
 
 	lp := &lpack.Lightpeer{
 		Tracer:      tr,
@@ -41,11 +111,14 @@ func NewLPGrpcServer(host string, port int, blockRepo string) (*grpc.Server, *lp
 		Meta:        meta,
 		Network:     []pb.PeerInfo{meta},
 	}
+	for _, opt := range opts {
+		opt(lp)
+	}
 
 	pb.RegisterLightpeerServer(grpcServer, lp)
 	healthpb.RegisterHealthServer(grpcServer, lp)
 
-	nhc := lpack.NetworkHealthChecker{Lp: lp}
-	nhc.StartPeerHealthCheck()
-	return grpcServer, lp, nhc
+	nhc := &lpack.NetworkHealthChecker{Lp: lp}
+	nhc.StartPeerHealthCheck(context.Background())
+	return grpcServer, lp, nhc, nil
 }
@@ -57,6 +57,18 @@ func TestConnectUpdatesMessages(t *testing.T) {
 		assertExpectedMessages(msg)
 }
 
+func TestPersistBatchIsReplicated(t *testing.T) {
+	tn := newTestNetwork(t)
+	defer tn.stop()
+
+	tn.startLPServer(8090).
+		startLPServer(8091).
+		connect(8091, 8090).
+		persist(8090, "before").
+		persistBatch(8091, "debit", "credit").
+		assertExpectedMessages("credit", "debit", "before")
+}
+
 func TestConnectUpdatesTopology(t *testing.T) {
 	tn := newTestNetwork(t) //.withOTLP(OTLPAddress, "TestConnectUpdatesTopology")
 	defer tn.stop()
@@ -89,6 +101,28 @@ func TestThreePeerNetworkUpdatesMessages(t *testing.T) {
 		assertExpectedMessages("8081#3", "8082#3", "8083#2", "8083", "8082#2", "8081#2", "8082", "8081")
 }
 
+func TestBurstOfWritesIsReplicatedInOrder(t *testing.T) {
+	tn := newTestNetwork(t)
+	defer tn.stop()
+
+	messages := []string{}
+	for i := 0; i < 50; i++ {
+		messages = append(messages, fmt.Sprintf("8081#%d", i))
+	}
+	expectedMessages := []string{}
+	for i := len(messages) - 1; i >= 0; i-- {
+		expectedMessages = append(expectedMessages, messages[i])
+	}
+
+	tn.startLPServer(8081).
+		startLPServer(8082).
+		connect(8082, 8081).
+		startLPServer(8083).
+		connect(8083, 8081).
+		persist(8081, messages...).
+		assertExpectedMessages(expectedMessages...)
+}
+
 // Test fails because peers don't notify new blocks yet
 func TestThreePeerNetworkUpdatesTopology(t *testing.T) {
 	tn := newTestNetwork(t) //.withOTLP(OTLPAddress, "TestThreePeerNetworkUpdatesTopology")
@@ -157,7 +191,8 @@ func TestNotifyRecoversAfterStateMismatch(t *testing.T) {
 }
 
 func TestNetworkRecoversAfterPeerFailure(t *testing.T) {
-	tn := newTestNetwork(t) //.withOTLP(OTLPAddress, "TestThreePeerNetworkUpdatesTopology")
+	fastDetection := WithFailureDetection(100*time.Millisecond, 100*time.Millisecond, 300*time.Millisecond, 1)
+	tn := newTestNetwork(t).withPeerOptions(fastDetection) //.withOTLP(OTLPAddress, "TestThreePeerNetworkUpdatesTopology")
 	defer tn.stop()
 
 	tn.startLPServer(8091)
@@ -180,6 +215,48 @@ func TestNetworkRecoversAfterPeerFailure(t *testing.T) {
 		assertExpectedMessages("8091")
 }
 
+func TestPeerLeavesNetwork(t *testing.T) {
+	tn := newTestNetwork(t)
+	defer tn.stop()
+
+	tn.startLPServer(8094).
+		startLPServer(8095).
+		connect(8095, 8094).
+		startLPServer(8096).
+		connect(8096, 8095).
+		leave(8095).
+		stopLPServer(8095).
+		assertNetworkTopology(8094, 8096).
+		persist(8096, "8096").
+		assertExpectedMessages("8096").
+		startLPServer(8095).
+		connect(8095, 8096).
+		assertNetworkTopology(8094, 8096, 8095).
+		assertExpectedMessages("8096")
+}
+
+func TestStatusReportsPeerState(t *testing.T) {
+	tn := newTestNetwork(t)
+	defer tn.stop()
+
+	tn.startLPServer(8097).
+		startLPServer(8098).
+		connect(8098, 8097).
+		persist(8098, "8098", "again")
+
+	tc := tn.clients[8098]
+	status, err := tc.client.GetStatus(getClientContext(tc), &pb.StatusRequest{})
+	require.NoError(t, err)
+
+	require.Equal(t, tc.lp.Meta.Address, status.Peer.Address)
+	require.Equal(t, tc.lp.GetState().ID, status.HeadID)
+	// the network block adding 8098, followed by the two client blocks
+	require.Equal(t, uint64(3), status.Height)
+	require.Equal(t, tn.clients[8097].lp.Meta.Address, status.Coordinator)
+	require.Len(t, status.Members, 2)
+	require.Greater(t, status.StorageBytes, int64(0))
+}
+
 func TestPeerSelfRecovery(t *testing.T) {
 	// not implemented yet
 	t.Skip()
@@ -209,14 +286,48 @@ func TestPeerSelfRecovery(t *testing.T) {
 		assertExpectedMessages("8091")
 }
 
+func BenchmarkPersist(b *testing.B) {
+	benchmarkConcurrentPersist(b)
+}
+
+func BenchmarkPersistGroupCommit(b *testing.B) {
+	benchmarkConcurrentPersist(b, WithGroupCommit(5*time.Millisecond, lpack.DefaultMaxBatchSize))
+}
+
+// benchmarkConcurrentPersist measures the persist throughput of a three peer network with concurrent clients.
+func benchmarkConcurrentPersist(b *testing.B, opts ...PeerOption) {
+	tn := newTestNetwork(b).withPeerOptions(opts...)
+	defer tn.stop()
+
+	tn.startLPServer(8181).
+		startLPServer(8182).
+		connect(8182, 8181).
+		startLPServer(8183).
+		connect(8183, 8181)
+
+	tc := tn.clients[8181]
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		ctx := getClientContext(tc)
+		for p.Next() {
+			_, err := tc.client.Persist(ctx, &pb.PersistRequest{Payload: []byte("benchmark")})
+			if err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
 type testNetwork struct {
-	test            *testing.T
+	test            testing.TB
 	clients         map[int]testClient
+	peerOptions     []PeerOption
 	otelFinalizer   func() error
 	ignoreNextError bool
 }
 
-func newTestNetwork(test *testing.T) *testNetwork {
+func newTestNetwork(test testing.TB) *testNetwork {
 	return &testNetwork{
 		test:          test,
 		clients:       make(map[int]testClient),
@@ -230,8 +341,13 @@ func (tn *testNetwork) withOTLP(otlpBackend, serviceName string) *testNetwork {
 	return tn
 }
 
+func (tn *testNetwork) withPeerOptions(opts ...PeerOption) *testNetwork {
+	tn.peerOptions = opts
+	return tn
+}
+
 func (tn *testNetwork) startLPServer(port int) *testNetwork {
-	tc, err := startLPTestServer(port)
+	tc, err := startLPTestServer(port, tn.peerOptions...)
 	tn.handleError("%v", err)
 	tn.clients[port] = tc
 	// sleep a bit to give the gRPC server a chance to start
@@ -265,6 +381,23 @@ func (tn *testNetwork) persist(port int, messages ...string) *testNetwork {
 
 	return tn
 }
+func (tn *testNetwork) persistBatch(port int, messages ...string) *testNetwork {
+	tc := tn.clients[port]
+	ctx := getClientContext(tc)
+	traceID := fmt.Sprintf("persistBatch@client%d", port)
+	persistCtx, span := global.Tracer(traceID).Start(ctx, traceID)
+	defer span.End()
+
+	batchReq := &pb.PersistBatchRequest{}
+	for _, msg := range messages {
+		batchReq.Payloads = append(batchReq.Payloads, []byte(msg))
+	}
+	_, err := tc.client.PersistBatch(persistCtx, batchReq)
+	tn.handleError("%v", err)
+
+	return tn
+}
+
 func (tn *testNetwork) connect(port, toPort int) *testNetwork {
 	tc := tn.clients[port]
 	ctx := getClientContext(tc)
@@ -284,6 +417,19 @@ func (tn *testNetwork) connect(port, toPort int) *testNetwork {
 	return tn
 }
 
+func (tn *testNetwork) leave(port int) *testNetwork {
+	tc := tn.clients[port]
+	ctx := getClientContext(tc)
+	traceID := fmt.Sprintf("leave@client%d", port)
+	leaveCtx, span := global.Tracer(traceID).Start(ctx, traceID)
+	defer span.End()
+
+	_, err := tc.client.LeaveNetwork(leaveCtx, &pb.LeaveRequest{})
+	tn.handleError("%v", err)
+
+	return tn
+}
+
 func (tn *testNetwork) notifyNewBlock(port int, block pb.Lightblock) *testNetwork {
 	tc := tn.clients[port]
 	ctx := getClientContext(tc)
@@ -410,7 +556,7 @@ type testClient struct {
 	stop   func() error
 }
 
-func startLPTestServer(port int) (testClient, error) {
+func startLPTestServer(port int, opts ...PeerOption) (testClient, error) {
 
 	blockRepoPath := fmt.Sprintf("./testdata/%d", port)
 	os.MkdirAll(blockRepoPath, 0777)
@@ -420,7 +566,11 @@ func startLPTestServer(port int) (testClient, error) {
 		return testClient{}, fmt.Errorf("failed to listen: %v", err)
 	}
 
-	grpcServer, lp, nhc := NewLPGrpcServer("", port, blockRepoPath)
+	grpcServer, lp, nhc, err := NewLPGrpcServer("", port, blockRepoPath, opts...)
+	if err != nil {
+		lis.Close()
+		return testClient{}, err
+	}
 
 	go func() {
 		if err := grpcServer.Serve(lis); err != nil {